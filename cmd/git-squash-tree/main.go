@@ -1,41 +1,128 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"time"
 
-	"squash-tree/internal/git"
-	"squash-tree/internal/githooks"
-	"squash-tree/internal/metadata"
-	"squash-tree/internal/repo"
-	"squash-tree/internal/tree"
+	"github.com/widefix/squash-tree/internal/backend"
+	"github.com/widefix/squash-tree/internal/browse"
+	"github.com/widefix/squash-tree/internal/git"
+	"github.com/widefix/squash-tree/internal/githooks"
+	"github.com/widefix/squash-tree/internal/metadata"
+	"github.com/widefix/squash-tree/internal/repo"
+	"github.com/widefix/squash-tree/internal/report"
+	"github.com/widefix/squash-tree/internal/tree"
 )
 
+// defaultHookTimeout bounds hook invocations when squashTree.timeout is not set, so a
+// hung git process never blocks the user's merge or rebase.
+const defaultHookTimeout = 10 * time.Second
+
 func main() {
-	if len(os.Args) < 2 {
+	args := os.Args[1:]
+	// --hook marks invocations from the installed git hooks: they are time-limited by
+	// default and fail open, printing a warning instead of failing the git command.
+	hook := len(args) > 0 && args[0] == "--hook"
+	if hook {
+		args = args[1:]
+	}
+	if len(args) < 1 {
 		printUsage()
 		os.Exit(1)
 	}
 
-	sub := os.Args[1]
-	switch sub {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	timeout, err := commandTimeout(hook)
+	if err != nil && !hook {
+		fatal(err)
+	}
+	if timeout > 0 && args[0] != "browse" && args[0] != "edit-metadata" {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	err = run(ctx, args)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s (%s): %w", timeout, backend.TimeoutConfigKey, err)
+	}
+	if err == nil {
+		return
+	}
+	if hook {
+		fmt.Fprintf(os.Stderr, "squash-tree: warning: %v; continuing without squash metadata\n", err)
+		return
+	}
+	stop()
+	fatal(err)
+}
+
+// commandTimeout returns squashTree.timeout for the current repository; hooks fall
+// back to defaultHookTimeout when it is unset or invalid.
+func commandTimeout(hook bool) (time.Duration, error) {
+	var timeout time.Duration
+	set := false
+	var err error
+	if repoPath, rerr := repo.FindGitRepo("."); rerr == nil {
+		timeout, set, err = backend.Timeout(repoPath)
+	}
+	if hook && (!set || err != nil) {
+		return defaultHookTimeout, err
+	}
+	return timeout, err
+}
+
+func run(ctx context.Context, args []string) error {
+	switch args[0] {
 	case "init":
-		if err := runInit(os.Args[2:]); err != nil {
-			fatal(err)
-		}
+		return runInit(args[1:])
 	case "add-metadata":
-		if err := runAddMetadata(os.Args[2:]); err != nil {
-			fatal(err)
-		}
+		return runAddMetadata(ctx, args[1:])
+	case "edit-metadata":
+		return runEditMetadata(ctx, args[1:])
+	case "remove-metadata":
+		return runRemoveMetadata(ctx, args[1:])
+	case "squash-merge":
+		return runSquashMerge(ctx, args[1:])
+	case "squash":
+		return runSquash(ctx, args[1:])
+	case "merge":
+		return runMerge(ctx, args[1:])
+	case "cherry-pick":
+		return runCherryPick(ctx, args[1:])
+	case "record-subtree":
+		return runRecordSubtree(ctx, args[1:])
+	case "record-backport":
+		return runRecordBackport(ctx, args[1:])
+	case "record-rewrite":
+		return runRecordRewrite(ctx, args[1:])
+	case "record-rebase":
+		// Called by post-rewrite hooks installed by earlier versions.
+		return runRecordRewrite(ctx, append([]string{"rebase"}, args[1:]...))
+	case "import-pr":
+		return runImportPR(ctx, args[1:])
+	case "import":
+		return runImport(ctx, args[1:])
+	case "browse":
+		return runBrowse(ctx, args[1:])
+	case "unsquash":
+		return runUnsquash(ctx, args[1:])
+	case "report":
+		return runReport(ctx, args[1:])
 	case "help", "-h", "--help":
 		printUsage()
+		return nil
 	default:
-		if err := runShowTree(sub); err != nil {
-			fatal(err)
-		}
+		return runShowTree(ctx, args)
 	}
 }
 
@@ -45,39 +132,362 @@ func fatal(err error) {
 }
 
 func printUsage() {
-	fmt.Fprintf(os.Stderr, "Usage: git squash-tree <commit>       Show squash tree for a commit\n")
+	fmt.Fprintf(os.Stderr, "Usage: git squash-tree [--strict] [--depth=N] [--format=text|json] <commit>  Show squash tree for a commit\n")
+	fmt.Fprintf(os.Stderr, "       git squash-tree browse [--depth=N] <commit>  Browse the squash tree interactively\n")
+	fmt.Fprintf(os.Stderr, "       git squash-tree unsquash [--branch=<name>] <commit>  Recreate a squash's children on a new branch\n")
+	fmt.Fprintf(os.Stderr, "       git squash-tree report --html=<dir> (<commit> | --all)  Write a static HTML report\n")
+	fmt.Fprintf(os.Stderr, "       git squash-tree squash <base>[..<tip>] [-m <msg>] [--branch=<name>]  Squash a range and record it\n")
+	fmt.Fprintf(os.Stderr, "       git squash-tree merge <branch> [-m <msg>] [--delete-branch] | --continue | --abort  Squash-merge a branch and record it\n")
 	fmt.Fprintf(os.Stderr, "       git squash-tree init [--global] Install hooks in repo (or globally)\n")
 	fmt.Fprintf(os.Stderr, "       git squash-tree add-metadata --root=<ref> --base=<ref> --children=<refs>\n")
+	fmt.Fprintf(os.Stderr, "                    [--author=<id>] [--committer=<id>] [--pr=<url>] [--ref=<kind>=<v>] [--label=<k>=<v>] [--tool=<name@ver>] [--force]\n")
+	fmt.Fprintf(os.Stderr, "       git squash-tree edit-metadata <commit>  Edit a commit's squash metadata in $EDITOR\n")
+	fmt.Fprintf(os.Stderr, "       git squash-tree remove-metadata <commit>  Remove a commit's squash metadata and archive refs\n")
+	fmt.Fprintf(os.Stderr, "       git squash-tree squash-merge begin <commit>|prepare|commit  Record git merge --squash (run by hooks)\n")
+	fmt.Fprintf(os.Stderr, "       git squash-tree record-subtree [--merge] <commit>  Record a git subtree --squash commit (or those merged by <commit>)\n")
+	fmt.Fprintf(os.Stderr, "       git squash-tree record-backport [--from=<squash>] [<commit>]  Copy a squash's metadata to its cherry-pick\n")
+	fmt.Fprintf(os.Stderr, "       git squash-tree cherry-pick pick|prepare|commit  Record git cherry-pick --no-commit of several commits (run by hooks)\n")
+	fmt.Fprintf(os.Stderr, "       git squash-tree record-rewrite amend|rebase < <old new lines>  Record rebase squashes and carry metadata to rewritten squashes (run by hooks)\n")
+	fmt.Fprintf(os.Stderr, "       git squash-tree import-pr --squash=<commit> --head=<ref> --base=<ref> [--strategy=github|gitlab]\n")
+	fmt.Fprintf(os.Stderr, "       git squash-tree import-pr --file=<mapping.json|mapping.csv> [--strategy=github|gitlab]\n")
+	fmt.Fprintf(os.Stderr, "       git squash-tree import --from=<records.jsonl> [--dry-run]\n")
 	fmt.Fprintf(os.Stderr, "\n")
 	fmt.Fprintf(os.Stderr, "Examples:\n")
 	fmt.Fprintf(os.Stderr, "  git squash-tree HEAD\n")
 	fmt.Fprintf(os.Stderr, "  git squash-tree init\n")
+	fmt.Fprintf(os.Stderr, "  git squash-tree squash main..HEAD -m \"Add login page\"\n")
 	fmt.Fprintf(os.Stderr, "  git squash-tree add-metadata --root=HEAD --base=main --children=a1b2c3,d4e5f6\n")
+	fmt.Fprintf(os.Stderr, "  git squash-tree import-pr --squash=a1b2c3 --head=refs/pull/42/head --base=main\n")
+}
+
+type showTreeOptions struct {
+	commitRef string
+	strict    bool
+	format    string
+	depth     int
 }
 
-func runShowTree(commitRef string) error {
+func parseShowTreeFlags(args []string) (showTreeOptions, error) {
+	fs := flag.NewFlagSet("git-squash-tree", flag.ContinueOnError)
+	strict := fs.Bool("strict", false, "Fail if any child commit is missing or any note is unreadable")
+	format := fs.String("format", "text", "Output format: text or json")
+	depth := fs.Int("depth", 0, "Expand nested squashes at most N levels deep (0 = unlimited)")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return showTreeOptions{}, err
+	}
+	if len(args) != 1 {
+		return showTreeOptions{}, fmt.Errorf("expected exactly one commit")
+	}
+	if *format != "text" && *format != "json" {
+		return showTreeOptions{}, fmt.Errorf("unsupported format %q (expected text or json)", *format)
+	}
+	if *depth < 0 {
+		return showTreeOptions{}, fmt.Errorf("--depth must be >= 0")
+	}
+	return showTreeOptions{commitRef: args[0], strict: *strict, format: *format, depth: *depth}, nil
+}
+
+// parseArgs parses args with fs and returns the positional arguments. Flags may
+// follow them, so "git squash-tree HEAD --format json" parses like
+// "git squash-tree --format json HEAD"; everything after "--" is positional.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		if n := len(args) - len(rest); n > 0 && args[n-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+func runShowTree(ctx context.Context, args []string) error {
+	opts, err := parseShowTreeFlags(args)
+	if err != nil {
+		return err
+	}
+	commitRef := opts.commitRef
+
 	repoPath, err := repo.FindGitRepo(".")
 	if err != nil {
 		return fmt.Errorf("not a git repository: %w", err)
 	}
 
-	commitHash, err := repo.ResolveCommitHash(repoPath, commitRef)
+	commitHash, err := repo.ResolveCommitHash(ctx, repoPath, commitRef)
 	if err != nil {
 		return fmt.Errorf("resolve %q: %w", commitRef, err)
 	}
 
 	notesReader := git.NewNotesReader(repoPath)
-	builder := tree.NewBuilder(notesReader)
-	rootNode, err := builder.BuildTree(commitHash)
+	builder := tree.NewBuilder(notesReader).WithOptions(tree.Options{Strict: opts.strict, MaxDepth: opts.depth})
+	rootNode, err := builder.BuildTree(ctx, commitHash)
 	if err != nil {
 		return fmt.Errorf("build tree: %w", err)
 	}
 
+	printWarnings(rootNode, make(map[*tree.Node]bool))
+	if opts.format == "json" {
+		data, err := tree.EncodeJSON(rootNode)
+		if err != nil {
+			return fmt.Errorf("encode tree: %w", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
 	fmt.Print(tree.NewVisualizer().Visualize(rootNode))
 	return nil
 }
 
-func runAddMetadata(args []string) error {
+// printWarnings reports metadata compatibility warnings (newer spec, unknown fields) to stderr.
+func printWarnings(node *tree.Node, seen map[*tree.Node]bool) {
+	if seen[node] {
+		return
+	}
+	seen[node] = true
+	if node.Metadata != nil {
+		for _, w := range node.Metadata.Warnings {
+			fmt.Fprintf(os.Stderr, "warning: %s: %s\n", node.Hash, w)
+		}
+	}
+	for _, child := range node.Children {
+		printWarnings(child, seen)
+	}
+}
+
+func runBrowse(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("browse", flag.ContinueOnError)
+	depth := fs.Int("depth", 0, "Levels of nested squashes to load up front (default: one, the rest on demand)")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("browse expects exactly one commit")
+	}
+
+	repoPath, err := repo.FindGitRepo(".")
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	commitHash, err := repo.ResolveCommitHash(ctx, repoPath, args[0])
+	if err != nil {
+		return fmt.Errorf("resolve %q: %w", args[0], err)
+	}
+
+	builder := tree.NewBuilder(git.NewNotesReader(repoPath)).WithOptions(tree.Options{Lazy: true, MaxDepth: *depth})
+	rootNode, err := builder.BuildTree(ctx, commitHash)
+	if err != nil {
+		return fmt.Errorf("build tree: %w", err)
+	}
+
+	return browse.Run(rootNode, browseExpander{ctx: ctx, builder: builder}, browse.Actions{
+		Detail: func(hash string, full bool) (string, error) {
+			return git.ShowCommit(ctx, repoPath, hash, full)
+		},
+		Unsquash: func(hash string) (string, error) {
+			return git.Unsquash(ctx, repoPath, hash, "")
+		},
+	})
+}
+
+// browseExpander lets the browser expand stubs under the command's context.
+type browseExpander struct {
+	ctx     context.Context
+	builder *tree.Builder
+}
+
+func (e browseExpander) Expand(node *tree.Node) error {
+	return e.builder.Expand(e.ctx, node)
+}
+
+func runReport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	htmlDir := fs.String("html", "", "Directory to write the HTML report into")
+	all := fs.Bool("all", false, "Report every squash root in the repository")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if *htmlDir == "" {
+		return fmt.Errorf("report requires --html=<dir>")
+	}
+	if *all == (len(args) == 1) || len(args) > 1 {
+		return fmt.Errorf("report expects either one commit or --all")
+	}
+
+	repoPath, err := repo.FindGitRepo(".")
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	notesReader := git.NewNotesReader(repoPath)
+
+	var commits []string
+	title := "Squash Tree Report"
+	if *all {
+		annotated, err := notesReader.ListAnnotated(ctx)
+		if err != nil {
+			return err
+		}
+		commits, err = tree.FindRoots(ctx, notesReader, annotated)
+		if err != nil {
+			return err
+		}
+	} else {
+		commitHash, err := repo.ResolveCommitHash(ctx, repoPath, args[0])
+		if err != nil {
+			return fmt.Errorf("resolve %q: %w", args[0], err)
+		}
+		commits = []string{commitHash}
+		title = "Squash Tree Report: " + commitHash
+	}
+
+	builder := tree.NewBuilder(notesReader)
+	var roots []*tree.Node
+	for _, c := range commits {
+		node, err := builder.BuildTree(ctx, c)
+		if err != nil {
+			return fmt.Errorf("build tree for %s: %w", c, err)
+		}
+		roots = append(roots, node)
+	}
+
+	err = report.Write(*htmlDir, report.Report{
+		Title: title,
+		Roots: roots,
+		DiffStat: func(hash string) (string, error) {
+			return git.DiffStat(ctx, repoPath, hash)
+		},
+		GeneratedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Report for %d squash roots written to %s\n", len(roots), filepath.Join(*htmlDir, "index.html"))
+	return nil
+}
+
+func runUnsquash(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("unsquash", flag.ContinueOnError)
+	branch := fs.String("branch", "", "Name of the branch to create (default unsquash/<commit>)")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("unsquash expects exactly one commit")
+	}
+
+	repoPath, err := repo.FindGitRepo(".")
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	commitHash, err := repo.ResolveCommitHash(ctx, repoPath, args[0])
+	if err != nil {
+		return fmt.Errorf("resolve %q: %w", args[0], err)
+	}
+
+	created, err := git.Unsquash(ctx, repoPath, commitHash, *branch)
+	if err != nil {
+		return fmt.Errorf("unsquash: %w", err)
+	}
+	fmt.Printf("Children of %s recreated on branch %s\n", commitHash, created)
+	return nil
+}
+
+func runSquash(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("squash", flag.ContinueOnError)
+	message := fs.String("m", "", "Message of the squash commit (default: the squashed commits' messages)")
+	branch := fs.String("branch", "", "Create this branch at the squash commit instead of moving the current one")
+	ranges, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(ranges) != 1 {
+		return fmt.Errorf("squash expects one range <base>..<tip> (or <base>, meaning <base>..HEAD)")
+	}
+	base, tip, ok := strings.Cut(ranges[0], "..")
+	if !ok || tip == "" {
+		tip = "HEAD"
+	}
+	if base == "" {
+		return fmt.Errorf("squash: missing base in %q", ranges[0])
+	}
+
+	repoPath, err := repo.FindGitRepo(".")
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	res, err := git.Squash(ctx, repoPath, base, tip, git.SquashOptions{Message: *message, Branch: *branch})
+	if err != nil {
+		return fmt.Errorf("squash: %w", err)
+	}
+	noun := "commits"
+	if len(res.Metadata.Children) == 1 {
+		noun = "commit"
+	}
+	fmt.Printf("Squashed %d %s into %s", len(res.Metadata.Children), noun, res.Metadata.Root)
+	switch {
+	case *branch != "":
+		fmt.Printf(" on new branch %s", *branch)
+	case res.Ref != "":
+		fmt.Printf(" (%s updated)", strings.TrimPrefix(res.Ref, "refs/heads/"))
+	}
+	fmt.Println()
+	return nil
+}
+
+func runMerge(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	message := fs.String("m", "", "Message of the squash commit (default: the merged commits' messages)")
+	deleteBranch := fs.Bool("delete-branch", false, "Delete the merged branch once its commits are preserved")
+	cont := fs.Bool("continue", false, "Commit a squash merge stopped on conflicts")
+	abort := fs.Bool("abort", false, "Undo a squash merge stopped on conflicts")
+	branches, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	repoPath, err := repo.FindGitRepo(".")
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	var res *git.SquashResult
+	switch {
+	case *cont && *abort:
+		return fmt.Errorf("--continue and --abort are mutually exclusive")
+	case *abort:
+		return git.AbortSquashMerge(ctx, repoPath)
+	case *cont:
+		res, err = git.ContinueSquashMerge(ctx, repoPath)
+	case len(branches) == 1:
+		res, err = git.SquashMergeBranch(ctx, repoPath, branches[0], git.MergeOptions{Message: *message, DeleteBranch: *deleteBranch})
+	default:
+		return fmt.Errorf("merge expects one branch, --continue or --abort")
+	}
+	if errors.Is(err, git.ErrMergeConflict) {
+		return fmt.Errorf("%w\nResolve them, `git add` the files and run `git squash-tree merge --continue` (or --abort)", err)
+	}
+	if res != nil {
+		fmt.Printf("Squash-merged %d commits into %s\n", len(res.Metadata.Children), res.Metadata.Root)
+	}
+	if err != nil {
+		return fmt.Errorf("merge: %w", err)
+	}
+	if res.DeletedBranch != "" {
+		fmt.Printf("Deleted branch %s\n", res.DeletedBranch)
+	}
+	return nil
+}
+
+func runAddMetadata(ctx context.Context, args []string) error {
 	opts, err := metadata.ParseAddMetadataFlags(args)
 	if err != nil {
 		return err
@@ -89,38 +499,424 @@ func runAddMetadata(args []string) error {
 	}
 
 	notesReader := git.NewNotesReader(repoPath)
-	if notesReader.HasMetadata(opts.RootRef) {
+	if !opts.Force && notesReader.HasMetadata(ctx, opts.RootRef) {
+		fmt.Fprintf(os.Stderr, "%s already has squash metadata; use --force to replace it or edit-metadata to change it\n", opts.RootRef)
 		return nil
 	}
 
-	rootShort, err := repo.ResolveCommitHash(repoPath, opts.RootRef)
+	rootShort, err := repo.ResolveCommitHash(ctx, repoPath, opts.RootRef)
 	if err != nil {
 		return fmt.Errorf("invalid root: %w", err)
 	}
-	baseShort, err := repo.ResolveCommitHash(repoPath, opts.BaseRef)
+	baseShort, err := repo.ResolveCommitHash(ctx, repoPath, opts.BaseRef)
 	if err != nil {
 		return fmt.Errorf("invalid base: %w", err)
 	}
-	childrenShort, err := repo.ResolveRefs(repoPath, splitTrim(opts.ChildrenRefs, ","))
+	childrenShort, err := repo.ResolveRefs(ctx, repoPath, splitTrim(opts.ChildrenRefs, ","))
 	if err != nil {
 		return fmt.Errorf("children: %w", err)
 	}
 
-	if err := git.WriteMetadata(repoPath, rootShort, baseShort, childrenShort, opts.Strategy); err != nil {
+	meta, err := git.BuildMetadata(ctx, repoPath, rootShort, baseShort, childrenShort, opts.Strategy)
+	if err != nil {
+		return err
+	}
+	if opts.HasV2Fields() {
+		if err := git.UpgradeToV2(ctx, repoPath, meta, opts.V2Fields); err != nil {
+			return err
+		}
+	}
+	write := git.WriteSquashMetadata
+	if opts.Force {
+		write = git.ReplaceSquashMetadata
+	}
+	if err := write(ctx, repoPath, meta); err != nil {
 		return fmt.Errorf("write metadata: %w", err)
 	}
 	return nil
 }
 
-func runInit(args []string) error {
-	global := false
-	for _, a := range args {
-		if a == "--global" {
-			global = true
-			break
+func runEditMetadata(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("edit-metadata", flag.ContinueOnError)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("edit-metadata expects exactly one commit")
+	}
+
+	repoPath, err := repo.FindGitRepo(".")
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	rootFull, err := git.FullHash(ctx, repoPath, args[0]+"^{commit}")
+	if err != nil {
+		return fmt.Errorf("resolve %q: %w", args[0], err)
+	}
+	note, err := git.NewNotesReader(repoPath).ReadRaw(ctx, rootFull)
+	if err != nil {
+		return err
+	}
+	if note == "" {
+		return fmt.Errorf("%s has no squash metadata (use add-metadata)", args[0])
+	}
+
+	f, err := os.CreateTemp("", "squash-tree-*.json")
+	if err != nil {
+		return err
+	}
+	path := f.Name()
+	_, err = f.WriteString(note + "\n")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	if err := launchEditor(ctx, repoPath, path); err != nil {
+		os.Remove(path)
+		return err
+	}
+	edited, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(edited)) == note {
+		os.Remove(path)
+		fmt.Fprintln(os.Stderr, "edit-metadata: no changes")
+		return nil
+	}
+
+	// On failure the edited file is kept so the changes are not lost.
+	if err := git.EditSquashMetadata(ctx, repoPath, rootFull, edited); err != nil {
+		return fmt.Errorf("edit %s (edits kept in %s): %w", args[0], path, err)
+	}
+	os.Remove(path)
+	return nil
+}
+
+// launchEditor opens path in the editor git would use (GIT_EDITOR, core.editor,
+// VISUAL, EDITOR), running it through the shell like git does.
+func launchEditor(ctx context.Context, repoPath, path string) error {
+	out, err := backend.Command(ctx, repoPath, "var", "GIT_EDITOR").Output()
+	if err != nil {
+		return fmt.Errorf("find editor: %w", err)
+	}
+	editor := strings.TrimSpace(string(out))
+	cmd := exec.CommandContext(ctx, "sh", "-c", editor+` "$@"`, editor, path)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor %s: %w", editor, err)
+	}
+	return nil
+}
+
+func runRemoveMetadata(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("remove-metadata", flag.ContinueOnError)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("remove-metadata expects exactly one commit")
+	}
+
+	repoPath, err := repo.FindGitRepo(".")
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	rootFull, err := git.FullHash(ctx, repoPath, args[0]+"^{commit}")
+	if err != nil {
+		return fmt.Errorf("resolve %q: %w", args[0], err)
+	}
+	if !git.NewNotesReader(repoPath).HasMetadata(ctx, rootFull) {
+		return fmt.Errorf("%s has no squash metadata", args[0])
+	}
+	if err := git.RemoveSquashMetadata(ctx, repoPath, rootFull); err != nil {
+		return fmt.Errorf("remove metadata: %w", err)
+	}
+	return nil
+}
+
+// runSquashMerge implements the hook steps that record `git merge --squash`: begin
+// (post-merge), prepare (prepare-commit-msg) and commit (post-commit).
+func runSquashMerge(ctx context.Context, args []string) error {
+	if len(args) == 0 || len(args) != 1 && args[0] != "begin" {
+		return fmt.Errorf("squash-merge expects one of begin <commit>, prepare, commit")
+	}
+	repoPath, err := repo.FindGitRepo(".")
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	switch args[0] {
+	case "begin":
+		if len(args) != 2 {
+			return fmt.Errorf("squash-merge begin expects the merged commit")
+		}
+		return git.BeginSquashMerge(ctx, repoPath, args[1])
+	case "prepare":
+		return git.PrepareSquashMergeCommit(ctx, repoPath)
+	case "commit":
+		_, err := git.FinishSquashMerge(ctx, repoPath)
+		return err
+	default:
+		return fmt.Errorf("squash-merge: unknown step %q (expected begin, prepare or commit)", args[0])
+	}
+}
+
+func runRecordSubtree(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("record-subtree", flag.ContinueOnError)
+	merge := fs.Bool("merge", false, "Record the unrecorded subtree squashes merged by <commit>")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("record-subtree expects exactly one commit")
+	}
+	repoPath, err := repo.FindGitRepo(".")
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	if *merge {
+		recorded, err := git.RecordSubtreeMerge(ctx, repoPath, args[0])
+		for _, root := range recorded {
+			fmt.Printf("Recorded subtree squash %s\n", root)
+		}
+		return err
+	}
+	root, err := git.RecordSubtree(ctx, repoPath, args[0])
+	if err != nil {
+		return fmt.Errorf("record-subtree: %w", err)
+	}
+	fmt.Printf("Recorded subtree squash %s\n", root)
+	return nil
+}
+
+func runRecordBackport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("record-backport", flag.ContinueOnError)
+	from := fs.String("from", "", "The squash commit <commit> was cherry-picked from (default: its \"cherry picked from\" line)")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("record-backport expects at most one commit")
+	}
+	repoPath, err := repo.FindGitRepo(".")
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	rev, source := "HEAD", *from
+	if len(args) == 1 {
+		rev = args[0]
+	}
+	if source == "" {
+		if source, err = git.CherryPickedFrom(ctx, repoPath, rev); err != nil {
+			return err
+		}
+	}
+	if source == "" {
+		return fmt.Errorf("%s has no \"cherry picked from\" line; use --from=<squash>", rev)
+	}
+	recorded, err := git.RecordBackport(ctx, repoPath, rev, source)
+	if err != nil || !recorded {
+		return err
+	}
+	b := backend.For(repoPath)
+	sourceShort, _ := b.ShortHash(ctx, source)
+	revShort, _ := b.ShortHash(ctx, rev)
+	fmt.Printf("Copied squash metadata of %s to %s\n", sourceShort, revShort)
+	return nil
+}
+
+func runCherryPick(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("cherry-pick expects one of pick, prepare, commit")
+	}
+	repoPath, err := repo.FindGitRepo(".")
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	switch args[0] {
+	case "pick":
+		return git.RecordCherryPickStep(ctx, repoPath)
+	case "prepare":
+		return git.PrepareCherryPickCommit(ctx, repoPath)
+	case "commit":
+		_, err := git.FinishCherryPick(ctx, repoPath)
+		return err
+	default:
+		return fmt.Errorf("cherry-pick: unknown step %q (expected pick, prepare or commit)", args[0])
+	}
+}
+
+// runRecordRewrite reads the old-to-new mapping git passes to post-rewrite on stdin and,
+// after a rebase, the actions of the finished todo list from the rebase state.
+func runRecordRewrite(ctx context.Context, args []string) error {
+	if len(args) != 1 || (args[0] != "amend" && args[0] != "rebase") {
+		return fmt.Errorf("usage: record-rewrite amend|rebase < <old new lines>")
+	}
+	repoPath, err := repo.FindGitRepo(".")
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	groups, err := git.ParseRewriteMapping(os.Stdin)
+	if err != nil {
+		return err
+	}
+	_, carryErr := git.CarryRewrittenMetadata(ctx, repoPath, groups)
+	if args[0] != "rebase" {
+		return carryErr
+	}
+	gitDir, err := git.GitDir(ctx, repoPath)
+	if err != nil {
+		return err
+	}
+	var actions map[string]string
+	if f, err := os.Open(filepath.Join(gitDir, git.RebaseDoneFile)); err == nil {
+		actions, err = git.ParseRebaseDone(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("read rebase todo: %w", err)
+		}
+	}
+	_, err = git.RecordRebaseSquashes(ctx, repoPath, groups, actions)
+	return errors.Join(carryErr, err)
+}
+
+func runImportPR(ctx context.Context, args []string) error {
+	opts, err := metadata.ParseImportPRFlags(args)
+	if err != nil {
+		return err
+	}
+
+	repoPath, err := repo.FindGitRepo(".")
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+
+	mappings := opts.Mappings
+	if opts.File != "" {
+		data, err := os.ReadFile(opts.File)
+		if err != nil {
+			return fmt.Errorf("read mapping file: %w", err)
+		}
+		mappings, err = metadata.ParsePRMappings(data, opts.Strategy)
+		if err != nil {
+			return err
+		}
+	}
+
+	notesReader := git.NewNotesReader(repoPath)
+	failed := 0
+	for _, m := range mappings {
+		if notesReader.HasMetadata(ctx, m.Squash) {
+			fmt.Printf("%s: already has squash metadata, skipped\n", m.Squash)
+			continue
+		}
+		n, err := importPR(ctx, repoPath, opts.Remote, m)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", m.Squash, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s: recorded %d children from %s\n", m.Squash, n, m.Head)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d imports failed", failed, len(mappings))
+	}
+	return nil
+}
+
+func importPR(ctx context.Context, repoPath, remote string, m metadata.PRMapping) (int, error) {
+	rootShort, err := repo.ResolveCommitHash(ctx, repoPath, m.Squash)
+	if err != nil {
+		return 0, fmt.Errorf("invalid squash commit: %w", err)
+	}
+	headShort, err := repo.ResolveCommitHash(ctx, repoPath, m.Head)
+	if err != nil && strings.HasPrefix(m.Head, "refs/") {
+		if ferr := repo.FetchRef(ctx, repoPath, remote, m.Head); ferr != nil {
+			return 0, fmt.Errorf("invalid head: %w", ferr)
 		}
+		headShort, err = repo.ResolveCommitHash(ctx, repoPath, m.Head)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid head: %w", err)
+	}
+	baseShort, err := repo.MergeBase(ctx, repoPath, m.Base, headShort)
+	if err != nil {
+		return 0, fmt.Errorf("invalid base: %w", err)
+	}
+	childrenShort, err := repo.RevList(ctx, repoPath, baseShort, headShort)
+	if err != nil {
+		return 0, fmt.Errorf("children: %w", err)
+	}
+	if len(childrenShort) == 0 {
+		return 0, fmt.Errorf("no commits between %s and %s", m.Base, m.Head)
+	}
+
+	if err := git.WriteMetadata(ctx, repoPath, rootShort, baseShort, childrenShort, m.Strategy); err != nil {
+		return 0, fmt.Errorf("write metadata: %w", err)
+	}
+	return len(childrenShort), nil
+}
+
+func runImport(ctx context.Context, args []string) error {
+	opts, err := metadata.ParseImportFlags(args)
+	if err != nil {
+		return err
+	}
+
+	repoPath, err := repo.FindGitRepo(".")
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+
+	in := os.Stdin
+	if opts.From != "-" {
+		f, err := os.Open(opts.From)
+		if err != nil {
+			return fmt.Errorf("open import file: %w", err)
+		}
+		defer f.Close()
+		in = f
+	}
+	res, err := git.Import(ctx, repoPath, in, opts.DryRun)
+	if err != nil {
+		return err
+	}
+	for _, rec := range res.Skipped {
+		fmt.Printf("line %d: %s already has squash metadata, skipped\n", rec.Line, rec.Squash)
+	}
+	for _, e := range res.Errors {
+		fmt.Fprintf(os.Stderr, "%v\n", e)
+	}
+
+	verb := "imported"
+	if opts.DryRun {
+		verb = "validated"
+	}
+	fmt.Printf("%s %d records, %d failed\n", verb, res.Imported, len(res.Errors))
+	if len(res.Errors) > 0 {
+		return fmt.Errorf("%d records failed", len(res.Errors))
+	}
+	return nil
+}
+
+func runInit(args []string) error {
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	global := fs.Bool("global", false, "Install the hooks globally (core.hooksPath) instead of in this repository")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("init expects no arguments")
 	}
-	if global {
+	if *global {
 		return runInitGlobal()
 	}
 
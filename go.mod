@@ -1,3 +1,3 @@
-module squash-tree
+module github.com/widefix/squash-tree
 
 go 1.21
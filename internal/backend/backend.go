@@ -0,0 +1,192 @@
+// Package backend abstracts the git operations squash-tree needs, so they can be
+// served either by running the git binary or by reading the repository directly.
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/widefix/squash-tree/internal/gitobj"
+)
+
+// ConfigKey selects the backend: "exec" (default) runs git, "native" uses the pure-Go
+// implementation.
+const ConfigKey = "squashTree.backend"
+
+// TimeoutConfigKey limits how long a squash-tree command may run, as a duration
+// ("30s", "2m") or a number of seconds; 0 means no limit.
+const TimeoutConfigKey = "squashTree.timeout"
+
+const (
+	NameExec   = "exec"
+	NameNative = "native"
+)
+
+// Backend is the set of repository operations used by the notes reader and writer.
+// Revisions accept whatever the implementation can resolve; the native backend
+// handles hashes, ref names and ~/^ suffixes. When ctx is done the exec backend kills
+// the running git process and the native backend stops before its next step.
+type Backend interface {
+	// Name returns NameExec or NameNative.
+	Name() string
+	// RevParse resolves rev to a full object hash.
+	RevParse(ctx context.Context, rev string) (string, error)
+	// ShortHash resolves rev to the abbreviated hash git prints for it.
+	ShortHash(ctx context.Context, rev string) (string, error)
+	// ShortHashes abbreviates several full commit hashes in one call.
+	ShortHashes(ctx context.Context, hashes []string) ([]string, error)
+	// CommitParents returns the full parent hashes of each commit in revs, in order.
+	CommitParents(ctx context.Context, revs []string) ([][]string, error)
+	// ObjectExists reports whether rev names an existing object.
+	ObjectExists(ctx context.Context, rev string) bool
+	// CommitSubject returns the subject line of the commit rev.
+	CommitSubject(ctx context.Context, rev string) (string, error)
+	// ReadNote returns the note on rev under notesRef with surrounding whitespace
+	// trimmed, or "" if there is none.
+	ReadNote(ctx context.Context, notesRef, rev string) (string, error)
+	// ListNotes returns the full hashes of all objects with a note under notesRef.
+	ListNotes(ctx context.Context, notesRef string) ([]string, error)
+	// NoteCommit writes the notes commit that applies op to rev's note under notesRef,
+	// without moving notesRef. parent is the notes commit it builds on, or ZeroHash if
+	// notesRef does not exist yet.
+	NoteCommit(ctx context.Context, notesRef, rev string, op NoteOp, note []byte) (commit, parent string, err error)
+	// UpdateRefs applies all updates in one transaction: either every ref is updated
+	// or none is.
+	UpdateRefs(ctx context.Context, updates []RefUpdate) error
+	// ListRefs returns the sorted names of all refs starting with prefix.
+	ListRefs(ctx context.Context, prefix string) ([]string, error)
+	// RefExists reports whether the fully qualified ref exists.
+	RefExists(ctx context.Context, ref string) bool
+}
+
+// NoteOp selects what NoteCommit does to a note.
+type NoteOp int
+
+const (
+	// NoteAdd adds a note and fails if there already is one.
+	NoteAdd NoteOp = iota
+	// NoteReplace adds the note or overwrites the existing one.
+	NoteReplace
+	// NoteRemove removes the note and fails if there is none.
+	NoteRemove
+)
+
+// ErrRefConflict is returned by UpdateRefs when another writer holds a ref's lock or
+// has moved it away from the expected old value; the caller may rebuild and retry.
+var ErrRefConflict = errors.New("ref updated concurrently")
+
+// ZeroHash as RefUpdate.Old requires that the ref does not exist yet.
+const ZeroHash = gitobj.ZeroHash
+
+// RefUpdate points the fully qualified Ref at the revision New, or deletes it if New is
+// ZeroHash. If Old is set, the transaction fails unless the ref currently holds that
+// full hash.
+type RefUpdate struct {
+	Ref, New, Old string
+}
+
+// reflogMessage is the reflog message of every ref update either backend makes.
+const reflogMessage = "squash-tree"
+
+var (
+	mu     sync.Mutex
+	opened = make(map[string]Backend)
+)
+
+// For returns the backend configured for the repository at repoPath ("" means the
+// current directory). Backends are cached per repository. If the native backend is
+// configured but cannot open the repository (e.g. a SHA-256 or reftable repository),
+// the exec backend is used.
+func For(repoPath string) Backend {
+	abs, err := filepath.Abs(repoPath)
+	if err != nil {
+		abs = repoPath
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if b, ok := opened[abs]; ok {
+		return b
+	}
+	var b Backend = Exec(repoPath)
+	if r, err := gitobj.Open(abs); err == nil {
+		if strings.EqualFold(r.Config().Get("squashtree", "backend"), NameNative) {
+			b = &nativeBackend{repo: r}
+		} else {
+			r.Close()
+		}
+	}
+	opened[abs] = b
+	return b
+}
+
+// Reset drops cached backends, so the next For call re-reads the configuration.
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, b := range opened {
+		if n, ok := b.(*nativeBackend); ok {
+			n.repo.Close()
+		}
+	}
+	opened = make(map[string]Backend)
+}
+
+// Timeout returns the squashTree.timeout configured for the repository at repoPath.
+// set is false if the key is not configured.
+func Timeout(repoPath string) (timeout time.Duration, set bool, err error) {
+	r, err := gitobj.Open(repoPath)
+	if err != nil {
+		return 0, false, err
+	}
+	defer r.Close()
+	v := r.Config().Get("squashtree", "timeout")
+	if v == "" {
+		return 0, false, nil
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, true, fmt.Errorf("invalid %s %q: expected a duration such as 30s", TimeoutConfigKey, v)
+	}
+	return d, true, nil
+}
+
+// Native opens the pure-Go backend for the repository at repoPath regardless of config.
+func Native(repoPath string) (Backend, error) {
+	r, err := gitobj.Open(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	return &nativeBackend{repo: r}, nil
+}
+
+// Exec returns the backend that runs the git binary in repoPath.
+func Exec(repoPath string) Backend {
+	return execBackend{dir: repoPath}
+}
+
+// Command returns a git command that is killed when ctx is done. Git never prompts for
+// credentials on the terminal, so a missing credential fails instead of hanging.
+func Command(ctx context.Context, dir string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	if dir != "" {
+		cmd.Dir = dir
+	}
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	cmd.WaitDelay = waitDelay
+	return cmd
+}
+
+// waitDelay bounds how long a killed git process may keep its output pipes open
+// (e.g. through a credential helper it spawned).
+const waitDelay = 2 * time.Second
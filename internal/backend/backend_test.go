@@ -0,0 +1,326 @@
+package backend
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const notesRef = "refs/notes/squash-tree"
+
+func run(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v %s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func newRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available:", err)
+	}
+	dir := t.TempDir()
+	run(t, dir, "init")
+	run(t, dir, "config", "user.email", "test@test")
+	run(t, dir, "config", "user.name", "Test")
+	run(t, dir, "config", "commit.gpgsign", "false")
+	for i, msg := range []string{"one", "two", "three"} {
+		if err := os.WriteFile(filepath.Join(dir, "f.txt"), []byte(msg), 0644); err != nil {
+			t.Fatal(err)
+		}
+		run(t, dir, "add", "f.txt")
+		run(t, dir, "commit", "-m", msg)
+		if i == 0 {
+			run(t, dir, "gc", "--quiet")
+		}
+	}
+	return dir
+}
+
+// TestBackendsAgree runs the same operations through both backends on twin
+// repositories and checks they observe and produce the same results.
+func TestBackendsAgree(t *testing.T) {
+	dir := newRepo(t)
+	native, err := Native(dir)
+	if err != nil {
+		t.Fatalf("Native: %v", err)
+	}
+	backends := []Backend{Exec(dir), native}
+	ctx := context.Background()
+
+	for _, rev := range []string{"HEAD", "HEAD~1", "HEAD~2"} {
+		var got []string
+		for _, b := range backends {
+			full, err := b.RevParse(ctx, rev)
+			if err != nil {
+				t.Fatalf("%s RevParse(%s): %v", b.Name(), rev, err)
+			}
+			short, err := b.ShortHash(ctx, rev)
+			if err != nil {
+				t.Fatalf("%s ShortHash(%s): %v", b.Name(), rev, err)
+			}
+			// Repeated hashes get one short hash each.
+			shorts, err := b.ShortHashes(ctx, []string{full, full})
+			if err != nil || len(shorts) != 2 || shorts[1] != shorts[0] {
+				t.Fatalf("%s ShortHashes: %v, %v", b.Name(), shorts, err)
+			}
+			subject, err := b.CommitSubject(ctx, short)
+			if err != nil {
+				t.Fatalf("%s CommitSubject: %v", b.Name(), err)
+			}
+			parents, err := b.CommitParents(ctx, []string{rev, full})
+			if err != nil || len(parents) != 2 || strings.Join(parents[0], " ") != strings.Join(parents[1], " ") {
+				t.Fatalf("%s CommitParents: %v, %v", b.Name(), parents, err)
+			}
+			got = append(got, strings.Join(append([]string{full, short, shorts[0], subject}, parents[0]...), " "))
+			if !b.ObjectExists(ctx, short) {
+				t.Errorf("%s ObjectExists(%s) = false", b.Name(), short)
+			}
+		}
+		if got[0] != got[1] {
+			t.Errorf("%s: exec %q, native %q", rev, got[0], got[1])
+		}
+	}
+	for _, b := range backends {
+		if b.ObjectExists(ctx, "deadbeefdeadbeef") {
+			t.Errorf("%s ObjectExists(missing) = true", b.Name())
+		}
+		if _, err := b.CommitParents(ctx, []string{"HEAD", "deadbeefdeadbeef"}); err == nil {
+			t.Errorf("%s CommitParents(missing): expected error", b.Name())
+		}
+		if parents, err := b.CommitParents(ctx, []string{"HEAD~1"}); err != nil || len(parents) != 1 ||
+			len(parents[0]) != 1 || parents[0][0] != run(t, dir, "rev-parse", "HEAD~2") {
+			t.Errorf("%s CommitParents(HEAD~1) = %v, %v", b.Name(), parents, err)
+		}
+		if note, err := b.ReadNote(ctx, notesRef, "HEAD"); err != nil || note != "" {
+			t.Errorf("%s ReadNote before add = %q, %v", b.Name(), note, err)
+		}
+		if notes, err := b.ListNotes(ctx, notesRef); err != nil || len(notes) != 0 {
+			t.Errorf("%s ListNotes before add = %v, %v", b.Name(), notes, err)
+		}
+	}
+
+	// Each backend writes a note on a different commit; both must read both.
+	note := []byte("{\n  \"spec\": \"x\"   \n}\n\n")
+	if err := addNote(ctx, backends[0], "HEAD~1", note); err != nil {
+		t.Fatalf("exec addNote: %v", err)
+	}
+	if err := addNote(ctx, backends[1], "HEAD~2", note); err != nil {
+		t.Fatalf("native addNote: %v", err)
+	}
+	for _, b := range backends {
+		if _, _, err := b.NoteCommit(ctx, notesRef, "HEAD~1", NoteAdd, note); err == nil {
+			t.Errorf("%s NoteCommit over an existing note: expected error", b.Name())
+		}
+	}
+	if refs := run(t, dir, "for-each-ref", "refs/notes/"); strings.Count(refs, "\n") != 0 {
+		t.Errorf("temporary notes refs left behind:\n%s", refs)
+	}
+	want := run(t, dir, "notes", "--ref", notesRef, "show", "HEAD~1")
+	for _, b := range backends {
+		for _, rev := range []string{"HEAD~1", "HEAD~2"} {
+			if got, err := b.ReadNote(ctx, notesRef, rev); err != nil || got != want {
+				t.Errorf("%s ReadNote(%s) = %q, %v; want %q", b.Name(), rev, got, err, want)
+			}
+		}
+		if notes, err := b.ListNotes(ctx, notesRef); err != nil || len(notes) != 2 {
+			t.Errorf("%s ListNotes = %v, %v", b.Name(), notes, err)
+		}
+	}
+	if run(t, dir, "cat-file", "-p", "refs/notes/squash-tree:"+run(t, dir, "rev-parse", "HEAD~2")) !=
+		run(t, dir, "cat-file", "-p", "refs/notes/squash-tree:"+run(t, dir, "rev-parse", "HEAD~1")) {
+		t.Error("native note content differs from git notes add")
+	}
+
+	for i, b := range backends {
+		ref := "refs/squash-archive/test/" + b.Name()
+		if b.RefExists(ctx, ref) {
+			t.Errorf("%s RefExists before update", b.Name())
+		}
+		if err := b.UpdateRefs(ctx, []RefUpdate{{Ref: ref, New: []string{"HEAD", "HEAD~1"}[i]}}); err != nil {
+			t.Fatalf("%s UpdateRefs: %v", b.Name(), err)
+		}
+		for _, other := range backends {
+			if !other.RefExists(ctx, ref) {
+				t.Errorf("%s does not see ref written by %s", other.Name(), b.Name())
+			}
+		}
+	}
+	run(t, dir, "fsck", "--strict")
+}
+
+func addNote(ctx context.Context, b Backend, rev string, note []byte) error {
+	commit, parent, err := b.NoteCommit(ctx, notesRef, rev, NoteAdd, note)
+	if err != nil {
+		return err
+	}
+	return b.UpdateRefs(ctx, []RefUpdate{{Ref: notesRef, New: commit, Old: parent}})
+}
+
+// TestUpdateRefs_AllOrNothing checks that a transaction with one stale ref updates
+// none of its refs, with either backend.
+func TestUpdateRefs_AllOrNothing(t *testing.T) {
+	dir := newRepo(t)
+	native, err := Native(dir)
+	if err != nil {
+		t.Fatalf("Native: %v", err)
+	}
+	ctx := context.Background()
+	head := run(t, dir, "rev-parse", "HEAD")
+	run(t, dir, "update-ref", "refs/test/taken", head)
+	for _, b := range []Backend{Exec(dir), native} {
+		fresh := "refs/test/fresh-" + b.Name()
+		err := b.UpdateRefs(ctx, []RefUpdate{
+			{Ref: fresh, New: "HEAD~1", Old: ZeroHash},
+			{Ref: "refs/test/taken", New: "HEAD~1", Old: ZeroHash},
+		})
+		if err == nil {
+			t.Errorf("%s UpdateRefs: expected error for an existing ref", b.Name())
+		}
+		if b.RefExists(ctx, fresh) {
+			t.Errorf("%s UpdateRefs: %s created by a failed transaction", b.Name(), fresh)
+		}
+		if got := run(t, dir, "rev-parse", "refs/test/taken"); got != head {
+			t.Errorf("%s UpdateRefs: refs/test/taken moved to %s", b.Name(), got)
+		}
+		if _, err := os.Stat(filepath.Join(dir, ".git", fresh+".lock")); !os.IsNotExist(err) {
+			t.Errorf("%s UpdateRefs: lock file left behind", b.Name())
+		}
+	}
+}
+
+func TestFor_SelectsByConfig(t *testing.T) {
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "gitconfig"))
+	dir := newRepo(t)
+	defer Reset()
+	if b := For(dir); b.Name() != NameExec {
+		t.Errorf("default backend = %s", b.Name())
+	}
+	run(t, dir, "config", ConfigKey, NameNative)
+	if b := For(dir); b.Name() != NameExec {
+		t.Errorf("backend not cached: %s", b.Name())
+	}
+	Reset()
+	if b := For(dir); b.Name() != NameNative {
+		t.Errorf("configured backend = %s, want native", b.Name())
+	}
+}
+
+func TestBackends_CancelledContext(t *testing.T) {
+	dir := newRepo(t)
+	native, err := Native(dir)
+	if err != nil {
+		t.Fatalf("Native: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, b := range []Backend{Exec(dir), native} {
+		if _, err := b.ShortHash(ctx, "HEAD"); err == nil {
+			t.Errorf("%T: ShortHash succeeded with a cancelled context", b)
+		}
+	}
+	if err := Command(ctx, dir, "rev-parse", "HEAD").Run(); err == nil {
+		t.Error("Command ran with a cancelled context")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	dir := newRepo(t)
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "gitconfig"))
+	if d, set, err := Timeout(dir); err != nil || set || d != 0 {
+		t.Fatalf("unset: got %v, %v, %v", d, set, err)
+	}
+	for value, want := range map[string]time.Duration{"30": 30 * time.Second, "1m30s": 90 * time.Second, "0": 0} {
+		run(t, dir, "config", TimeoutConfigKey, value)
+		d, set, err := Timeout(dir)
+		if err != nil || !set || d != want {
+			t.Errorf("%q: got %v, %v, %v; want %v", value, d, set, err, want)
+		}
+	}
+	run(t, dir, "config", TimeoutConfigKey, "soon")
+	if _, _, err := Timeout(dir); err == nil {
+		t.Error("expected an error for an invalid timeout")
+	}
+}
+
+// TestNoteCommit_ReplaceAndRemove checks that both backends overwrite and remove notes
+// the way git does, keeping earlier versions in the notes history.
+func TestNoteCommit_ReplaceAndRemove(t *testing.T) {
+	dir := newRepo(t)
+	native, err := Native(dir)
+	if err != nil {
+		t.Fatalf("Native: %v", err)
+	}
+	ctx := context.Background()
+	for i, b := range []Backend{Exec(dir), native} {
+		rev := []string{"HEAD", "HEAD~1"}[i]
+		apply := func(op NoteOp, note string) error {
+			commit, parent, err := b.NoteCommit(ctx, notesRef, rev, op, []byte(note))
+			if err != nil {
+				return err
+			}
+			return b.UpdateRefs(ctx, []RefUpdate{{Ref: notesRef, New: commit, Old: parent}})
+		}
+		if err := apply(NoteRemove, ""); err == nil {
+			t.Errorf("%s NoteRemove without a note: expected error", b.Name())
+		}
+		if err := apply(NoteAdd, "first"); err != nil {
+			t.Fatalf("%s NoteAdd: %v", b.Name(), err)
+		}
+		if err := apply(NoteReplace, "second"); err != nil {
+			t.Fatalf("%s NoteReplace: %v", b.Name(), err)
+		}
+		if got := run(t, dir, "notes", "--ref", notesRef, "show", rev); got != "second" {
+			t.Errorf("%s after replace: note %q", b.Name(), got)
+		}
+		if got := run(t, dir, "notes", "--ref", notesRef+"~1", "show", rev); got != "first" {
+			t.Errorf("%s after replace: previous note %q not in history", b.Name(), got)
+		}
+		if err := apply(NoteRemove, ""); err != nil {
+			t.Fatalf("%s NoteRemove: %v", b.Name(), err)
+		}
+		if note, err := b.ReadNote(ctx, notesRef, rev); err != nil || note != "" {
+			t.Errorf("%s after remove: ReadNote = %q, %v", b.Name(), note, err)
+		}
+	}
+	run(t, dir, "fsck", "--strict")
+}
+
+func TestUpdateRefs_DeleteAndList(t *testing.T) {
+	dir := newRepo(t)
+	native, err := Native(dir)
+	if err != nil {
+		t.Fatalf("Native: %v", err)
+	}
+	ctx := context.Background()
+	for _, b := range []Backend{Exec(dir), native} {
+		prefix := "refs/squash-archive/" + b.Name() + "/"
+		for _, name := range []string{"a", "b", "c"} {
+			run(t, dir, "update-ref", prefix+name, "HEAD")
+		}
+		// Packed refs must be deleted from packed-refs, not just as loose files.
+		run(t, dir, "pack-refs", "--all")
+		run(t, dir, "update-ref", prefix+"d", "HEAD")
+
+		refs, err := b.ListRefs(ctx, prefix)
+		if err != nil || strings.Join(refs, " ") != prefix+"a "+prefix+"b "+prefix+"c "+prefix+"d" {
+			t.Fatalf("%s ListRefs = %v, %v", b.Name(), refs, err)
+		}
+		err = b.UpdateRefs(ctx, []RefUpdate{{Ref: prefix + "a", New: ZeroHash}, {Ref: prefix + "d", New: ZeroHash}})
+		if err != nil {
+			t.Fatalf("%s UpdateRefs delete: %v", b.Name(), err)
+		}
+		if got := run(t, dir, "for-each-ref", "--format=%(refname)", prefix); got != prefix+"b\n"+prefix+"c" {
+			t.Errorf("%s after delete: refs %q", b.Name(), got)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+)
+
+// pending numbers the temporary notes refs of concurrent NoteCommit calls.
+var pending atomic.Int64
+
+type execBackend struct {
+	dir string
+}
+
+func (b execBackend) Name() string {
+	return NameExec
+}
+
+func (b execBackend) RevParse(ctx context.Context, rev string) (string, error) {
+	output, err := Command(ctx, b.dir, "rev-parse", rev).Output()
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse %s: %w", rev, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func (b execBackend) ShortHash(ctx context.Context, rev string) (string, error) {
+	output, err := Command(ctx, b.dir, "rev-parse", "--short", rev).Output()
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse failed: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func (b execBackend) ShortHashes(ctx context.Context, hashes []string) ([]string, error) {
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+	// git log shows each commit once, so ask for distinct hashes and map back.
+	var distinct []string
+	index := make(map[string]int)
+	for _, h := range hashes {
+		if _, ok := index[h]; !ok {
+			index[h] = len(distinct)
+			distinct = append(distinct, h)
+		}
+	}
+	output, err := Command(ctx, b.dir, append([]string{"log", "--no-walk=unsorted", "--format=%h"}, distinct...)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("git log --no-walk failed: %w", err)
+	}
+	shorts := strings.Fields(string(output))
+	if len(shorts) != len(distinct) {
+		return nil, fmt.Errorf("git log --no-walk: got %d hashes for %d commits", len(shorts), len(distinct))
+	}
+	out := make([]string, len(hashes))
+	for i, h := range hashes {
+		out[i] = shorts[index[h]]
+	}
+	return out, nil
+}
+
+func (b execBackend) CommitParents(ctx context.Context, revs []string) ([][]string, error) {
+	if len(revs) == 0 {
+		return nil, nil
+	}
+	// cat-file --batch answers every input line in order, so one process serves all revs.
+	var in strings.Builder
+	for _, rev := range revs {
+		in.WriteString(rev + "^{commit}\n")
+	}
+	cmd := Command(ctx, b.dir, "cat-file", "--batch")
+	cmd.Stdin = strings.NewReader(in.String())
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git cat-file --batch: %w", err)
+	}
+	parents := make([][]string, len(revs))
+	for i, rev := range revs {
+		header, rest, _ := bytes.Cut(output, []byte("\n"))
+		var hash, kind string
+		var size int
+		if n, _ := fmt.Sscanf(string(header), "%s %s %d", &hash, &kind, &size); n != 3 || kind != "commit" || size+1 > len(rest) {
+			return nil, fmt.Errorf("git cat-file --batch: %s is not a commit", rev)
+		}
+		body, _, _ := bytes.Cut(rest[:size], []byte("\n\n"))
+		for _, line := range strings.Split(string(body), "\n") {
+			if p, ok := strings.CutPrefix(line, "parent "); ok {
+				parents[i] = append(parents[i], p)
+			}
+		}
+		output = rest[size+1:]
+	}
+	return parents, nil
+}
+
+func (b execBackend) ObjectExists(ctx context.Context, rev string) bool {
+	return Command(ctx, b.dir, "cat-file", "-e", rev).Run() == nil
+}
+
+func (b execBackend) CommitSubject(ctx context.Context, rev string) (string, error) {
+	output, err := Command(ctx, b.dir, "log", "-1", "--format=%s", rev).Output()
+	if err != nil {
+		return "", fmt.Errorf("git log: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func (b execBackend) ReadNote(ctx context.Context, notesRef, rev string) (string, error) {
+	output, err := Command(ctx, b.dir, "notes", "--ref", notesRef, "show", rev).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return "", nil
+		}
+		return "", fmt.Errorf("git notes show failed: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func (b execBackend) ListNotes(ctx context.Context, notesRef string) ([]string, error) {
+	output, err := Command(ctx, b.dir, "notes", "--ref", notesRef, "list").Output()
+	if err != nil {
+		return nil, fmt.Errorf("git notes list failed: %w", err)
+	}
+	var objects []string
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			objects = append(objects, fields[1])
+		}
+	}
+	return objects, nil
+}
+
+// NoteCommit lets `git notes` build the commit on a temporary notes ref that starts at
+// the current notes commit, so notesRef itself is left for UpdateRefs to move.
+func (b execBackend) NoteCommit(ctx context.Context, notesRef, rev string, op NoteOp, note []byte) (string, string, error) {
+	parent := ZeroHash
+	output, err := Command(ctx, b.dir, "rev-parse", "--verify", "--quiet", notesRef+"^{commit}").Output()
+	if err == nil {
+		parent = strings.TrimSpace(string(output))
+	} else if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+		return "", "", fmt.Errorf("git rev-parse %s: %w", notesRef, err)
+	}
+
+	tmp := fmt.Sprintf("%s-pending/%d-%d", notesRef, os.Getpid(), pending.Add(1))
+	defer Command(context.WithoutCancel(ctx), b.dir, "update-ref", "-d", tmp).Run()
+	if parent != ZeroHash {
+		if out, err := Command(ctx, b.dir, "update-ref", tmp, parent, ZeroHash).CombinedOutput(); err != nil {
+			return "", "", fmt.Errorf("git update-ref %s: %w: %s", tmp, err, string(out))
+		}
+	}
+	args := []string{"notes", "--ref", tmp, "add", "-F", "-", rev}
+	switch op {
+	case NoteReplace:
+		args = []string{"notes", "--ref", tmp, "add", "-f", "-F", "-", rev}
+	case NoteRemove:
+		args = []string{"notes", "--ref", tmp, "remove", rev}
+	}
+	cmd := Command(ctx, b.dir, args...)
+	cmd.Stdin = bytes.NewReader(note)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", "", fmt.Errorf("git notes %s: %w: %s", args[3], err, string(out))
+	}
+	commit, err := b.RevParse(ctx, tmp)
+	if err != nil {
+		return "", "", err
+	}
+	return commit, parent, nil
+}
+
+// UpdateRefs runs a `git update-ref --stdin` transaction; git locks and verifies every
+// ref in prepare, so a failure there leaves all of them unchanged.
+func (b execBackend) UpdateRefs(ctx context.Context, updates []RefUpdate) error {
+	var in strings.Builder
+	in.WriteString("start\n")
+	for _, u := range updates {
+		if u.New == ZeroHash {
+			fmt.Fprintf(&in, "delete %s", u.Ref)
+		} else {
+			fmt.Fprintf(&in, "update %s %s", u.Ref, u.New)
+		}
+		if u.Old != "" {
+			fmt.Fprintf(&in, " %s", u.Old)
+		}
+		in.WriteString("\n")
+	}
+	in.WriteString("prepare\ncommit\n")
+	cmd := Command(ctx, b.dir, "update-ref", "-m", reflogMessage, "--stdin")
+	cmd.Stdin = strings.NewReader(in.String())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		msg := strings.TrimSpace(string(out))
+		if isRefConflict(msg) {
+			return fmt.Errorf("%w: %s", ErrRefConflict, msg)
+		}
+		return fmt.Errorf("git update-ref --stdin: %w: %s", err, msg)
+	}
+	return nil
+}
+
+func (b execBackend) ListRefs(ctx context.Context, prefix string) ([]string, error) {
+	output, err := Command(ctx, b.dir, "for-each-ref", "--format=%(refname)", prefix).Output()
+	if err != nil {
+		return nil, fmt.Errorf("git for-each-ref: %w", err)
+	}
+	var refs []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.HasPrefix(line, prefix) {
+			refs = append(refs, line)
+		}
+	}
+	return refs, nil
+}
+
+// isRefConflict reports whether update-ref failed because another writer got there
+// first: a stale old value, a ref created or deleted meanwhile, or a held lock.
+func isRefConflict(msg string) bool {
+	for _, s := range []string{"but expected", "reference already exists", "reference is missing", "File exists"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b execBackend) RefExists(ctx context.Context, ref string) bool {
+	return Command(ctx, b.dir, "show-ref", "--verify", "--quiet", ref).Run() == nil
+}
@@ -0,0 +1,195 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/widefix/squash-tree/internal/gitobj"
+)
+
+type nativeBackend struct {
+	repo *gitobj.Repo
+}
+
+func (b *nativeBackend) Name() string {
+	return NameNative
+}
+
+func (b *nativeBackend) RevParse(ctx context.Context, rev string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return b.repo.RevParse(rev)
+}
+
+func (b *nativeBackend) ShortHash(ctx context.Context, rev string) (string, error) {
+	hash, err := b.RevParse(ctx, rev)
+	if err != nil {
+		return "", err
+	}
+	return b.repo.Abbrev(hash), nil
+}
+
+func (b *nativeBackend) ShortHashes(ctx context.Context, hashes []string) ([]string, error) {
+	out := make([]string, len(hashes))
+	for i, h := range hashes {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if _, err := b.repo.ReadCommit(h); err != nil {
+			return nil, err
+		}
+		out[i] = b.repo.Abbrev(h)
+	}
+	return out, nil
+}
+
+func (b *nativeBackend) CommitParents(ctx context.Context, revs []string) ([][]string, error) {
+	parents := make([][]string, len(revs))
+	for i, rev := range revs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		c, err := b.commit(ctx, rev)
+		if err != nil {
+			return nil, err
+		}
+		parents[i] = c.Parents
+	}
+	return parents, nil
+}
+
+func (b *nativeBackend) ObjectExists(ctx context.Context, rev string) bool {
+	_, err := b.RevParse(ctx, rev)
+	return err == nil
+}
+
+func (b *nativeBackend) CommitSubject(ctx context.Context, rev string) (string, error) {
+	c, err := b.commit(ctx, rev)
+	if err != nil {
+		return "", err
+	}
+	return c.Subject(), nil
+}
+
+func (b *nativeBackend) commit(ctx context.Context, rev string) (*gitobj.Commit, error) {
+	hash, err := b.RevParse(ctx, rev)
+	if err != nil {
+		return nil, err
+	}
+	if hash, err = b.repo.Peel(hash, gitobj.TypeCommit); err != nil {
+		return nil, err
+	}
+	return b.repo.ReadCommit(hash)
+}
+
+func (b *nativeBackend) ReadNote(ctx context.Context, notesRef, rev string) (string, error) {
+	hash, err := b.RevParse(ctx, rev)
+	if err != nil {
+		return "", fmt.Errorf("read note: %w", err)
+	}
+	data, found, err := b.repo.ReadNote(notesRef, hash)
+	if err != nil || !found {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (b *nativeBackend) ListNotes(ctx context.Context, notesRef string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	notes, err := b.repo.ListNotes(notesRef)
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]string, len(notes))
+	for i, n := range notes {
+		objects[i] = n.Object
+	}
+	return objects, nil
+}
+
+// NoteCommit builds the note like `git notes add -F -` (with -f for NoteReplace),
+// including its whitespace cleanup, or like `git notes remove`.
+func (b *nativeBackend) NoteCommit(ctx context.Context, notesRef, rev string, op NoteOp, note []byte) (string, string, error) {
+	hash, err := b.RevParse(ctx, rev)
+	if err != nil {
+		return "", "", fmt.Errorf("note %s: %w", rev, err)
+	}
+	if op == NoteRemove {
+		commit, parent, err := b.repo.RemoveNoteCommit(notesRef, hash)
+		if errors.Is(err, gitobj.ErrNoteNotFound) {
+			return "", "", fmt.Errorf("object %s has no note", hash)
+		}
+		return commit, parent, err
+	}
+	commit, parent, err := b.repo.NoteCommit(notesRef, hash, stripSpace(note), op == NoteReplace)
+	if errors.Is(err, gitobj.ErrNoteExists) {
+		return "", "", fmt.Errorf("cannot add notes: found existing notes for object %s", hash)
+	}
+	return commit, parent, err
+}
+
+func (b *nativeBackend) UpdateRefs(ctx context.Context, updates []RefUpdate) error {
+	tx := make([]gitobj.RefUpdate, len(updates))
+	for i, u := range updates {
+		full := u.New
+		if full != ZeroHash {
+			var err error
+			if full, err = b.RevParse(ctx, u.New); err != nil {
+				return fmt.Errorf("update ref %s: %w", u.Ref, err)
+			}
+		}
+		tx[i] = gitobj.RefUpdate{Name: u.Ref, New: full, Old: u.Old, Message: reflogMessage}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := b.repo.UpdateRefs(tx)
+	if errors.Is(err, gitobj.ErrRefChanged) || errors.Is(err, gitobj.ErrRefLocked) {
+		return fmt.Errorf("%w: %v", ErrRefConflict, err)
+	}
+	return err
+}
+
+func (b *nativeBackend) ListRefs(ctx context.Context, prefix string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return b.repo.ListRefs(prefix)
+}
+
+func (b *nativeBackend) RefExists(ctx context.Context, ref string) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	_, err := b.repo.ReadRef(ref)
+	return err == nil
+}
+
+// stripSpace applies git's default message cleanup: trailing whitespace is removed
+// from every line, runs of blank lines are collapsed and leading and trailing blank
+// lines are dropped.
+func stripSpace(text []byte) []byte {
+	var out []string
+	blank := false
+	for _, line := range strings.Split(string(text), "\n") {
+		line = strings.TrimRight(line, " \t\r\v\f")
+		if line == "" {
+			blank = len(out) > 0
+			continue
+		}
+		if blank {
+			out = append(out, "")
+			blank = false
+		}
+		out = append(out, line)
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(out, "\n") + "\n")
+}
@@ -0,0 +1,31 @@
+// Package browse implements the interactive full-screen squash tree browser.
+package browse
+
+import "github.com/widefix/squash-tree/internal/tree"
+
+// Run opens the terminal and runs the browser over root until the user quits.
+// Actions.Copy defaults to copying through the terminal (OSC 52).
+func Run(root *tree.Node, expander Expander, actions Actions) error {
+	t, err := openTerminal()
+	if err != nil {
+		return err
+	}
+	defer t.close()
+
+	if actions.Copy == nil {
+		actions.Copy = t.copy
+	}
+	m := NewModel(root, expander, actions)
+	for {
+		t.draw(m.Render(t.size()))
+		buf, err := t.read()
+		if err != nil {
+			return err
+		}
+		for _, k := range DecodeKeys(buf) {
+			if m.HandleKey(k) {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package browse
+
+import "unicode/utf8"
+
+type KeyCode int
+
+const (
+	KeyRune KeyCode = iota
+	KeyUp
+	KeyDown
+	KeyLeft
+	KeyRight
+	KeyPageUp
+	KeyPageDown
+	KeyEnter
+	KeyEscape
+	KeyBackspace
+	KeyUnknown
+)
+
+type Key struct {
+	Code KeyCode
+	Rune rune
+}
+
+var escapeSequences = map[string]KeyCode{
+	"\x1b[A":  KeyUp,
+	"\x1b[B":  KeyDown,
+	"\x1b[C":  KeyRight,
+	"\x1b[D":  KeyLeft,
+	"\x1bOA":  KeyUp,
+	"\x1bOB":  KeyDown,
+	"\x1bOC":  KeyRight,
+	"\x1bOD":  KeyLeft,
+	"\x1b[5~": KeyPageUp,
+	"\x1b[6~": KeyPageDown,
+}
+
+// DecodeKeys splits raw terminal input into key presses.
+func DecodeKeys(buf []byte) []Key {
+	var keys []Key
+	for len(buf) > 0 {
+		if buf[0] == 0x1b {
+			matched := false
+			for seq, code := range escapeSequences {
+				if len(buf) >= len(seq) && string(buf[:len(seq)]) == seq {
+					keys = append(keys, Key{Code: code})
+					buf = buf[len(seq):]
+					matched = true
+					break
+				}
+			}
+			if matched {
+				continue
+			}
+			if len(buf) > 1 && buf[1] == '[' {
+				keys = append(keys, Key{Code: KeyUnknown})
+				return keys
+			}
+			keys = append(keys, Key{Code: KeyEscape})
+			buf = buf[1:]
+			continue
+		}
+		switch buf[0] {
+		case '\r', '\n':
+			keys = append(keys, Key{Code: KeyEnter})
+			buf = buf[1:]
+			continue
+		case 0x7f, 0x08:
+			keys = append(keys, Key{Code: KeyBackspace})
+			buf = buf[1:]
+			continue
+		case 0x03: // Ctrl-C
+			keys = append(keys, Key{Code: KeyRune, Rune: 'q'})
+			buf = buf[1:]
+			continue
+		}
+		r, size := utf8.DecodeRune(buf)
+		keys = append(keys, Key{Code: KeyRune, Rune: r})
+		buf = buf[size:]
+	}
+	return keys
+}
@@ -0,0 +1,366 @@
+package browse
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/widefix/squash-tree/internal/tree"
+)
+
+// Expander loads the children of an unexpanded squash stub, typically via (*tree.Builder).Expand.
+type Expander interface {
+	Expand(node *tree.Node) error
+}
+
+// Actions are the side effects the browser can trigger; all are optional.
+type Actions struct {
+	// Detail returns the text shown in the side pane: a stat (full=false) or a full diff.
+	Detail func(hash string, full bool) (string, error)
+	// Copy puts text on the clipboard.
+	Copy func(text string) error
+	// Unsquash recreates the children of a squash on a new branch and returns its name.
+	Unsquash func(hash string) (string, error)
+}
+
+type mode int
+
+const (
+	modeNormal mode = iota
+	modeSearch
+	modeConfirmUnsquash
+)
+
+type row struct {
+	node   *tree.Node
+	depth  int
+	prefix string
+	repeat bool // a shared node already shown above; never expanded here
+}
+
+// Model is the state of the browser, independent of the terminal.
+type Model struct {
+	root     *tree.Node
+	expander Expander
+	actions  Actions
+
+	expanded map[*tree.Node]bool
+	rows     []row
+	cursor   int
+	top      int
+
+	mode     mode
+	input    string
+	query    string
+	fullDiff bool
+	scroll   int
+	status   string
+	detail   map[string]string
+}
+
+func NewModel(root *tree.Node, expander Expander, actions Actions) *Model {
+	m := &Model{
+		root:     root,
+		expander: expander,
+		actions:  actions,
+		expanded: map[*tree.Node]bool{root: true},
+		detail:   make(map[string]string),
+	}
+	m.refresh()
+	return m
+}
+
+// Selected returns the node under the cursor.
+func (m *Model) Selected() *tree.Node {
+	return m.rows[m.cursor].node
+}
+
+func expandable(n *tree.Node) bool {
+	return n.IsSquash() && (n.Unexpanded || len(n.Children) > 0)
+}
+
+func (m *Model) refresh() {
+	var selected *tree.Node
+	if len(m.rows) > 0 {
+		selected = m.Selected()
+	}
+	m.rows = m.rows[:0]
+	seen := make(map[*tree.Node]bool)
+	var walk func(n *tree.Node, depth int, prefix, childPrefix string)
+	walk = func(n *tree.Node, depth int, prefix, childPrefix string) {
+		repeat := seen[n]
+		seen[n] = true
+		m.rows = append(m.rows, row{node: n, depth: depth, prefix: prefix, repeat: repeat})
+		if repeat || !m.expanded[n] {
+			return
+		}
+		for i, c := range n.Children {
+			if i == len(n.Children)-1 {
+				walk(c, depth+1, childPrefix+"└── ", childPrefix+"    ")
+			} else {
+				walk(c, depth+1, childPrefix+"├── ", childPrefix+"│   ")
+			}
+		}
+	}
+	walk(m.root, 0, "", "")
+
+	m.cursor = 0
+	for i, r := range m.rows {
+		if r.node == selected && !r.repeat {
+			m.cursor = i
+			break
+		}
+	}
+}
+
+func (m *Model) move(delta int) {
+	m.cursor += delta
+	if m.cursor < 0 {
+		m.cursor = 0
+	}
+	if m.cursor >= len(m.rows) {
+		m.cursor = len(m.rows) - 1
+	}
+	m.scroll = 0
+}
+
+func (m *Model) setExpanded(expand bool) {
+	r := m.rows[m.cursor]
+	n := r.node
+	if r.repeat || !expandable(n) {
+		return
+	}
+	if expand && n.Unexpanded {
+		if err := m.expander.Expand(n); err != nil {
+			m.status = fmt.Sprintf("expand %s: %v", n.Hash, err)
+			return
+		}
+	}
+	m.expanded[n] = expand
+	m.refresh()
+}
+
+// collapseOrParent collapses the selected node, or moves to its parent row if it is
+// already collapsed.
+func (m *Model) collapseOrParent() {
+	r := m.rows[m.cursor]
+	if m.expanded[r.node] && !r.repeat && expandable(r.node) {
+		m.setExpanded(false)
+		return
+	}
+	for i := m.cursor - 1; i >= 0; i-- {
+		if m.rows[i].depth < r.depth {
+			m.cursor = i
+			m.scroll = 0
+			return
+		}
+	}
+}
+
+// search selects the next loaded node after the cursor whose hash starts with query or
+// whose message contains it, expanding collapsed ancestors as needed.
+func (m *Model) search(query string) {
+	if query == "" {
+		return
+	}
+	q := strings.ToLower(query)
+	type hit struct {
+		path []*tree.Node
+	}
+	var order []hit
+	seen := make(map[*tree.Node]bool)
+	var walk func(n *tree.Node, path []*tree.Node)
+	walk = func(n *tree.Node, path []*tree.Node) {
+		if seen[n] {
+			return
+		}
+		seen[n] = true
+		path = append(path[:len(path):len(path)], n)
+		if strings.HasPrefix(strings.ToLower(n.Hash), q) || strings.Contains(strings.ToLower(n.Message), q) {
+			order = append(order, hit{path: path})
+		}
+		for _, c := range n.Children {
+			walk(c, path)
+		}
+	}
+	walk(m.root, nil)
+	if len(order) == 0 {
+		m.status = fmt.Sprintf("no match for %q", query)
+		return
+	}
+
+	current := m.Selected()
+	start := 0
+	for i, h := range order {
+		if h.path[len(h.path)-1] == current {
+			start = i + 1
+			break
+		}
+	}
+	target := order[start%len(order)]
+	for _, n := range target.path[:len(target.path)-1] {
+		m.expanded[n] = true
+	}
+	m.refresh()
+	want := target.path[len(target.path)-1]
+	for i, r := range m.rows {
+		if r.node == want && !r.repeat {
+			m.cursor = i
+			break
+		}
+	}
+	m.scroll = 0
+	m.status = ""
+}
+
+// HandleKey applies a key press and reports whether the browser should exit.
+func (m *Model) HandleKey(k Key) (quit bool) {
+	switch m.mode {
+	case modeSearch:
+		switch k.Code {
+		case KeyEnter:
+			m.mode = modeNormal
+			m.query = m.input
+			m.search(m.query)
+		case KeyEscape:
+			m.mode = modeNormal
+		case KeyBackspace:
+			if r := []rune(m.input); len(r) > 0 {
+				m.input = string(r[:len(r)-1])
+			}
+		case KeyRune:
+			m.input += string(k.Rune)
+		}
+		return false
+	case modeConfirmUnsquash:
+		m.mode = modeNormal
+		if k.Code == KeyRune && (k.Rune == 'y' || k.Rune == 'Y') {
+			m.unsquash()
+		} else {
+			m.status = "unsquash cancelled"
+		}
+		return false
+	}
+
+	m.status = ""
+	switch k.Code {
+	case KeyUp:
+		m.move(-1)
+	case KeyDown:
+		m.move(1)
+	case KeyPageUp:
+		m.scroll -= 10
+		if m.scroll < 0 {
+			m.scroll = 0
+		}
+	case KeyPageDown:
+		m.scroll += 10
+	case KeyRight, KeyEnter:
+		m.setExpanded(true)
+	case KeyLeft:
+		m.collapseOrParent()
+	case KeyEscape:
+		return false
+	case KeyRune:
+		switch k.Rune {
+		case 'q':
+			return true
+		case 'k':
+			m.move(-1)
+		case 'j':
+			m.move(1)
+		case 'l':
+			m.setExpanded(true)
+		case 'h':
+			m.collapseOrParent()
+		case ' ':
+			m.setExpanded(!m.expanded[m.Selected()])
+		case 'g':
+			m.move(-len(m.rows))
+		case 'G':
+			m.move(len(m.rows))
+		case 'K':
+			m.HandleKey(Key{Code: KeyPageUp})
+		case 'J':
+			m.HandleKey(Key{Code: KeyPageDown})
+		case '/':
+			m.mode = modeSearch
+			m.input = ""
+		case 'n':
+			m.search(m.query)
+		case 'd':
+			m.fullDiff = !m.fullDiff
+			m.scroll = 0
+		case 'y':
+			m.copyHash()
+		case 'u':
+			if !m.Selected().IsSquash() {
+				m.status = "unsquash: selected commit is not a squash"
+			} else if m.actions.Unsquash == nil {
+				m.status = "unsquash not available"
+			} else {
+				m.mode = modeConfirmUnsquash
+			}
+		}
+	}
+	return false
+}
+
+func (m *Model) copyHash() {
+	hash := m.Selected().Hash
+	if m.actions.Copy == nil {
+		m.status = "copy not available"
+		return
+	}
+	if err := m.actions.Copy(hash); err != nil {
+		m.status = fmt.Sprintf("copy: %v", err)
+		return
+	}
+	m.status = "copied " + hash
+}
+
+func (m *Model) unsquash() {
+	hash := m.Selected().Hash
+	branch, err := m.actions.Unsquash(hash)
+	if err != nil {
+		m.status = fmt.Sprintf("unsquash %s: %v", hash, err)
+		return
+	}
+	m.status = fmt.Sprintf("unsquashed %s onto branch %s", hash, branch)
+}
+
+func (m *Model) detailText(n *tree.Node) string {
+	var b strings.Builder
+	switch {
+	case n.IsMissing():
+		fmt.Fprintf(&b, "%s: commit not found\n", n.Hash)
+		if n.Message != "" {
+			fmt.Fprintf(&b, "Recorded message: %s\n", n.Message)
+		}
+		return b.String()
+	case n.IsInvalid() || n.IsUnreadable():
+		fmt.Fprintf(&b, "%s: squash note cannot be read\n%v\n\n", n.Hash, n.Err)
+	case n.IsSquash() && n.Metadata != nil:
+		meta := n.Metadata
+		fmt.Fprintf(&b, "Squash of %d commits onto %s (strategy: %s, %s)\n", len(meta.Children), meta.Base, meta.Strategy, meta.Spec)
+		if len(n.Parents) > 1 {
+			fmt.Fprintf(&b, "Shared by %d squashes\n", len(n.Parents))
+		}
+		b.WriteString("\n")
+	}
+
+	if m.actions.Detail == nil {
+		return b.String()
+	}
+	key := fmt.Sprintf("%s:%v", n.Hash, m.fullDiff)
+	text, ok := m.detail[key]
+	if !ok {
+		var err error
+		text, err = m.actions.Detail(n.Hash, m.fullDiff)
+		if err != nil {
+			text = fmt.Sprintf("error: %v\n", err)
+		}
+		m.detail[key] = text
+	}
+	b.WriteString(text)
+	return b.String()
+}
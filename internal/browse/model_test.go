@@ -0,0 +1,174 @@
+package browse
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/widefix/squash-tree/internal/tree"
+)
+
+// stubExpander expands stubs by attaching prepared children.
+type stubExpander struct {
+	children map[*tree.Node][]*tree.Node
+	calls    int
+}
+
+func (s *stubExpander) Expand(n *tree.Node) error {
+	s.calls++
+	n.Unexpanded = false
+	n.Children = s.children[n]
+	for _, c := range n.Children {
+		c.Parents = append(c.Parents, n)
+	}
+	return nil
+}
+
+func testTree() (*tree.Node, *stubExpander) {
+	leaf := &tree.Node{Hash: "leaf1", Type: tree.NodeTypeLeaf, Message: "Fix typo"}
+	inner := &tree.Node{Hash: "inner", Type: tree.NodeTypeSquash, Unexpanded: true}
+	other := &tree.Node{Hash: "other", Type: tree.NodeTypeLeaf, Message: "Add login"}
+	root := &tree.Node{Hash: "root", Type: tree.NodeTypeSquash, Children: []*tree.Node{other, inner}}
+	return root, &stubExpander{children: map[*tree.Node][]*tree.Node{inner: {leaf}}}
+}
+
+func keys(s string) []Key {
+	return DecodeKeys([]byte(s))
+}
+
+func press(m *Model, input string) {
+	for _, k := range keys(input) {
+		m.HandleKey(k)
+	}
+}
+
+func TestModel_ExpandAndCollapse(t *testing.T) {
+	root, exp := testTree()
+	m := NewModel(root, exp, Actions{})
+	if len(m.rows) != 3 {
+		t.Fatalf("rows: got %d, want 3", len(m.rows))
+	}
+
+	press(m, "jj")
+	if m.Selected().Hash != "inner" {
+		t.Fatalf("selected %q", m.Selected().Hash)
+	}
+	press(m, "l")
+	if exp.calls != 1 || len(m.rows) != 4 {
+		t.Fatalf("after expand: calls=%d rows=%d", exp.calls, len(m.rows))
+	}
+	press(m, "j")
+	if m.Selected().Hash != "leaf1" {
+		t.Fatalf("selected %q", m.Selected().Hash)
+	}
+	press(m, "h") // leaf: jump to parent
+	if m.Selected().Hash != "inner" {
+		t.Fatalf("after h on leaf: selected %q", m.Selected().Hash)
+	}
+	press(m, "h") // collapse
+	if len(m.rows) != 3 || m.Selected().Hash != "inner" {
+		t.Fatalf("after collapse: rows=%d selected=%q", len(m.rows), m.Selected().Hash)
+	}
+	press(m, " ")
+	if len(m.rows) != 4 || exp.calls != 1 {
+		t.Fatalf("re-expand should not reload: rows=%d calls=%d", len(m.rows), exp.calls)
+	}
+}
+
+func TestModel_SearchExpandsCollapsedAncestors(t *testing.T) {
+	root, exp := testTree()
+	m := NewModel(root, exp, Actions{})
+	press(m, "jjl") // load inner
+	press(m, "h")   // collapse again
+	press(m, "gg")
+
+	press(m, "/typo\r")
+	if m.Selected().Hash != "leaf1" {
+		t.Fatalf("search: selected %q", m.Selected().Hash)
+	}
+	press(m, "/nothing-matches\r")
+	if !strings.Contains(m.status, "no match") {
+		t.Errorf("status %q", m.status)
+	}
+}
+
+func TestModel_CopyAndUnsquash(t *testing.T) {
+	root, exp := testTree()
+	var copied, unsquashed string
+	m := NewModel(root, exp, Actions{
+		Copy: func(s string) error { copied = s; return nil },
+		Unsquash: func(hash string) (string, error) {
+			unsquashed = hash
+			if hash == "inner" {
+				return "", errors.New("conflict")
+			}
+			return "unsquash/" + hash, nil
+		},
+	})
+
+	press(m, "y")
+	if copied != "root" {
+		t.Errorf("copied %q", copied)
+	}
+	press(m, "un")
+	if unsquashed != "" || m.status != "unsquash cancelled" {
+		t.Errorf("declined unsquash ran: %q status=%q", unsquashed, m.status)
+	}
+	press(m, "uy")
+	if unsquashed != "root" || !strings.Contains(m.status, "unsquash/root") {
+		t.Errorf("unsquash: %q status=%q", unsquashed, m.status)
+	}
+	press(m, "j")
+	press(m, "u")
+	if !strings.Contains(m.status, "not a squash") {
+		t.Errorf("unsquash on leaf: status=%q", m.status)
+	}
+}
+
+func TestModel_RenderShowsTreeAndDetail(t *testing.T) {
+	root, exp := testTree()
+	m := NewModel(root, exp, Actions{
+		Detail: func(hash string, full bool) (string, error) {
+			if full {
+				return "diff --git a/" + hash, nil
+			}
+			return "stat of " + hash, nil
+		},
+	})
+	out := m.Render(100, 6)
+	lines := strings.Split(out, "\r\n")
+	if len(lines) != 6 {
+		t.Fatalf("lines: got %d, want 6", len(lines))
+	}
+	if !strings.Contains(lines[0], "- root [SQUASH]") || !strings.Contains(lines[0], "stat of root") {
+		t.Errorf("line 0: %q", lines[0])
+	}
+	if !strings.Contains(out, "└── + inner [SQUASH]") {
+		t.Errorf("stub not marked collapsed: %q", out)
+	}
+	press(m, "d")
+	if out := m.Render(100, 6); !strings.Contains(out, "diff --git a/root") {
+		t.Errorf("full diff not shown: %q", out)
+	}
+}
+
+func TestDecodeKeys(t *testing.T) {
+	got := DecodeKeys([]byte("\x1b[Aa\r\x1b[6~\x1b\x7fé"))
+	want := []Key{
+		{Code: KeyUp},
+		{Code: KeyRune, Rune: 'a'},
+		{Code: KeyEnter},
+		{Code: KeyPageDown},
+		{Code: KeyEscape},
+		{Code: KeyBackspace},
+		{Code: KeyRune, Rune: 'é'},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("DecodeKeys: got %+v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("key %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
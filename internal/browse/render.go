@@ -0,0 +1,107 @@
+package browse
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	reverseVideo = "\x1b[7m"
+	resetStyle   = "\x1b[0m"
+	helpLine     = "↑↓ move  →/enter expand  ← collapse  / search  n next  d diff/stat  J/K scroll  y copy  u unsquash  q quit"
+)
+
+// Render draws the model as width x height terminal lines: the tree on the left, the
+// selected commit's details on the right and a status line at the bottom.
+func (m *Model) Render(width, height int) string {
+	if width < 20 || height < 3 {
+		return "terminal too small"
+	}
+	bodyHeight := height - 1
+	leftWidth := width * 45 / 100
+	rightWidth := width - leftWidth - 3
+
+	if m.cursor < m.top {
+		m.top = m.cursor
+	}
+	if m.cursor >= m.top+bodyHeight {
+		m.top = m.cursor - bodyHeight + 1
+	}
+
+	detail := strings.Split(strings.TrimRight(m.detailText(m.Selected()), "\n"), "\n")
+	if m.scroll > len(detail)-1 {
+		m.scroll = len(detail) - 1
+	}
+	if m.scroll < 0 {
+		m.scroll = 0
+	}
+	detail = detail[m.scroll:]
+
+	var b strings.Builder
+	for i := 0; i < bodyHeight; i++ {
+		left := ""
+		idx := m.top + i
+		if idx < len(m.rows) {
+			left = fit(m.rowLabel(m.rows[idx]), leftWidth)
+			if idx == m.cursor {
+				left = reverseVideo + left + resetStyle
+			}
+		} else {
+			left = fit("", leftWidth)
+		}
+		right := ""
+		if i < len(detail) {
+			right = fit(strings.ReplaceAll(detail[i], "\t", "    "), rightWidth)
+		}
+		b.WriteString(left)
+		b.WriteString(" │ ")
+		b.WriteString(strings.TrimRight(right, " "))
+		b.WriteString("\r\n")
+	}
+
+	switch {
+	case m.mode == modeSearch:
+		b.WriteString(fit("/"+m.input, width))
+	case m.mode == modeConfirmUnsquash:
+		b.WriteString(fit(fmt.Sprintf("Unsquash %s onto a new branch? [y/N]", m.Selected().Hash), width))
+	case m.status != "":
+		b.WriteString(fit(m.status, width))
+	default:
+		b.WriteString(fit(helpLine, width))
+	}
+	return b.String()
+}
+
+func (m *Model) rowLabel(r row) string {
+	n := r.node
+	marker := "  "
+	if !r.repeat && expandable(n) {
+		if m.expanded[n] {
+			marker = "- "
+		} else {
+			marker = "+ "
+		}
+	}
+	label := fmt.Sprintf("%s%s%s [%s]", r.prefix, marker, n.Hash, strings.ToUpper(n.Type.String()))
+	if n.Message != "" {
+		label += "  " + n.Message
+	}
+	if r.repeat {
+		label += "  (see above)"
+	}
+	return label
+}
+
+// fit truncates or pads s to exactly width runes.
+func fit(s string, width int) string {
+	n := utf8.RuneCountInString(s)
+	if n > width {
+		r := []rune(s)
+		if width <= 1 {
+			return string(r[:width])
+		}
+		return string(r[:width-1]) + "…"
+	}
+	return s + strings.Repeat(" ", width-n)
+}
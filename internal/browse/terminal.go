@@ -0,0 +1,82 @@
+package browse
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	enterAltScreen = "\x1b[?1049h\x1b[?25l"
+	exitAltScreen  = "\x1b[?25h\x1b[?1049l"
+	clearScreen    = "\x1b[H\x1b[2J"
+)
+
+// terminal is the controlling terminal in raw mode. Raw mode is set with stty(1),
+// so the browser works on Unix-like systems without extra dependencies.
+type terminal struct {
+	tty   *os.File
+	saved string
+}
+
+func openTerminal() (*terminal, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("browse needs an interactive terminal: %w", err)
+	}
+	t := &terminal{tty: tty}
+	saved, err := t.stty("-g")
+	if err != nil {
+		tty.Close()
+		return nil, fmt.Errorf("read terminal settings: %w", err)
+	}
+	t.saved = strings.TrimSpace(saved)
+	if _, err := t.stty("raw", "-echo"); err != nil {
+		tty.Close()
+		return nil, fmt.Errorf("set raw mode: %w", err)
+	}
+	fmt.Fprint(tty, enterAltScreen)
+	return t, nil
+}
+
+func (t *terminal) stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = t.tty
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+func (t *terminal) size() (width, height int) {
+	out, err := t.stty("size")
+	if err == nil {
+		if _, err := fmt.Sscan(out, &height, &width); err == nil && width > 0 && height > 0 {
+			return width, height
+		}
+	}
+	return 80, 24
+}
+
+func (t *terminal) draw(screen string) {
+	fmt.Fprint(t.tty, clearScreen+screen)
+}
+
+func (t *terminal) read() ([]byte, error) {
+	buf := make([]byte, 64)
+	n, err := t.tty.Read(buf)
+	return buf[:n], err
+}
+
+// copy puts text on the clipboard with an OSC 52 escape, which terminals forward
+// to the system clipboard (also over ssh).
+func (t *terminal) copy(text string) error {
+	_, err := fmt.Fprintf(t.tty, "\x1b]52;c;%s\a", base64.StdEncoding.EncodeToString([]byte(text)))
+	return err
+}
+
+func (t *terminal) close() {
+	fmt.Fprint(t.tty, exitAltScreen)
+	t.stty(t.saved)
+	t.tty.Close()
+}
@@ -1,9 +1,10 @@
 package git
 
 import (
+	"context"
 	"fmt"
-	"os/exec"
-	"strings"
+
+	"github.com/widefix/squash-tree/internal/backend"
 )
 
 const (
@@ -14,40 +15,51 @@ func PreservationRefName(rootFullSHA, childFullSHA string) string {
 	return ArchiveRefPrefix + rootFullSHA + "/" + childFullSHA
 }
 
-func FullHash(repoPath, ref string) (string, error) {
-	cmd := exec.Command("git", "rev-parse", ref)
-	if repoPath != "" {
-		cmd.Dir = repoPath
-	}
-	output, err := cmd.Output()
-	if err != nil {
-		return "", fmt.Errorf("git rev-parse %s: %w", ref, err)
-	}
-	return strings.TrimSpace(string(output)), nil
+func FullHash(ctx context.Context, repoPath, ref string) (string, error) {
+	return backend.For(repoPath).RevParse(ctx, ref)
 }
 
-func CreatePreservationRefs(repoPath, rootFullSHA string, childFullSHAs []string) error {
+func CreatePreservationRefs(ctx context.Context, repoPath, rootFullSHA string, childFullSHAs []string) error {
+	return backend.For(repoPath).UpdateRefs(ctx, preservationUpdates(rootFullSHA, childFullSHAs))
+}
+
+// preservationUpdates returns one ref update per distinct child; a ref transaction
+// rejects two updates of the same ref.
+func preservationUpdates(rootFullSHA string, childFullSHAs []string) []backend.RefUpdate {
+	var updates []backend.RefUpdate
+	seen := make(map[string]bool)
 	for _, child := range childFullSHAs {
-		refName := PreservationRefName(rootFullSHA, child)
-		cmd := exec.Command("git", "update-ref", refName, child)
-		if repoPath != "" {
-			cmd.Dir = repoPath
+		if !seen[child] {
+			seen[child] = true
+			updates = append(updates, backend.RefUpdate{Ref: PreservationRefName(rootFullSHA, child), New: child})
 		}
-		if out, err := cmd.CombinedOutput(); err != nil {
-			return fmt.Errorf("git update-ref %s %s: %w: %s", refName, child, err, string(out))
+	}
+	return updates
+}
+
+// stalePreservationRefs returns deletions for the preservation refs of root whose
+// child is not in keep.
+func stalePreservationRefs(ctx context.Context, repoPath, rootFullSHA string, keep []string) ([]backend.RefUpdate, error) {
+	refs, err := backend.For(repoPath).ListRefs(ctx, ArchiveRefPrefix+rootFullSHA+"/")
+	if err != nil {
+		return nil, fmt.Errorf("list preservation refs: %w", err)
+	}
+	kept := make(map[string]bool, len(keep))
+	for _, child := range keep {
+		kept[PreservationRefName(rootFullSHA, child)] = true
+	}
+	var updates []backend.RefUpdate
+	for _, ref := range refs {
+		if !kept[ref] {
+			updates = append(updates, backend.RefUpdate{Ref: ref, New: backend.ZeroHash})
 		}
 	}
-	return nil
+	return updates, nil
 }
 
-func PreservationRefsExist(repoPath, rootFullSHA string, childFullSHAs []string) (bool, error) {
+func PreservationRefsExist(ctx context.Context, repoPath, rootFullSHA string, childFullSHAs []string) (bool, error) {
 	for _, child := range childFullSHAs {
-		refName := PreservationRefName(rootFullSHA, child)
-		cmd := exec.Command("git", "show-ref", "--verify", "--quiet", refName)
-		if repoPath != "" {
-			cmd.Dir = repoPath
-		}
-		if err := cmd.Run(); err != nil {
+		if !backend.For(repoPath).RefExists(ctx, PreservationRefName(rootFullSHA, child)) {
 			return false, nil
 		}
 	}
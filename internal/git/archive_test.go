@@ -1,6 +1,7 @@
 package git
 
 import (
+	"context"
 	"os/exec"
 	"strings"
 	"testing"
@@ -13,7 +14,7 @@ func TestFullHash(t *testing.T) {
 
 	shortHash := makeCommit(t, repoPath, "test commit")
 
-	fullHash, err := FullHash(repoPath, shortHash)
+	fullHash, err := FullHash(context.Background(), repoPath, shortHash)
 	if err != nil {
 		t.Fatalf("FullHash: %v", err)
 	}
@@ -26,7 +27,7 @@ func TestFullHash(t *testing.T) {
 		t.Errorf("FullHash: %q is not a prefix of full hash %q", shortHash, fullHash)
 	}
 
-	headFull, err := FullHash(repoPath, "HEAD")
+	headFull, err := FullHash(context.Background(), repoPath, "HEAD")
 	if err != nil {
 		t.Fatalf("FullHash(HEAD): %v", err)
 	}
@@ -42,7 +43,7 @@ func TestFullHash_InvalidRef(t *testing.T) {
 
 	makeCommit(t, repoPath, "initial")
 
-	_, err := FullHash(repoPath, "nonexistent-ref")
+	_, err := FullHash(context.Background(), repoPath, "nonexistent-ref")
 	if err == nil {
 		t.Error("FullHash(nonexistent): expected error, got nil")
 	}
@@ -69,11 +70,11 @@ func TestCreatePreservationRefs(t *testing.T) {
 	hash2 := makeCommitUnique(t, repoPath, "commit 2", "2")
 	hash3 := makeCommitUnique(t, repoPath, "commit 3", "3")
 
-	fullRoot, _ := FullHash(repoPath, hash1)
-	fullChild1, _ := FullHash(repoPath, hash2)
-	fullChild2, _ := FullHash(repoPath, hash3)
+	fullRoot, _ := FullHash(context.Background(), repoPath, hash1)
+	fullChild1, _ := FullHash(context.Background(), repoPath, hash2)
+	fullChild2, _ := FullHash(context.Background(), repoPath, hash3)
 
-	err := CreatePreservationRefs(repoPath, fullRoot, []string{fullChild1, fullChild2})
+	err := CreatePreservationRefs(context.Background(), repoPath, fullRoot, []string{fullChild1, fullChild2})
 	if err != nil {
 		t.Fatalf("CreatePreservationRefs: %v", err)
 	}
@@ -117,13 +118,13 @@ func TestCreatePreservationRefs_Idempotent(t *testing.T) {
 	hash1 := makeCommit(t, repoPath, "commit 1")
 	hash2 := makeCommitUnique(t, repoPath, "commit 2", "2")
 
-	fullRoot, _ := FullHash(repoPath, hash1)
-	fullChild, _ := FullHash(repoPath, hash2)
+	fullRoot, _ := FullHash(context.Background(), repoPath, hash1)
+	fullChild, _ := FullHash(context.Background(), repoPath, hash2)
 
-	if err := CreatePreservationRefs(repoPath, fullRoot, []string{fullChild}); err != nil {
+	if err := CreatePreservationRefs(context.Background(), repoPath, fullRoot, []string{fullChild}); err != nil {
 		t.Fatalf("CreatePreservationRefs (1st): %v", err)
 	}
-	if err := CreatePreservationRefs(repoPath, fullRoot, []string{fullChild}); err != nil {
+	if err := CreatePreservationRefs(context.Background(), repoPath, fullRoot, []string{fullChild}); err != nil {
 		t.Fatalf("CreatePreservationRefs (2nd): %v", err)
 	}
 }
@@ -136,10 +137,10 @@ func TestPreservationRefsExist(t *testing.T) {
 	hash1 := makeCommit(t, repoPath, "commit 1")
 	hash2 := makeCommitUnique(t, repoPath, "commit 2", "2")
 
-	fullRoot, _ := FullHash(repoPath, hash1)
-	fullChild, _ := FullHash(repoPath, hash2)
+	fullRoot, _ := FullHash(context.Background(), repoPath, hash1)
+	fullChild, _ := FullHash(context.Background(), repoPath, hash2)
 
-	exists, err := PreservationRefsExist(repoPath, fullRoot, []string{fullChild})
+	exists, err := PreservationRefsExist(context.Background(), repoPath, fullRoot, []string{fullChild})
 	if err != nil {
 		t.Fatalf("PreservationRefsExist: %v", err)
 	}
@@ -147,11 +148,11 @@ func TestPreservationRefsExist(t *testing.T) {
 		t.Error("PreservationRefsExist: expected false before creation")
 	}
 
-	if err := CreatePreservationRefs(repoPath, fullRoot, []string{fullChild}); err != nil {
+	if err := CreatePreservationRefs(context.Background(), repoPath, fullRoot, []string{fullChild}); err != nil {
 		t.Fatalf("CreatePreservationRefs: %v", err)
 	}
 
-	exists, err = PreservationRefsExist(repoPath, fullRoot, []string{fullChild})
+	exists, err = PreservationRefsExist(context.Background(), repoPath, fullRoot, []string{fullChild})
 	if err != nil {
 		t.Fatalf("PreservationRefsExist: %v", err)
 	}
@@ -173,16 +174,16 @@ func TestWriteMetadata_CreatesPreservationRefs(t *testing.T) {
 	baseShort := child1Short
 
 	children := []string{child1Short, child2Short}
-	err := WriteMetadata(repoPath, rootShort, baseShort, children, "test")
+	err := WriteMetadata(context.Background(), repoPath, rootShort, baseShort, children, "test")
 	if err != nil {
 		t.Fatalf("WriteMetadata: %v", err)
 	}
 
-	rootFull, _ := FullHash(repoPath, rootShort)
-	child1Full, _ := FullHash(repoPath, child1Short)
-	child2Full, _ := FullHash(repoPath, child2Short)
+	rootFull, _ := FullHash(context.Background(), repoPath, rootShort)
+	child1Full, _ := FullHash(context.Background(), repoPath, child1Short)
+	child2Full, _ := FullHash(context.Background(), repoPath, child2Short)
 
-	exists, err := PreservationRefsExist(repoPath, rootFull, []string{child1Full, child2Full})
+	exists, err := PreservationRefsExist(context.Background(), repoPath, rootFull, []string{child1Full, child2Full})
 	if err != nil {
 		t.Fatalf("PreservationRefsExist: %v", err)
 	}
@@ -191,6 +192,50 @@ func TestWriteMetadata_CreatesPreservationRefs(t *testing.T) {
 	}
 }
 
+func TestWriteMetadata_AtomicOnFailure(t *testing.T) {
+	requireGit(t)
+	repoPath, cleanup := initTempRepo(t)
+	defer cleanup()
+
+	makeCommit(t, repoPath, "base")
+	child1Short := makeCommitUnique(t, repoPath, "child1", "c1")
+	child2Short := makeCommitUnique(t, repoPath, "child2", "c2")
+	rootShort := makeCommitUnique(t, repoPath, "squash", "sq")
+
+	ctx := context.Background()
+	rootFull, _ := FullHash(ctx, repoPath, rootShort)
+	child1Full, _ := FullHash(ctx, repoPath, child1Short)
+	child2Full, _ := FullHash(ctx, repoPath, child2Short)
+
+	// A ref below the second archive ref's name makes the transaction fail after the
+	// notes commit has been built.
+	blocker := PreservationRefName(rootFull, child2Full) + "/blocker"
+	if out, err := exec.Command("git", "-C", repoPath, "update-ref", blocker, child2Full).CombinedOutput(); err != nil {
+		t.Fatalf("update-ref: %v %s", err, out)
+	}
+
+	err := WriteMetadata(ctx, repoPath, rootShort, child1Short, []string{child1Short, child2Short}, "test")
+	if err == nil {
+		t.Fatal("WriteMetadata: expected error while an archive ref is blocked")
+	}
+	if NewNotesReader(repoPath).HasMetadata(ctx, rootShort) {
+		t.Error("note written although the archive refs were not")
+	}
+	if exists, _ := PreservationRefsExist(ctx, repoPath, rootFull, []string{child1Full}); exists {
+		t.Error("archive ref written although the transaction failed")
+	}
+
+	if out, err := exec.Command("git", "-C", repoPath, "update-ref", "-d", blocker).CombinedOutput(); err != nil {
+		t.Fatalf("update-ref -d: %v %s", err, out)
+	}
+	if err := WriteMetadata(ctx, repoPath, rootShort, child1Short, []string{child1Short, child2Short}, "test"); err != nil {
+		t.Fatalf("WriteMetadata retry: %v", err)
+	}
+	if exists, _ := PreservationRefsExist(ctx, repoPath, rootFull, []string{child1Full, child2Full}); !exists {
+		t.Error("retry did not create preservation refs")
+	}
+}
+
 func makeCommitUnique(t *testing.T, repoPath, msg, uniqueContent string) string {
 	t.Helper()
 
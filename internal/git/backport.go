@@ -0,0 +1,57 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/widefix/squash-tree/internal/backend"
+)
+
+// cherryPickedPrefix starts the line `git cherry-pick -x` appends to the message.
+const cherryPickedPrefix = "(cherry picked from commit "
+
+// CherryPickedFrom returns the commit named by the last "(cherry picked from commit X)"
+// line in rev's message, the one added by the most recent pick, or "" if there is none.
+func CherryPickedFrom(ctx context.Context, repoPath, rev string) (string, error) {
+	out, err := backend.Command(ctx, repoPath, "log", "-1", "--format=%B", rev).Output()
+	if err != nil {
+		return "", fmt.Errorf("git log %s: %w", rev, err)
+	}
+	source := ""
+	for _, line := range strings.Split(string(out), "\n") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(line), cherryPickedPrefix); ok {
+			source = strings.TrimSuffix(v, ")")
+		}
+	}
+	return source, nil
+}
+
+// RecordBackport copies the metadata of source, a squash commit, to rev, a cherry-pick
+// of it: root, message and identities come from rev, base and children stay those of
+// source, and derived_from names source. Preservation refs for the children are created
+// under rev in the same transaction. It reports false without writing anything if
+// source has no metadata or rev already has some.
+func RecordBackport(ctx context.Context, repoPath, rev, source string) (bool, error) {
+	nr := NewNotesReader(repoPath)
+	if !nr.HasMetadata(ctx, source) || nr.HasMetadata(ctx, rev) {
+		return false, nil
+	}
+	meta, err := nr.ReadMetadata(ctx, source)
+	if err != nil {
+		return false, fmt.Errorf("read metadata of %s: %w", source, err)
+	}
+	root, err := backend.For(repoPath).ShortHash(ctx, rev)
+	if err != nil {
+		return false, fmt.Errorf("resolve %s: %w", rev, err)
+	}
+	from := meta.Root
+	if err := moveRoot(ctx, repoPath, meta, root); err != nil {
+		return false, err
+	}
+	meta.DerivedFrom, meta.RewrittenFrom = from, ""
+	if err := WriteSquashMetadata(ctx, repoPath, meta); err != nil {
+		return false, fmt.Errorf("record %s: %w", root, err)
+	}
+	return true, nil
+}
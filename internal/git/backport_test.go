@@ -0,0 +1,72 @@
+package git
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRecordBackport_CopiesSquashMetadata(t *testing.T) {
+	requireGit(t)
+	repoPath, cleanup := initTempRepo(t)
+	defer cleanup()
+	ctx := context.Background()
+	nr := NewNotesReader(repoPath)
+
+	base := makeCommit(t, repoPath, "base")
+	run(t, repoPath, "branch", "release")
+	c1 := commitFile(t, repoPath, "one")
+	c2 := commitFile(t, repoPath, "two")
+	res, err := Squash(ctx, repoPath, base, "HEAD", SquashOptions{Message: "feature"})
+	if err != nil {
+		t.Fatalf("Squash: %v", err)
+	}
+	run(t, repoPath, "checkout", "-q", "release")
+	run(t, repoPath, "cherry-pick", "-x", res.Commit)
+
+	source, err := CherryPickedFrom(ctx, repoPath, "HEAD")
+	if err != nil || source != res.Commit {
+		t.Fatalf("CherryPickedFrom = %q, %v; want %s", source, err, res.Commit)
+	}
+	if ok, err := RecordBackport(ctx, repoPath, "HEAD", source); err != nil || !ok {
+		t.Fatalf("RecordBackport = %v, %v", ok, err)
+	}
+	meta, err := nr.ReadMetadata(ctx, "HEAD")
+	if err != nil {
+		t.Fatalf("ReadMetadata: %v", err)
+	}
+	if meta.Spec != "squash-tree/v1.1" {
+		t.Errorf("spec = %s, want squash-tree/v1.1 for derived_from", meta.Spec)
+	}
+	if meta.DerivedFrom != res.Metadata.Root || meta.Base != base || meta.Strategy != StrategyManual ||
+		len(meta.Children) != 2 || meta.Children[0].Hash != c1 || meta.Children[1].Hash != c2 {
+		t.Errorf("backport metadata = %+v", meta)
+	}
+	head, _ := FullHash(ctx, repoPath, "HEAD")
+	childFulls := []string{}
+	for _, c := range []string{c1, c2} {
+		full, _ := FullHash(ctx, repoPath, c)
+		childFulls = append(childFulls, full)
+	}
+	if ok, err := PreservationRefsExist(ctx, repoPath, head, childFulls); err != nil || !ok {
+		t.Errorf("preservation refs under the backport missing: %v", err)
+	}
+	if ok, err := RecordBackport(ctx, repoPath, "HEAD", source); err != nil || ok {
+		t.Errorf("second RecordBackport = %v, %v; want false", ok, err)
+	}
+}
+
+func TestCherryPickedFrom_LastLineWins(t *testing.T) {
+	requireGit(t)
+	repoPath, cleanup := initTempRepo(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	makeCommit(t, repoPath, "base")
+	run(t, repoPath, "commit", "-q", "--allow-empty", "-m", "fix\n\n(cherry picked from commit aaaa)\n(cherry picked from commit bbbb)")
+	if source, err := CherryPickedFrom(ctx, repoPath, "HEAD"); err != nil || source != "bbbb" {
+		t.Errorf("CherryPickedFrom = %q, %v; want bbbb", source, err)
+	}
+	if source, err := CherryPickedFrom(ctx, repoPath, "HEAD^"); err != nil || source != "" {
+		t.Errorf("CherryPickedFrom(plain commit) = %q, %v; want none", source, err)
+	}
+}
@@ -0,0 +1,194 @@
+package git
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/widefix/squash-tree/internal/backend"
+)
+
+// StrategyCherryPick marks metadata recorded for a commit made after
+// `git cherry-pick --no-commit` of several commits.
+const StrategyCherryPick = "cherry-pick"
+
+// cherryPickFile holds a no-commit cherry-pick session in the worktree's git
+// directory: the HEAD the commits are picked onto, the commits picked so far, the
+// sequencer todo list last seen and, once a commit has started while git's MERGE_MSG
+// was present, "commit".
+const cherryPickFile = "SQUASH_TREE_PICKS"
+
+type cherryPickSession struct {
+	head       string
+	picks      []string
+	todo       []string
+	committing bool
+}
+
+// RecordCherryPickStep runs whenever the index is written (post-index-change). During
+// `git cherry-pick --no-commit` of several commits, git's sequencer state lists the
+// remaining picks, the first being the one just applied; that commit is appended to the
+// session. A todo list that is not a continuation of the one last seen starts a new
+// session. Outside such a cherry-pick it does nothing.
+func RecordCherryPickStep(ctx context.Context, repoPath string) error {
+	gitDir, err := GitDir(ctx, repoPath)
+	if err != nil {
+		return err
+	}
+	seq := filepath.Join(gitDir, "sequencer")
+	opts, err := os.ReadFile(filepath.Join(seq, "opts"))
+	if err != nil || !strings.Contains(string(opts), "no-commit = true") {
+		return nil
+	}
+	head, err := os.ReadFile(filepath.Join(seq, "head"))
+	if err != nil {
+		return nil
+	}
+	todo, err := sequencerPicks(filepath.Join(seq, "todo"))
+	if err != nil || len(todo) == 0 {
+		return err
+	}
+	pick, err := FullHash(ctx, repoPath, todo[0])
+	if err != nil {
+		return fmt.Errorf("resolve picked commit %s: %w", todo[0], err)
+	}
+
+	session, ok, err := readCherryPick(gitDir)
+	if err != nil {
+		return err
+	}
+	headFull := strings.TrimSpace(string(head))
+	continued := ok && session.head == headFull && len(todo) <= len(session.todo) &&
+		slices.Equal(todo, session.todo[len(session.todo)-len(todo):])
+	if !continued {
+		session = cherryPickSession{head: headFull}
+	}
+	if n := len(session.picks); n == 0 || session.picks[n-1] != pick {
+		session.picks = append(session.picks, pick)
+	}
+	session.todo = todo
+	return writeCherryPick(gitDir, session)
+}
+
+// sequencerPicks returns the commits of the pick commands in a sequencer todo list.
+func sequencerPicks(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var picks []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) >= 2 && (fields[0] == "pick" || fields[0] == "p") {
+			picks = append(picks, fields[1])
+		}
+	}
+	return picks, sc.Err()
+}
+
+// PrepareCherryPickCommit runs when a commit starts. If MERGE_MSG, which git keeps
+// after a no-commit cherry-pick and removes on reset or abort, is present, the session
+// is marked for recording; otherwise it is dropped.
+func PrepareCherryPickCommit(ctx context.Context, repoPath string) error {
+	gitDir, err := GitDir(ctx, repoPath)
+	if err != nil {
+		return err
+	}
+	session, ok, err := readCherryPick(gitDir)
+	if err != nil || !ok {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(gitDir, "MERGE_MSG")); err != nil {
+		return os.Remove(filepath.Join(gitDir, cherryPickFile))
+	}
+	session.committing = true
+	return writeCherryPick(gitDir, session)
+}
+
+// FinishCherryPick runs after a commit. If the commit was prepared from a session of
+// two or more picks and its parent is the HEAD they were picked onto, it records
+// metadata with base = that HEAD and children = the picked commits in order, and
+// reports true. The session is removed once a commit has been made from it.
+func FinishCherryPick(ctx context.Context, repoPath string) (bool, error) {
+	gitDir, err := GitDir(ctx, repoPath)
+	if err != nil {
+		return false, err
+	}
+	session, ok, err := readCherryPick(gitDir)
+	if err != nil || !ok || !session.committing {
+		return false, err
+	}
+	os.Remove(filepath.Join(gitDir, cherryPickFile))
+
+	parent, err := FullHash(ctx, repoPath, "HEAD^")
+	if err != nil || parent != session.head || len(session.picks) < 2 {
+		return false, nil
+	}
+	head, err := FullHash(ctx, repoPath, "HEAD")
+	if err != nil {
+		return false, err
+	}
+	b := backend.For(repoPath)
+	shorts, err := b.ShortHashes(ctx, append([]string{head, session.head}, session.picks...))
+	if err != nil {
+		return false, err
+	}
+	if NewNotesReader(repoPath).HasMetadata(ctx, shorts[0]) {
+		return false, nil
+	}
+	if err := WriteMetadata(ctx, repoPath, shorts[0], shorts[1], shorts[2:], StrategyCherryPick); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func readCherryPick(gitDir string) (cherryPickSession, bool, error) {
+	data, err := os.ReadFile(filepath.Join(gitDir, cherryPickFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return cherryPickSession{}, false, nil
+	}
+	if err != nil {
+		return cherryPickSession{}, false, err
+	}
+	var s cherryPickSession
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "head":
+			s.head = value
+		case "pick":
+			s.picks = append(s.picks, value)
+		case "todo":
+			s.todo = strings.Fields(value)
+		case "commit":
+			s.committing = true
+		}
+	}
+	if s.head == "" {
+		return cherryPickSession{}, false, fmt.Errorf("malformed %s", cherryPickFile)
+	}
+	return s, true, nil
+}
+
+func writeCherryPick(gitDir string, s cherryPickSession) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "head %s\n", s.head)
+	for _, p := range s.picks {
+		fmt.Fprintf(&b, "pick %s\n", p)
+	}
+	fmt.Fprintf(&b, "todo %s\n", strings.Join(s.todo, " "))
+	if s.committing {
+		b.WriteString("commit\n")
+	}
+	return os.WriteFile(filepath.Join(gitDir, cherryPickFile), []byte(b.String()), 0644)
+}
@@ -0,0 +1,113 @@
+package git
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// cherryPickRepo returns a repo on main with three commits on branch src, each adding
+// its own file, plus the full hash of main's HEAD and the short hashes of the picks.
+func cherryPickRepo(t *testing.T) (repoPath, head string, picks []string) {
+	t.Helper()
+	requireGit(t)
+	repoPath, cleanup := initTempRepo(t)
+	t.Cleanup(cleanup)
+
+	makeCommit(t, repoPath, "base")
+	run(t, repoPath, "checkout", "-q", "-b", "src")
+	for _, name := range []string{"b", "c", "d"} {
+		picks = append(picks, commitFile(t, repoPath, name))
+	}
+	run(t, repoPath, "checkout", "-q", "-")
+	head, err := FullHash(context.Background(), repoPath, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repoPath, head, picks
+}
+
+// pickSteps replays the post-index-change calls git makes while it applies picks
+// with --no-commit: the sequencer todo list shrinks by one commit per step.
+func pickSteps(t *testing.T, repoPath, head string, picks []string) {
+	t.Helper()
+	seq := filepath.Join(repoPath, ".git", "sequencer")
+	if err := os.MkdirAll(seq, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(seq)
+	os.WriteFile(filepath.Join(seq, "opts"), []byte("[options]\n\tno-commit = true\n"), 0644)
+	os.WriteFile(filepath.Join(seq, "head"), []byte(head+"\n"), 0644)
+	for i := range picks {
+		var todo strings.Builder
+		for _, p := range picks[i:] {
+			todo.WriteString("pick " + p + " msg\n")
+		}
+		os.WriteFile(filepath.Join(seq, "todo"), []byte(todo.String()), 0644)
+		// The index is usually written more than once per pick.
+		for n := 0; n < 2; n++ {
+			if err := RecordCherryPickStep(context.Background(), repoPath); err != nil {
+				t.Fatalf("RecordCherryPickStep: %v", err)
+			}
+		}
+	}
+}
+
+func TestCherryPickNoCommit_RecordsCombinedCommit(t *testing.T) {
+	repoPath, head, picks := cherryPickRepo(t)
+	ctx := context.Background()
+
+	// An aborted earlier session is replaced when the picks start over.
+	pickSteps(t, repoPath, head, picks[1:])
+	pickSteps(t, repoPath, head, picks)
+	run(t, repoPath, append([]string{"cherry-pick", "-n"}, picks...)...)
+
+	if err := PrepareCherryPickCommit(ctx, repoPath); err != nil {
+		t.Fatalf("PrepareCherryPickCommit: %v", err)
+	}
+	run(t, repoPath, "commit", "-q", "-m", "backport b, c and d")
+	recorded, err := FinishCherryPick(ctx, repoPath)
+	if err != nil || !recorded {
+		t.Fatalf("FinishCherryPick = %v, %v", recorded, err)
+	}
+
+	meta, err := NewNotesReader(repoPath).ReadMetadata(ctx, "HEAD")
+	if err != nil {
+		t.Fatalf("ReadMetadata: %v", err)
+	}
+	if full, _ := FullHash(ctx, repoPath, meta.Base); meta.Strategy != StrategyCherryPick || full != head {
+		t.Errorf("strategy %q base %q, want %q %s", meta.Strategy, meta.Base, StrategyCherryPick, head)
+	}
+	if len(meta.Children) != 3 {
+		t.Fatalf("children = %+v, want %v", meta.Children, picks)
+	}
+	for i, c := range meta.Children {
+		if c.Hash != picks[i] {
+			t.Errorf("child %d = %s, want %s", i, c.Hash, picks[i])
+		}
+	}
+	if _, err := os.Stat(filepath.Join(repoPath, ".git", cherryPickFile)); !os.IsNotExist(err) {
+		t.Error("cherry-pick session left behind")
+	}
+}
+
+func TestCherryPickNoCommit_ResetDiscardsSession(t *testing.T) {
+	repoPath, head, picks := cherryPickRepo(t)
+	ctx := context.Background()
+
+	pickSteps(t, repoPath, head, picks[:2])
+	run(t, repoPath, "cherry-pick", "-n", picks[0], picks[1])
+	run(t, repoPath, "reset", "-q", "--hard")
+	makeCommitUnique(t, repoPath, "unrelated", "u")
+	if err := PrepareCherryPickCommit(ctx, repoPath); err != nil {
+		t.Fatalf("PrepareCherryPickCommit: %v", err)
+	}
+	if recorded, err := FinishCherryPick(ctx, repoPath); err != nil || recorded {
+		t.Errorf("FinishCherryPick after reset = %v, %v; want false", recorded, err)
+	}
+	if NewNotesReader(repoPath).HasMetadata(ctx, "HEAD") {
+		t.Error("unrelated commit was recorded as a cherry-pick")
+	}
+}
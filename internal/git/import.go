@@ -0,0 +1,97 @@
+package git
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/widefix/squash-tree/internal/metadata"
+	"github.com/widefix/squash-tree/internal/repo"
+)
+
+// ImportResult reports what Import did with the records of an import file.
+type ImportResult struct {
+	Imported int
+	// Skipped lists the records whose squash commit already had metadata.
+	Skipped []metadata.ImportRecord
+	// Errors holds one error per record that could not be read or imported.
+	Errors []*metadata.RecordError
+}
+
+// Import records the squashes read from r as JSON lines (see docs/spec.md). A bad
+// record is reported in the result and does not stop the others; with dryRun the
+// records are only validated. Only a failure to read r is returned as an error.
+func Import(ctx context.Context, repoPath string, r io.Reader, dryRun bool) (*ImportResult, error) {
+	records, recordErrs, err := metadata.ReadImportRecords(r)
+	if err != nil {
+		return nil, err
+	}
+	res := &ImportResult{Errors: recordErrs}
+	notesReader := NewNotesReader(repoPath)
+	for _, rec := range records {
+		if notesReader.HasMetadata(ctx, rec.Squash) {
+			res.Skipped = append(res.Skipped, rec)
+			continue
+		}
+		if err := importRecord(ctx, repoPath, rec, dryRun); err != nil {
+			res.Errors = append(res.Errors, &metadata.RecordError{Line: rec.Line, Err: err})
+			continue
+		}
+		res.Imported++
+	}
+	return res, nil
+}
+
+func importRecord(ctx context.Context, repoPath string, rec metadata.ImportRecord, dryRun bool) error {
+	rootShort, err := repo.ResolveCommitHash(ctx, repoPath, rec.Squash)
+	if err != nil {
+		return fmt.Errorf("invalid squash commit: %w", err)
+	}
+	baseShort, err := repo.ResolveCommitHash(ctx, repoPath, rec.Base)
+	if err != nil {
+		return fmt.Errorf("invalid base: %w", err)
+	}
+	childrenShort, err := repo.ResolveRefs(ctx, repoPath, rec.Children)
+	if err != nil {
+		return fmt.Errorf("children: %w", err)
+	}
+
+	meta, err := BuildMetadata(ctx, repoPath, rootShort, baseShort, childrenShort, rec.Strategy)
+	if err != nil {
+		return err
+	}
+	if rec.CreatedAt != "" {
+		meta.CreatedAt = rec.CreatedAt
+	}
+	if rec.Author != "" || rec.PR != 0 || rec.URL != "" {
+		var fields metadata.V2Fields
+		if rec.Author != "" {
+			author, err := metadata.ParseIdentity(rec.Author)
+			if err != nil {
+				return fmt.Errorf("author: %w", err)
+			}
+			fields.Author = &author
+		}
+		if rec.PR != 0 || rec.URL != "" {
+			ref := metadata.Reference{Kind: metadata.RefKindPR, URL: rec.URL}
+			if rec.PR != 0 {
+				ref.ID = strconv.Itoa(rec.PR)
+			}
+			fields.Refs = []metadata.Reference{ref}
+		}
+		if err := UpgradeToV2(ctx, repoPath, meta, fields); err != nil {
+			return err
+		}
+	}
+	if dryRun {
+		data, err := json.Marshal(meta)
+		if err != nil {
+			return err
+		}
+		_, err = metadata.Parse(data)
+		return err
+	}
+	return WriteSquashMetadata(ctx, repoPath, meta)
+}
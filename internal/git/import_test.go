@@ -0,0 +1,68 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestImport_DryRunThenImport(t *testing.T) {
+	requireGit(t)
+	repoPath, cleanup := initTempRepo(t)
+	defer cleanup()
+
+	base := makeCommit(t, repoPath, "base")
+	c1 := makeCommitUnique(t, repoPath, "c1", "1")
+	c2 := makeCommitUnique(t, repoPath, "c2", "2")
+	root1 := makeCommitUnique(t, repoPath, "squash1", "s1")
+	root2 := makeCommitUnique(t, repoPath, "squash2", "s2")
+	input := fmt.Sprintf(`{"squash": %q, "base": %q, "children": [%q]}
+not json
+{"squash": "deadbeefdeadbeef", "base": %q, "children": [%q]}
+{"squash": %q, "base": %q, "children": [%q], "author": "A <a@example.com>", "pr": 7}
+`, root1, base, c1, base, c1, root2, c1, c2)
+	ctx := context.Background()
+
+	res, err := Import(ctx, repoPath, strings.NewReader(input), true)
+	if err != nil {
+		t.Fatalf("Import dry run: %v", err)
+	}
+	if res.Imported != 2 || len(res.Errors) != 2 || res.Errors[0].Line != 2 || res.Errors[1].Line != 3 {
+		t.Fatalf("dry run result: %+v", res)
+	}
+	if annotated, _ := NewNotesReader(repoPath).ListAnnotated(ctx); len(annotated) != 0 {
+		t.Errorf("dry run wrote notes for %v", annotated)
+	}
+	if refs := run(t, repoPath, "for-each-ref", ArchiveRefPrefix); refs != "" {
+		t.Errorf("dry run wrote archive refs:\n%s", refs)
+	}
+
+	res, err = Import(ctx, repoPath, strings.NewReader(input), false)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if res.Imported != 2 || len(res.Errors) != 2 || res.Errors[1].Line != 3 {
+		t.Fatalf("import result: %+v", res)
+	}
+	for root, child := range map[string]string{root1: c1, root2: c2} {
+		meta, err := NewNotesReader(repoPath).ReadMetadata(ctx, root)
+		if err != nil || meta == nil {
+			t.Fatalf("ReadMetadata(%s): %v, %v", root, meta, err)
+		}
+		rootFull, _ := FullHash(ctx, repoPath, root)
+		childFull, _ := FullHash(ctx, repoPath, child)
+		if exists, _ := PreservationRefsExist(ctx, repoPath, rootFull, []string{childFull}); !exists {
+			t.Errorf("archive ref of %s under %s is missing", child, root)
+		}
+	}
+	meta, _ := NewNotesReader(repoPath).ReadMetadata(ctx, root2)
+	if meta.Author == nil || meta.Author.Email != "a@example.com" || len(meta.Refs) != 1 || meta.Refs[0].ID != "7" {
+		t.Errorf("v2 fields of %s: %+v", root2, meta)
+	}
+
+	res, err = Import(ctx, repoPath, strings.NewReader(input), false)
+	if err != nil || res.Imported != 0 || len(res.Skipped) != 2 {
+		t.Errorf("second import: %+v, %v", res, err)
+	}
+}
@@ -0,0 +1,275 @@
+package git
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/widefix/squash-tree/internal/backend"
+)
+
+// mergeStateFile holds a squash merge started by SquashMergeBranch until it is
+// committed or aborted, in the worktree's git directory.
+const mergeStateFile = "SQUASH_TREE_MERGE_CMD"
+
+// errNoSquashMerge is returned by readMergeState when no squash merge is in progress.
+var errNoSquashMerge = errors.New("no squash merge in progress")
+
+// ErrMergeConflict is returned when a squash merge stops on conflicts; it is finished
+// with ContinueSquashMerge or undone with AbortSquashMerge.
+var ErrMergeConflict = errors.New("squash merge stopped on conflicts")
+
+// MergeOptions configures SquashMergeBranch.
+type MergeOptions struct {
+	// Message of the squash commit; empty means the merged commits' messages.
+	Message string
+	// DeleteBranch deletes the merged branch once its commits are preserved.
+	DeleteBranch bool
+}
+
+type mergeState struct {
+	Branch       string `json:"branch"`
+	HeadRef      string `json:"head_ref"`
+	Head         string `json:"head"`
+	Tip          string `json:"tip"`
+	Message      string `json:"message,omitempty"`
+	DeleteBranch bool   `json:"delete_branch,omitempty"`
+}
+
+// SquashMergeBranch squash-merges branch into the checked-out branch, like
+// `git merge --squash` followed by a commit, and records the commit with base = the
+// merge base and children = base..branch in order (strategy: merge). The commit is
+// made without running commit hooks and the note, preservation refs and HEAD update
+// are written in one transaction. On conflicts the state is saved and
+// ErrMergeConflict returned. A conflicted merge finished with plain `git commit` is
+// recorded by the next ContinueSquashMerge, AbortSquashMerge or SquashMergeBranch.
+func SquashMergeBranch(ctx context.Context, repoPath, branch string, opts MergeOptions) (*SquashResult, error) {
+	gitDir, err := GitDir(ctx, repoPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := readMergeState(ctx, repoPath); err == nil {
+		return nil, fmt.Errorf("a squash merge is in progress; use --continue or --abort")
+	} else if !errors.Is(err, errNoSquashMerge) {
+		return nil, err
+	}
+	headRef := symbolicFullName(ctx, repoPath, "HEAD")
+	if headRef == "" {
+		return nil, fmt.Errorf("cannot resolve HEAD")
+	}
+	head, err := FullHash(ctx, repoPath, "HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("resolve HEAD: %w", err)
+	}
+	tip, err := FullHash(ctx, repoPath, branch+"^{commit}")
+	if err != nil {
+		return nil, fmt.Errorf("resolve %q: %w", branch, err)
+	}
+	if runGit(ctx, repoPath, "merge-base", "--is-ancestor", tip, head) == nil {
+		return nil, fmt.Errorf("%s is already merged into HEAD", branch)
+	}
+
+	state := mergeState{Branch: branch, HeadRef: headRef, Head: head, Tip: tip, Message: opts.Message, DeleteBranch: opts.DeleteBranch}
+	if err := writeMergeState(gitDir, state); err != nil {
+		return nil, err
+	}
+	if out, err := backend.Command(ctx, repoPath, "merge", "--squash", tip).CombinedOutput(); err != nil {
+		// git's output names the conflicting paths.
+		if unmerged, _ := backend.Command(ctx, repoPath, "ls-files", "--unmerged").Output(); len(unmerged) > 0 {
+			return nil, fmt.Errorf("%w:\n%s", ErrMergeConflict, strings.TrimSpace(string(out)))
+		}
+		os.Remove(filepath.Join(gitDir, mergeStateFile))
+		return nil, fmt.Errorf("git merge --squash %s: %w: %s", branch, err, strings.TrimSpace(string(out)))
+	}
+	return finishMerge(ctx, repoPath, gitDir, state)
+}
+
+// ContinueSquashMerge commits a squash merge that stopped on conflicts, once they are
+// resolved and staged.
+func ContinueSquashMerge(ctx context.Context, repoPath string) (*SquashResult, error) {
+	gitDir, state, err := readMergeState(ctx, repoPath)
+	if err != nil {
+		return nil, err
+	}
+	if out, _ := backend.Command(ctx, repoPath, "ls-files", "--unmerged").Output(); len(out) > 0 {
+		return nil, fmt.Errorf("unresolved conflicts remain; fix them and `git add` the files")
+	}
+	return finishMerge(ctx, repoPath, gitDir, state)
+}
+
+// AbortSquashMerge undoes a squash merge that stopped on conflicts, like
+// `git merge --abort`.
+func AbortSquashMerge(ctx context.Context, repoPath string) error {
+	gitDir, _, err := readMergeState(ctx, repoPath)
+	if err != nil {
+		return err
+	}
+	if err := runGit(ctx, repoPath, "reset", "--merge"); err != nil {
+		return fmt.Errorf("git reset --merge: %w", err)
+	}
+	removeMergeFiles(gitDir)
+	return nil
+}
+
+func finishMerge(ctx context.Context, repoPath, gitDir string, state mergeState) (*SquashResult, error) {
+	if head, err := FullHash(ctx, repoPath, "HEAD"); err != nil || head != state.Head {
+		return nil, fmt.Errorf("HEAD moved since the squash merge started; use --abort")
+	}
+	out, err := backend.Command(ctx, repoPath, "write-tree").Output()
+	if err != nil {
+		return nil, fmt.Errorf("git write-tree: %w", err)
+	}
+	tree := strings.TrimSpace(string(out))
+	base, children, err := mergedCommits(ctx, repoPath, state)
+	if err != nil {
+		return nil, err
+	}
+
+	message := state.Message
+	if message == "" {
+		if message, err = combinedMessage(ctx, repoPath, base, state.Tip); err != nil {
+			return nil, err
+		}
+	}
+	cmd := backend.Command(ctx, repoPath, "commit-tree", tree, "-p", state.Head, "-F", "-")
+	cmd.Stdin = strings.NewReader(message)
+	out, err = cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git commit-tree: %w", withStderr(err))
+	}
+	commit := strings.TrimSpace(string(out))
+
+	shorts, err := backend.For(repoPath).ShortHashes(ctx, append([]string{commit, base}, children...))
+	if err != nil {
+		return nil, err
+	}
+	meta, err := BuildMetadata(ctx, repoPath, shorts[0], shorts[1], shorts[2:], StrategyMerge)
+	if err != nil {
+		return nil, err
+	}
+	update := backend.RefUpdate{Ref: state.HeadRef, New: commit, Old: state.Head}
+	if err := writeSquashMetadata(ctx, repoPath, meta, backend.NoteAdd, update); err != nil {
+		return nil, err
+	}
+	removeMergeFiles(gitDir)
+
+	result := &SquashResult{Commit: commit, Ref: state.HeadRef, Metadata: meta}
+	if state.DeleteBranch {
+		if err := deleteMergedBranch(ctx, repoPath, state.Branch, state.Tip, commit, children); err != nil {
+			return result, err
+		}
+		result.DeletedBranch = state.Branch
+	}
+	return result, nil
+}
+
+// mergedCommits returns the merge base of state and the commits it merges, oldest first.
+func mergedCommits(ctx context.Context, repoPath string, state mergeState) (string, []string, error) {
+	out, err := backend.Command(ctx, repoPath, "merge-base", state.Head, state.Tip).Output()
+	if err != nil {
+		return "", nil, fmt.Errorf("git merge-base: %w", err)
+	}
+	base := strings.TrimSpace(string(out))
+	out, err = backend.Command(ctx, repoPath, "rev-list", "--reverse", base+".."+state.Tip).Output()
+	if err != nil {
+		return "", nil, fmt.Errorf("git rev-list %s..%s: %w", base, state.Tip, err)
+	}
+	return base, strings.Fields(string(out)), nil
+}
+
+// settleMergeState handles a saved squash merge that was ended outside squash-tree.
+// git's SQUASH_MSG stays until the merge is committed or reset, so without it no merge
+// is in progress. If HEAD then is a commit on top of the merge's HEAD, the merge was
+// finished with plain `git commit` and that commit is recorded (unless the hooks
+// already did). Either way the saved state is removed. It reports whether the state
+// was stale.
+func settleMergeState(ctx context.Context, repoPath, gitDir string, state mergeState) (bool, error) {
+	if _, err := os.Stat(filepath.Join(gitDir, "SQUASH_MSG")); err == nil {
+		return false, nil
+	}
+	defer removeMergeFiles(gitDir)
+	if parent, err := FullHash(ctx, repoPath, "HEAD^"); err != nil || parent != state.Head {
+		return true, nil
+	}
+	b := backend.For(repoPath)
+	root, err := b.ShortHash(ctx, "HEAD")
+	if err != nil {
+		return true, err
+	}
+	if NewNotesReader(repoPath).HasMetadata(ctx, root) {
+		return true, nil
+	}
+	base, children, err := mergedCommits(ctx, repoPath, state)
+	if err != nil {
+		return true, err
+	}
+	shorts, err := b.ShortHashes(ctx, append([]string{base}, children...))
+	if err != nil {
+		return true, err
+	}
+	if err := WriteMetadata(ctx, repoPath, root, shorts[0], shorts[1:], StrategyMerge); err != nil {
+		return true, fmt.Errorf("record squash merge %s: %w", root, err)
+	}
+	return true, nil
+}
+
+// deleteMergedBranch deletes branch if it still points at tip and every merged commit
+// has a preservation ref under the squash commit.
+func deleteMergedBranch(ctx context.Context, repoPath, branch, tip, commit string, children []string) error {
+	ref := "refs/heads/" + branch
+	if current, err := FullHash(ctx, repoPath, ref); err != nil || current != tip {
+		return fmt.Errorf("%s is not a local branch at the merged commit; not deleted", branch)
+	}
+	ok, err := PreservationRefsExist(ctx, repoPath, commit, children)
+	if err != nil || !ok {
+		return fmt.Errorf("preservation refs of %s not found; branch %s not deleted", commit, branch)
+	}
+	if err := runGit(ctx, repoPath, "branch", "-D", branch); err != nil {
+		return fmt.Errorf("delete branch %s: %w", branch, err)
+	}
+	return nil
+}
+
+// removeMergeFiles removes the state of a finished or aborted squash merge, including
+// git's SQUASH_MSG and what the hooks saved for it, since no `git commit` consumes them.
+func removeMergeFiles(gitDir string) {
+	for _, name := range []string{mergeStateFile, "SQUASH_MSG", squashMergeFile} {
+		os.Remove(filepath.Join(gitDir, name))
+	}
+}
+
+func readMergeState(ctx context.Context, repoPath string) (string, mergeState, error) {
+	gitDir, err := GitDir(ctx, repoPath)
+	if err != nil {
+		return "", mergeState{}, err
+	}
+	data, err := os.ReadFile(filepath.Join(gitDir, mergeStateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", mergeState{}, errNoSquashMerge
+	}
+	if err != nil {
+		return "", mergeState{}, err
+	}
+	var state mergeState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return "", mergeState{}, fmt.Errorf("malformed %s: %w", mergeStateFile, err)
+	}
+	if stale, err := settleMergeState(ctx, repoPath, gitDir, state); err != nil {
+		return "", mergeState{}, err
+	} else if stale {
+		return "", mergeState{}, errNoSquashMerge
+	}
+	return gitDir, state, nil
+}
+
+func writeMergeState(gitDir string, s mergeState) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(gitDir, mergeStateFile), data, 0644)
+}
@@ -0,0 +1,186 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// mergeRepo returns a repo on its main branch with a "feature" branch of two commits
+// that both edit f.txt, plus the short hashes of base, f1 and f2.
+func mergeRepo(t *testing.T) (repoPath, base, f1, f2 string) {
+	t.Helper()
+	requireGit(t)
+	repoPath, cleanup := initTempRepo(t)
+	t.Cleanup(cleanup)
+
+	base = makeCommit(t, repoPath, "base")
+	run(t, repoPath, "checkout", "-q", "-b", "feature")
+	f1 = makeCommitUnique(t, repoPath, "feature one", "1")
+	f2 = makeCommitUnique(t, repoPath, "feature two", "2")
+	run(t, repoPath, "checkout", "-q", "-")
+	return repoPath, base, f1, f2
+}
+
+func TestSquashMergeBranch(t *testing.T) {
+	repoPath, base, f1, f2 := mergeRepo(t)
+	ctx := context.Background()
+	commitFile(t, repoPath, "main-work")
+	head, _ := FullHash(ctx, repoPath, "HEAD")
+
+	res, err := SquashMergeBranch(ctx, repoPath, "feature", MergeOptions{Message: "Add feature", DeleteBranch: true})
+	if err != nil {
+		t.Fatalf("SquashMergeBranch: %v", err)
+	}
+	if parent, _ := FullHash(ctx, repoPath, "HEAD^"); parent != head {
+		t.Errorf("squash parent = %s, want previous HEAD %s", parent, head)
+	}
+	if got := strings.TrimSpace(run(t, repoPath, "status", "--porcelain")); got != "" {
+		t.Errorf("worktree not clean after merge: %q", got)
+	}
+	meta, err := NewNotesReader(repoPath).ReadMetadata(ctx, "HEAD")
+	if err != nil {
+		t.Fatalf("ReadMetadata: %v", err)
+	}
+	if meta.Strategy != StrategyMerge || meta.Base != base || meta.Message != "Add feature" {
+		t.Errorf("metadata = %+v", meta)
+	}
+	if len(meta.Children) != 2 || meta.Children[0].Hash != f1 || meta.Children[1].Hash != f2 {
+		t.Errorf("children = %+v, want %s, %s", meta.Children, f1, f2)
+	}
+	if res.DeletedBranch != "feature" {
+		t.Errorf("DeletedBranch = %q", res.DeletedBranch)
+	}
+	if _, err := FullHash(ctx, repoPath, "refs/heads/feature"); err == nil {
+		t.Error("feature branch not deleted")
+	}
+	gitDir := filepath.Join(repoPath, ".git")
+	for _, name := range []string{mergeStateFile, "SQUASH_MSG"} {
+		if _, err := os.Stat(filepath.Join(gitDir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s left behind", name)
+		}
+	}
+}
+
+// conflictingMerge starts a squash merge of feature that conflicts in f.txt.
+func conflictingMerge(t *testing.T) (repoPath, head string) {
+	t.Helper()
+	repoPath, _, _, _ = mergeRepo(t)
+	ctx := context.Background()
+	makeCommitUnique(t, repoPath, "main edit", "m")
+	head, _ = FullHash(ctx, repoPath, "HEAD")
+	if _, err := SquashMergeBranch(ctx, repoPath, "feature", MergeOptions{}); !errors.Is(err, ErrMergeConflict) {
+		t.Fatalf("SquashMergeBranch = %v, want ErrMergeConflict", err)
+	}
+	return repoPath, head
+}
+
+func TestSquashMergeBranch_ContinueAfterConflict(t *testing.T) {
+	repoPath, head := conflictingMerge(t)
+	ctx := context.Background()
+
+	if _, err := SquashMergeBranch(ctx, repoPath, "feature", MergeOptions{}); err == nil || !strings.Contains(err.Error(), "in progress") {
+		t.Errorf("second SquashMergeBranch: got %v", err)
+	}
+	if _, err := ContinueSquashMerge(ctx, repoPath); err == nil {
+		t.Error("ContinueSquashMerge with unresolved conflicts: expected error")
+	}
+	if err := os.WriteFile(filepath.Join(repoPath, "f.txt"), []byte("resolved\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run(t, repoPath, "add", "f.txt")
+	if _, err := ContinueSquashMerge(ctx, repoPath); err != nil {
+		t.Fatalf("ContinueSquashMerge: %v", err)
+	}
+	if parent, _ := FullHash(ctx, repoPath, "HEAD^"); parent != head {
+		t.Errorf("squash parent = %s, want %s", parent, head)
+	}
+	if !NewNotesReader(repoPath).HasMetadata(ctx, "HEAD") {
+		t.Error("squash merge not recorded")
+	}
+	if got := run(t, repoPath, "show", "HEAD:f.txt"); got != "resolved\n" {
+		t.Errorf("f.txt = %q", got)
+	}
+}
+
+func TestSquashMergeBranch_Abort(t *testing.T) {
+	repoPath, head := conflictingMerge(t)
+	ctx := context.Background()
+
+	if err := AbortSquashMerge(ctx, repoPath); err != nil {
+		t.Fatalf("AbortSquashMerge: %v", err)
+	}
+	if got, _ := FullHash(ctx, repoPath, "HEAD"); got != head {
+		t.Error("HEAD moved by abort")
+	}
+	if got := strings.TrimSpace(run(t, repoPath, "status", "--porcelain")); got != "" {
+		t.Errorf("worktree not restored: %q", got)
+	}
+	if err := AbortSquashMerge(ctx, repoPath); err == nil {
+		t.Error("second AbortSquashMerge: expected error")
+	}
+}
+
+func TestSquashMergeBranch_FinishedWithGitCommit(t *testing.T) {
+	repoPath, head := conflictingMerge(t)
+	ctx := context.Background()
+
+	if err := os.WriteFile(filepath.Join(repoPath, "f.txt"), []byte("resolved\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run(t, repoPath, "add", "f.txt")
+	run(t, repoPath, "commit", "-q", "--no-verify", "-m", "squash feature")
+
+	if _, err := ContinueSquashMerge(ctx, repoPath); err == nil || !strings.Contains(err.Error(), "no squash merge in progress") {
+		t.Errorf("ContinueSquashMerge after git commit: got %v", err)
+	}
+	meta, err := NewNotesReader(repoPath).ReadMetadata(ctx, "HEAD")
+	if err != nil {
+		t.Fatalf("commit made by git commit not recorded: %v", err)
+	}
+	if parent, _ := FullHash(ctx, repoPath, "HEAD^"); parent != head || meta.Strategy != StrategyMerge || len(meta.Children) != 2 {
+		t.Errorf("metadata = %+v", meta)
+	}
+	if _, err := os.Stat(filepath.Join(repoPath, ".git", mergeStateFile)); !os.IsNotExist(err) {
+		t.Error("stale merge state left behind")
+	}
+}
+
+func TestSquashMergeBranch_ResetClearsState(t *testing.T) {
+	repoPath, _ := conflictingMerge(t)
+	ctx := context.Background()
+
+	run(t, repoPath, "reset", "-q", "--hard")
+	if _, err := SquashMergeBranch(ctx, repoPath, "feature", MergeOptions{}); !errors.Is(err, ErrMergeConflict) {
+		t.Errorf("SquashMergeBranch after git reset = %v, want a new merge stopping on conflicts", err)
+	}
+}
+
+func TestSquashMergeBranch_ConflictNamesPaths(t *testing.T) {
+	repoPath, _, _, _ := mergeRepo(t)
+	ctx := context.Background()
+	makeCommitUnique(t, repoPath, "main edit", "m")
+
+	_, err := SquashMergeBranch(ctx, repoPath, "feature", MergeOptions{})
+	if !errors.Is(err, ErrMergeConflict) || !strings.Contains(err.Error(), "f.txt") {
+		t.Errorf("SquashMergeBranch = %v, want a conflict naming f.txt", err)
+	}
+}
+
+func TestSquashMergeBranch_AnnotatedTag(t *testing.T) {
+	repoPath, base, f1, f2 := mergeRepo(t)
+	ctx := context.Background()
+	run(t, repoPath, "tag", "-a", "-m", "feature release", "v1", "feature")
+	commitFile(t, repoPath, "main-work")
+
+	res, err := SquashMergeBranch(ctx, repoPath, "v1", MergeOptions{Message: "Merge v1"})
+	if err != nil {
+		t.Fatalf("SquashMergeBranch(v1): %v", err)
+	}
+	if meta := res.Metadata; meta.Base != base || len(meta.Children) != 2 || meta.Children[0].Hash != f1 || meta.Children[1].Hash != f2 {
+		t.Errorf("metadata = %+v", meta)
+	}
+}
@@ -1,13 +1,16 @@
 package git
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os/exec"
+	"math/rand"
 	"strings"
 	"time"
 
-	"squash-tree/internal/metadata"
+	"github.com/widefix/squash-tree/internal/backend"
+	"github.com/widefix/squash-tree/internal/metadata"
 )
 
 const (
@@ -22,12 +25,12 @@ func NewNotesReader(repoPath string) *NotesReader {
 	return &NotesReader{repoPath: repoPath}
 }
 
-func (nr *NotesReader) ReadMetadata(commitHash string) (*metadata.SquashMetadata, error) {
-	shortHash, err := nr.getShortHash(commitHash)
+func (nr *NotesReader) ReadMetadata(ctx context.Context, commitHash string) (*metadata.SquashMetadata, error) {
+	shortHash, err := nr.getShortHash(ctx, commitHash)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get short hash: %w", err)
 	}
-	noteContent, err := nr.readNote(shortHash)
+	noteContent, err := nr.readNote(ctx, shortHash)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read note for commit %s: %w", shortHash, err)
 	}
@@ -41,81 +44,145 @@ func (nr *NotesReader) ReadMetadata(commitHash string) (*metadata.SquashMetadata
 	return meta, nil
 }
 
-func (nr *NotesReader) HasMetadata(commitHash string) bool {
-	shortHash, err := nr.getShortHash(commitHash)
+func (nr *NotesReader) HasMetadata(ctx context.Context, commitHash string) bool {
+	shortHash, err := nr.getShortHash(ctx, commitHash)
 	if err != nil {
 		return false
 	}
 
-	noteContent, err := nr.readNote(shortHash)
+	noteContent, err := nr.readNote(ctx, shortHash)
 	return err == nil && noteContent != ""
 }
 
-func (nr *NotesReader) readNote(commitHash string) (string, error) {
-	cmd := exec.Command("git", "notes", "--ref", NotesRef, "show", commitHash)
-	if nr.repoPath != "" {
-		cmd.Dir = nr.repoPath
-	}
-	output, err := cmd.Output()
+// ReadRaw returns the note on commitHash as stored, without parsing it, or "" if the
+// commit has none.
+func (nr *NotesReader) ReadRaw(ctx context.Context, commitHash string) (string, error) {
+	return nr.readNote(ctx, commitHash)
+}
+
+// ListAnnotated returns the short hashes of all commits that have a squash-tree note.
+func (nr *NotesReader) ListAnnotated(ctx context.Context) ([]string, error) {
+	b := backend.For(nr.repoPath)
+	objects, err := b.ListNotes(ctx, NotesRef)
 	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
-			return "", nil
-		}
-		return "", fmt.Errorf("git notes show failed: %w", err)
+		return nil, err
+	}
+	if len(objects) == 0 {
+		return nil, nil
 	}
-	return strings.TrimSpace(string(output)), nil
+	return b.ShortHashes(ctx, objects)
 }
 
-func (nr *NotesReader) getShortHash(commitHash string) (string, error) {
-	cmd := exec.Command("git", "rev-parse", "--short", commitHash)
-	if nr.repoPath != "" {
-		cmd.Dir = nr.repoPath
-	}
+func (nr *NotesReader) readNote(ctx context.Context, commitHash string) (string, error) {
+	return backend.For(nr.repoPath).ReadNote(ctx, NotesRef, commitHash)
+}
 
-	output, err := cmd.Output()
+func (nr *NotesReader) getShortHash(ctx context.Context, commitHash string) (string, error) {
+	return backend.For(nr.repoPath).ShortHash(ctx, commitHash)
+}
+
+func (nr *NotesReader) CommitExists(ctx context.Context, commitHash string) bool {
+	return backend.For(nr.repoPath).ObjectExists(ctx, commitHash)
+}
+
+func getCommitMessage(ctx context.Context, repoPath, ref string) (string, error) {
+	return backend.For(repoPath).CommitSubject(ctx, ref)
+}
+
+// ShowCommit returns `git show` output for ref: a diffstat, or the full patch when full is set.
+func ShowCommit(ctx context.Context, repoPath, ref string, full bool) (string, error) {
+	args := []string{"show", "--no-color", "--format=fuller"}
+	if !full {
+		args = append(args, "--stat")
+	}
+	output, err := backend.Command(ctx, repoPath, append(args, ref)...).Output()
 	if err != nil {
-		return "", fmt.Errorf("git rev-parse failed: %w", err)
+		return "", fmt.Errorf("git show %s: %w", ref, err)
 	}
-
-	return strings.TrimSpace(string(output)), nil
+	return string(output), nil
 }
 
-func (nr *NotesReader) CommitExists(commitHash string) bool {
-	cmd := exec.Command("git", "cat-file", "-e", commitHash)
-	if nr.repoPath != "" {
-		cmd.Dir = nr.repoPath
+// DiffStat returns the `git show --stat` summary of ref without the commit header.
+func DiffStat(ctx context.Context, repoPath, ref string) (string, error) {
+	output, err := backend.Command(ctx, repoPath, "show", "--no-color", "--stat", "--format=", ref).Output()
+	if err != nil {
+		return "", fmt.Errorf("git show --stat %s: %w", ref, err)
 	}
+	return strings.Trim(string(output), "\n"), nil
+}
 
-	err := cmd.Run()
-	return err == nil
+// CommitIdentities returns the author and committer of ref, for v2 metadata.
+func CommitIdentities(ctx context.Context, repoPath, ref string) (author, committer *metadata.Identity, err error) {
+	output, err := backend.Command(ctx, repoPath, "log", "-1", "--format=%an%x00%ae%x00%aI%x00%cn%x00%ce%x00%cI", ref).Output()
+	if err != nil {
+		return nil, nil, fmt.Errorf("git log: %w", err)
+	}
+	f := strings.Split(strings.TrimRight(string(output), "\n"), "\x00")
+	if len(f) != 6 {
+		return nil, nil, fmt.Errorf("git log: unexpected output %q", output)
+	}
+	return &metadata.Identity{Name: f[0], Email: f[1], Date: f[2]},
+		&metadata.Identity{Name: f[3], Email: f[4], Date: f[5]}, nil
 }
 
-func getCommitMessage(repoPath, ref string) (string, error) {
-	cmd := exec.Command("git", "log", "-1", "--format=%s", ref)
-	if repoPath != "" {
-		cmd.Dir = repoPath
+// UpgradeToV2 switches meta to squash-tree/v2, fills author and committer from the root
+// commit and then overlays the fields set in f.
+func UpgradeToV2(ctx context.Context, repoPath string, meta *metadata.SquashMetadata, f metadata.V2Fields) error {
+	author, committer, err := CommitIdentities(ctx, repoPath, meta.Root)
+	if err != nil {
+		return fmt.Errorf("read identities of %s: %w", meta.Root, err)
+	}
+	meta.Spec = metadata.SpecVersionV2
+	meta.Author = author
+	meta.Committer = committer
+	if f.Author != nil {
+		meta.Author = f.Author
+	}
+	if f.Committer != nil {
+		meta.Committer = f.Committer
+	}
+	if len(f.Refs) > 0 {
+		meta.Refs = f.Refs
 	}
-	output, err := cmd.Output()
+	if len(f.Labels) > 0 {
+		meta.Labels = f.Labels
+	}
+	if f.Tool != nil {
+		meta.Tool = f.Tool
+	}
+	return nil
+}
+
+func WriteMetadata(ctx context.Context, repoPath, rootShortHash, baseShortHash string, children []string, strategy string) error {
+	meta, err := BuildMetadata(ctx, repoPath, rootShortHash, baseShortHash, children, strategy)
 	if err != nil {
-		return "", fmt.Errorf("git log: %w", err)
+		return err
 	}
-	return strings.TrimSpace(string(output)), nil
+	return WriteSquashMetadata(ctx, repoPath, meta)
 }
 
-func WriteMetadata(repoPath, rootShortHash, baseShortHash string, children []string, strategy string) error {
+// BuildMetadata assembles v1 metadata for root, filling in commit messages from the repository.
+func BuildMetadata(ctx context.Context, repoPath, rootShortHash, baseShortHash string, children []string, strategy string) (*metadata.SquashMetadata, error) {
 	if len(children) == 0 {
-		return fmt.Errorf("at least one child commit required")
+		return nil, fmt.Errorf("at least one child commit required")
 	}
 
-	rootMessage, _ := getCommitMessage(repoPath, rootShortHash)
+	rootMessage, _ := getCommitMessage(ctx, repoPath, rootShortHash)
 
 	childCommits := make([]metadata.ChildCommit, len(children))
 	for i, h := range children {
-		msg, _ := getCommitMessage(repoPath, h)
+		msg, _ := getCommitMessage(ctx, repoPath, h)
 		childCommits[i] = metadata.ChildCommit{Hash: h, Order: i + 1, Message: msg}
 	}
+	parents, err := childParents(ctx, repoPath, children)
+	if err != nil {
+		return nil, err
+	}
+	for i := range childCommits {
+		childCommits[i].Parents = parents[i]
+	}
 
-	meta := &metadata.SquashMetadata{
+	return &metadata.SquashMetadata{
 		Spec:      metadata.SpecVersionV1,
 		Type:      metadata.TypeSquash,
 		Root:      rootShortHash,
@@ -124,33 +191,146 @@ func WriteMetadata(repoPath, rootShortHash, baseShortHash string, children []str
 		Children:  childCommits,
 		CreatedAt: time.Now().UTC().Format("2006-01-02T15:04:05Z07:00"),
 		Strategy:  strategy,
+	}, nil
+}
+
+// childParents returns the short hashes of each child's parents if any child is a
+// merge, so the order of a flattened range can be turned back into its branches; for
+// linear history it returns nil slices.
+func childParents(ctx context.Context, repoPath string, children []string) ([][]string, error) {
+	b := backend.For(repoPath)
+	parents, err := b.CommitParents(ctx, children)
+	if err != nil {
+		return nil, fmt.Errorf("parents of children: %w", err)
+	}
+	merge := false
+	for _, p := range parents {
+		merge = merge || len(p) > 1
+	}
+	if !merge {
+		return make([][]string, len(children)), nil
 	}
+	for i, p := range parents {
+		if len(p) == 0 {
+			continue
+		}
+		shorts, err := b.ShortHashes(ctx, p)
+		if err != nil {
+			return nil, fmt.Errorf("parents of %s: %w", children[i], err)
+		}
+		parents[i] = shorts
+	}
+	return parents, nil
+}
+
+// WriteSquashMetadata validates meta with the same rules as metadata.Parse, attaches it
+// as a note to meta.Root and creates preservation refs for its children, atomically.
+func WriteSquashMetadata(ctx context.Context, repoPath string, meta *metadata.SquashMetadata) error {
+	return writeSquashMetadata(ctx, repoPath, meta, backend.NoteAdd)
+}
+
+// ReplaceSquashMetadata is WriteSquashMetadata for a commit that may already carry a
+// note: the note is overwritten, with the previous version kept in the history of the
+// notes ref, and preservation refs of children no longer listed are deleted.
+func ReplaceSquashMetadata(ctx context.Context, repoPath string, meta *metadata.SquashMetadata) error {
+	return writeSquashMetadata(ctx, repoPath, meta, backend.NoteReplace)
+}
+
+// writeSquashMetadata applies op with meta; extra ref updates join the same transaction.
+func writeSquashMetadata(ctx context.Context, repoPath string, meta *metadata.SquashMetadata, op backend.NoteOp, extra ...backend.RefUpdate) error {
+	meta.RaiseSpecMinor()
 	data, err := json.MarshalIndent(meta, "", "  ")
 	if err != nil {
 		return fmt.Errorf("marshal metadata: %w", err)
 	}
-	cmd := exec.Command("git", "notes", "--ref", NotesRef, "add", "-F", "-", rootShortHash)
-	cmd.Dir = repoPath
-	cmd.Stdin = strings.NewReader(string(data))
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("git notes add: %w: %s", err, string(out))
+	if _, err := metadata.Parse(data); err != nil {
+		return fmt.Errorf("invalid metadata: %w", err)
 	}
 
-	rootFull, err := FullHash(repoPath, rootShortHash)
+	rootFull, err := FullHash(ctx, repoPath, meta.Root)
 	if err != nil {
 		return fmt.Errorf("resolve root full hash: %w", err)
 	}
-	childFulls := make([]string, len(children))
-	for i, c := range children {
-		full, err := FullHash(repoPath, c)
+	childFulls := make([]string, len(meta.Children))
+	for i, c := range meta.Children {
+		full, err := FullHash(ctx, repoPath, c.Hash)
 		if err != nil {
-			return fmt.Errorf("resolve child %s full hash: %w", c, err)
+			return fmt.Errorf("resolve child %s full hash: %w", c.Hash, err)
 		}
 		childFulls[i] = full
 	}
-	if err := CreatePreservationRefs(repoPath, rootFull, childFulls); err != nil {
-		return fmt.Errorf("create preservation refs: %w", err)
+
+	updates := preservationUpdates(rootFull, childFulls)
+	if op == backend.NoteReplace {
+		stale, err := stalePreservationRefs(ctx, repoPath, rootFull, childFulls)
+		if err != nil {
+			return err
+		}
+		updates = append(updates, stale...)
 	}
+	return commitNote(ctx, repoPath, rootFull, op, data, append(updates, extra...))
+}
 
-	return nil
+// EditSquashMetadata replaces the metadata on rootFull with edited, a hand-edited note.
+// Nothing is written unless edited parses and its root still names rootFull.
+func EditSquashMetadata(ctx context.Context, repoPath, rootFull string, edited []byte) error {
+	meta, err := metadata.Parse(edited)
+	if err != nil {
+		return fmt.Errorf("invalid metadata: %w", err)
+	}
+	if full, err := FullHash(ctx, repoPath, meta.Root); err != nil || full != rootFull {
+		return fmt.Errorf("root %q does not name %s", meta.Root, rootFull)
+	}
+	return ReplaceSquashMetadata(ctx, repoPath, meta)
+}
+
+// RemoveSquashMetadata removes the note on root together with its preservation refs.
+func RemoveSquashMetadata(ctx context.Context, repoPath, root string) error {
+	rootFull, err := FullHash(ctx, repoPath, root)
+	if err != nil {
+		return fmt.Errorf("resolve root full hash: %w", err)
+	}
+	stale, err := stalePreservationRefs(ctx, repoPath, rootFull, nil)
+	if err != nil {
+		return err
+	}
+	return commitNote(ctx, repoPath, rootFull, backend.NoteRemove, nil, stale)
+}
+
+// commitNote applies op to the note on rootFull and the given ref updates in one ref
+// transaction, so an interrupted write never leaves a note whose children are
+// unprotected from gc. The notes ref is compare-and-swapped against the commit the
+// note was built on; if another writer moved it first, the note is rebuilt on top of
+// theirs.
+func commitNote(ctx context.Context, repoPath, rootFull string, op backend.NoteOp, data []byte, refs []backend.RefUpdate) error {
+	b := backend.For(repoPath)
+	for attempt := 1; ; attempt++ {
+		commit, parent, err := b.NoteCommit(ctx, NotesRef, rootFull, op, data)
+		if err != nil {
+			return err
+		}
+		updates := append([]backend.RefUpdate{{Ref: NotesRef, New: commit, Old: parent}}, refs...)
+		err = b.UpdateRefs(ctx, updates)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, backend.ErrRefConflict) || attempt == maxWriteAttempts {
+			return fmt.Errorf("write metadata: %w", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retryDelay(attempt)):
+		}
+	}
+}
+
+// maxWriteAttempts bounds how often commitNote rebuilds a note that lost a
+// race for the notes ref.
+const maxWriteAttempts = 20
+
+// retryDelay returns a jittered backoff so concurrent writers do not retry in step.
+func retryDelay(attempt int) time.Duration {
+	ceiling := 5 * time.Millisecond << min(attempt, 5)
+	return ceiling/2 + time.Duration(rand.Int63n(int64(ceiling/2)))
 }
@@ -1,10 +1,16 @@
 package git
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/widefix/squash-tree/internal/backend"
 )
 
 // requireGit skips the test if git is not available.
@@ -30,16 +36,16 @@ func TestWriteMetadata_ReadMetadata_RoundTrip(t *testing.T) {
 	base = hash
 	children = []string{hash}
 
-	err := WriteMetadata(repoPath, root, base, children, strategy)
+	err := WriteMetadata(context.Background(), repoPath, root, base, children, strategy)
 	if err != nil {
 		t.Fatalf("WriteMetadata: %v", err)
 	}
 
 	nr := NewNotesReader(repoPath)
-	if !nr.HasMetadata(root) {
+	if !nr.HasMetadata(context.Background(), root) {
 		t.Fatal("HasMetadata: expected true after WriteMetadata")
 	}
-	meta, err := nr.ReadMetadata(root)
+	meta, err := nr.ReadMetadata(context.Background(), root)
 	if err != nil {
 		t.Fatalf("ReadMetadata: %v", err)
 	}
@@ -57,6 +63,74 @@ func TestWriteMetadata_ReadMetadata_RoundTrip(t *testing.T) {
 	}
 }
 
+func TestWriteSquashMetadata_RejectsInvalid(t *testing.T) {
+	requireGit(t)
+	repoPath, cleanup := initTempRepo(t)
+	defer cleanup()
+
+	hash := makeCommit(t, repoPath, "initial")
+	meta, err := BuildMetadata(context.Background(), repoPath, hash, hash, []string{hash}, "test")
+	if err != nil {
+		t.Fatalf("BuildMetadata: %v", err)
+	}
+	meta.Children[0].Order = 0
+
+	if err := WriteSquashMetadata(context.Background(), repoPath, meta); err == nil {
+		t.Fatal("WriteSquashMetadata: expected validation error")
+	}
+	if NewNotesReader(repoPath).HasMetadata(context.Background(), hash) {
+		t.Error("invalid metadata was written")
+	}
+}
+
+func TestCommitIdentities(t *testing.T) {
+	requireGit(t)
+	repoPath, cleanup := initTempRepo(t)
+	defer cleanup()
+
+	hash := makeCommit(t, repoPath, "initial")
+	author, committer, err := CommitIdentities(context.Background(), repoPath, hash)
+	if err != nil {
+		t.Fatalf("CommitIdentities: %v", err)
+	}
+	if author.Name != "Test" || author.Email != "test@test" || author.Date == "" {
+		t.Errorf("author: %+v", author)
+	}
+	if committer.Name != "Test" || committer.Email != "test@test" {
+		t.Errorf("committer: %+v", committer)
+	}
+}
+
+func TestNotesReader_ListAnnotated(t *testing.T) {
+	requireGit(t)
+	repoPath, cleanup := initTempRepo(t)
+	defer cleanup()
+
+	nr := NewNotesReader(repoPath)
+	first := makeCommit(t, repoPath, "first")
+	if list, err := nr.ListAnnotated(context.Background()); err != nil || len(list) != 0 {
+		t.Fatalf("ListAnnotated(no notes) = %v, %v", list, err)
+	}
+
+	second := makeCommitUnique(t, repoPath, "second", "2")
+	for _, root := range []string{first, second} {
+		if err := WriteMetadata(context.Background(), repoPath, root, first, []string{first}, "test"); err != nil {
+			t.Fatalf("WriteMetadata: %v", err)
+		}
+	}
+	list, err := nr.ListAnnotated(context.Background())
+	if err != nil {
+		t.Fatalf("ListAnnotated: %v", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("ListAnnotated = %v", list)
+	}
+	found := map[string]bool{list[0]: true, list[1]: true}
+	if !found[first] || !found[second] {
+		t.Errorf("ListAnnotated = %v, want %s and %s", list, first, second)
+	}
+}
+
 func TestNotesReader_CommitExists(t *testing.T) {
 	requireGit(t)
 	repoPath, cleanup := initTempRepo(t)
@@ -65,10 +139,10 @@ func TestNotesReader_CommitExists(t *testing.T) {
 	hash := makeCommit(t, repoPath, "only commit")
 	nr := NewNotesReader(repoPath)
 
-	if !nr.CommitExists(hash) {
+	if !nr.CommitExists(context.Background(), hash) {
 		t.Error("CommitExists(existing): got false")
 	}
-	if nr.CommitExists("nonexistent00000000000000000000") {
+	if nr.CommitExists(context.Background(), "nonexistent00000000000000000000") {
 		t.Error("CommitExists(nonexistent): got true")
 	}
 }
@@ -81,7 +155,7 @@ func TestNotesReader_GetShortHash(t *testing.T) {
 	fullHash := makeCommit(t, repoPath, "commit")
 	nr := NewNotesReader(repoPath)
 
-	short, err := nr.getShortHash(fullHash)
+	short, err := nr.getShortHash(context.Background(), fullHash)
 	if err != nil {
 		t.Fatalf("getShortHash: %v", err)
 	}
@@ -94,6 +168,69 @@ func TestNotesReader_GetShortHash(t *testing.T) {
 	}
 }
 
+// TestWriteMetadata_Concurrent races many writers on one notes ref, as parallel hooks
+// in several worktrees do, and checks that no note is lost with either backend.
+func TestWriteMetadata_Concurrent(t *testing.T) {
+	requireGit(t)
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "gitconfig"))
+	defer backend.Reset()
+	const writers = 16
+
+	for _, name := range []string{backend.NameExec, backend.NameNative} {
+		t.Run(name, func(t *testing.T) {
+			repoPath, cleanup := initTempRepo(t)
+			defer cleanup()
+			base := makeCommit(t, repoPath, "base")
+			roots := make([]string, writers)
+			for i := range roots {
+				roots[i] = makeCommitUnique(t, repoPath, fmt.Sprintf("squash %d", i), fmt.Sprint(i))
+			}
+			cmd := exec.Command("git", "config", backend.ConfigKey, name)
+			cmd.Dir = repoPath
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("git config: %v %s", err, out)
+			}
+			backend.Reset()
+
+			ctx := context.Background()
+			errs := make(chan error, writers)
+			var wg sync.WaitGroup
+			for _, root := range roots {
+				wg.Add(1)
+				go func(root string) {
+					defer wg.Done()
+					errs <- WriteMetadata(ctx, repoPath, root, base, []string{base}, "test")
+				}(root)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				if err != nil {
+					t.Errorf("WriteMetadata: %v", err)
+				}
+			}
+
+			annotated, err := NewNotesReader(repoPath).ListAnnotated(ctx)
+			if err != nil {
+				t.Fatalf("ListAnnotated: %v", err)
+			}
+			if len(annotated) != writers {
+				t.Errorf("ListAnnotated: got %d notes, want %d", len(annotated), writers)
+			}
+			for _, root := range roots {
+				if _, err := NewNotesReader(repoPath).ReadMetadata(ctx, root); err != nil {
+					t.Errorf("ReadMetadata(%s): %v", root, err)
+				}
+			}
+			cmd = exec.Command("git", "for-each-ref", "refs/notes/")
+			cmd.Dir = repoPath
+			if out, _ := cmd.Output(); strings.Count(string(out), "\n") != 1 {
+				t.Errorf("unexpected notes refs:\n%s", out)
+			}
+		})
+	}
+}
+
 func initTempRepo(t *testing.T) (string, func()) {
 	t.Helper()
 	dir, err := os.MkdirTemp("", "squash-tree-notes-test-*")
@@ -147,3 +284,119 @@ func makeCommit(t *testing.T, repoPath, msg string) string {
 	}
 	return string(out)[:len(out)-1] // trim newline
 }
+
+func TestReplaceAndRemoveSquashMetadata(t *testing.T) {
+	requireGit(t)
+	repoPath, cleanup := initTempRepo(t)
+	defer cleanup()
+
+	base := makeCommit(t, repoPath, "base")
+	child1 := makeCommitUnique(t, repoPath, "child1", "c1")
+	child2 := makeCommitUnique(t, repoPath, "child2", "c2")
+	root := makeCommitUnique(t, repoPath, "squash", "sq")
+	ctx := context.Background()
+	rootFull, _ := FullHash(ctx, repoPath, root)
+	child1Full, _ := FullHash(ctx, repoPath, child1)
+	child2Full, _ := FullHash(ctx, repoPath, child2)
+
+	if err := WriteMetadata(ctx, repoPath, root, base, []string{child1}, "auto"); err != nil {
+		t.Fatalf("WriteMetadata: %v", err)
+	}
+	meta, err := BuildMetadata(ctx, repoPath, root, base, []string{child2}, "manual")
+	if err != nil {
+		t.Fatalf("BuildMetadata: %v", err)
+	}
+	if err := WriteSquashMetadata(ctx, repoPath, meta); err == nil {
+		t.Error("WriteSquashMetadata over an existing note: expected error")
+	}
+	if err := ReplaceSquashMetadata(ctx, repoPath, meta); err != nil {
+		t.Fatalf("ReplaceSquashMetadata: %v", err)
+	}
+	got, err := NewNotesReader(repoPath).ReadMetadata(ctx, root)
+	if err != nil {
+		t.Fatalf("ReadMetadata: %v", err)
+	}
+	if got.Strategy != "manual" || len(got.Children) != 1 || got.Children[0].Hash != child2 {
+		t.Errorf("after replace: %+v", got)
+	}
+	if exists, _ := PreservationRefsExist(ctx, repoPath, rootFull, []string{child1Full}); exists {
+		t.Error("archive ref of the dropped child was kept")
+	}
+	if exists, _ := PreservationRefsExist(ctx, repoPath, rootFull, []string{child2Full}); !exists {
+		t.Error("archive ref of the new child is missing")
+	}
+	// The replaced version stays in the notes history.
+	cmd := exec.Command("git", "notes", "--ref", NotesRef+"~1", "show", root)
+	cmd.Dir = repoPath
+	if out, err := cmd.Output(); err != nil || !strings.Contains(string(out), child1) {
+		t.Errorf("previous note not in history: %s, %v", out, err)
+	}
+
+	if err := RemoveSquashMetadata(ctx, repoPath, root); err != nil {
+		t.Fatalf("RemoveSquashMetadata: %v", err)
+	}
+	if NewNotesReader(repoPath).HasMetadata(ctx, root) {
+		t.Error("note still present after remove")
+	}
+	if exists, _ := PreservationRefsExist(ctx, repoPath, rootFull, []string{child2Full}); exists {
+		t.Error("archive ref still present after remove")
+	}
+	if err := RemoveSquashMetadata(ctx, repoPath, root); err == nil {
+		t.Error("RemoveSquashMetadata without a note: expected error")
+	}
+}
+
+func TestEditAndRemoveSquashMetadata(t *testing.T) {
+	requireGit(t)
+	repoPath, cleanup := initTempRepo(t)
+	defer cleanup()
+
+	base := makeCommit(t, repoPath, "base")
+	child1 := makeCommitUnique(t, repoPath, "child1", "c1")
+	child2 := makeCommitUnique(t, repoPath, "child2", "c2")
+	root := makeCommitUnique(t, repoPath, "squash", "sq")
+	ctx := context.Background()
+	rootFull, _ := FullHash(ctx, repoPath, root)
+	baseFull, _ := FullHash(ctx, repoPath, base)
+
+	if err := WriteMetadata(ctx, repoPath, root, base, []string{child1, child2}, "auto"); err != nil {
+		t.Fatalf("WriteMetadata: %v", err)
+	}
+	note, _ := NewNotesReader(repoPath).ReadRaw(ctx, rootFull)
+	notesTip := run(t, repoPath, "rev-parse", NotesRef)
+
+	for name, edited := range map[string]string{
+		"not json":   "{",
+		"no spec":    `{"root": "` + root + `"}`,
+		"other root": strings.Replace(note, `"root": "`+root+`"`, `"root": "`+base+`"`, 1),
+		"bad child":  strings.Replace(note, child2, "deadbeef", 1),
+	} {
+		if err := EditSquashMetadata(ctx, repoPath, rootFull, []byte(edited)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	if got, _ := NewNotesReader(repoPath).ReadRaw(ctx, rootFull); got != note {
+		t.Errorf("note changed by rejected edits:\n%s", got)
+	}
+	if tip := run(t, repoPath, "rev-parse", NotesRef); tip != notesTip {
+		t.Errorf("notes ref moved by rejected edits: %s -> %s", notesTip, tip)
+	}
+	if err := EditSquashMetadata(ctx, repoPath, baseFull, []byte(note)); err == nil {
+		t.Error("edit of a note whose root names another commit: expected error")
+	}
+
+	edited := strings.Replace(note, `"strategy": "auto"`, `"strategy": "manual"`, 1)
+	if err := EditSquashMetadata(ctx, repoPath, rootFull, []byte(edited)); err != nil {
+		t.Fatalf("EditSquashMetadata: %v", err)
+	}
+	if meta, err := NewNotesReader(repoPath).ReadMetadata(ctx, root); err != nil || meta.Strategy != "manual" {
+		t.Errorf("after edit: %+v, %v", meta, err)
+	}
+
+	if err := RemoveSquashMetadata(ctx, repoPath, root); err != nil {
+		t.Fatalf("RemoveSquashMetadata: %v", err)
+	}
+	if refs := run(t, repoPath, "for-each-ref", ArchiveRefPrefix+rootFull+"/"); refs != "" {
+		t.Errorf("archive refs left after remove:\n%s", refs)
+	}
+}
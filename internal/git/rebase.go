@@ -0,0 +1,143 @@
+package git
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/widefix/squash-tree/internal/backend"
+	"github.com/widefix/squash-tree/internal/metadata"
+)
+
+// StrategyRebase marks metadata recorded from the squash and fixup steps of a rebase.
+const StrategyRebase = "rebase"
+
+// RewriteGroup is a commit produced by a rebase and the original commits rewritten
+// into it, in todo-list order. More than one Old commit means they were squashed.
+type RewriteGroup struct {
+	New string
+	Old []string
+}
+
+// ParseRewriteMapping reads the input git gives the post-rewrite hook, one
+// "<old> <new> [<extra>]" line per rewritten commit in the order the rebase ran them,
+// and groups the old commits by the commit they became, in order of first appearance.
+func ParseRewriteMapping(r io.Reader) ([]RewriteGroup, error) {
+	var groups []RewriteGroup
+	index := make(map[string]int)
+	seen := make(map[string]bool)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("malformed rewrite line %q", sc.Text())
+		}
+		old, new := fields[0], fields[1]
+		if seen[old] {
+			continue
+		}
+		seen[old] = true
+		i, ok := index[new]
+		if !ok {
+			i = len(groups)
+			index[new] = i
+			groups = append(groups, RewriteGroup{New: new})
+		}
+		groups[i].Old = append(groups[i].Old, old)
+	}
+	return groups, sc.Err()
+}
+
+// RebaseDoneFile is the list of todo commands an interactive rebase has run, relative
+// to the git directory. It is still present when post-rewrite runs.
+const RebaseDoneFile = "rebase-merge/done"
+
+// ParseRebaseDone reads the commands of a rebase todo list (or its done file) and
+// returns the action applied to each commit, keyed by the hash as written there
+// (git stores full hashes). Abbreviated commands are expanded and commands that do not
+// take a commit are skipped.
+func ParseRebaseDone(r io.Reader) (map[string]string, error) {
+	actions := make(map[string]string)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		action, ok := rebaseActions[fields[0]]
+		if !ok {
+			continue
+		}
+		hash := fields[1]
+		// fixup -C / -c keep the fixup's message, so they count as squash.
+		if action == metadata.ActionFixup && strings.HasPrefix(hash, "-") && len(fields) > 2 {
+			action, hash = metadata.ActionSquash, fields[2]
+		}
+		actions[hash] = action
+	}
+	return actions, sc.Err()
+}
+
+var rebaseActions = map[string]string{
+	"p": metadata.ActionPick, "pick": metadata.ActionPick,
+	"r": metadata.ActionReword, "reword": metadata.ActionReword,
+	"e": metadata.ActionEdit, "edit": metadata.ActionEdit,
+	"s": metadata.ActionSquash, "squash": metadata.ActionSquash,
+	"f": metadata.ActionFixup, "fixup": metadata.ActionFixup,
+}
+
+// RecordRebaseSquashes writes metadata for every group that squashed two or more
+// commits: the new commit is the root, its parent the base and the old commits the
+// children, ordered as in the todo list. Each child's action is taken from actions
+// (see ParseRebaseDone), which may be nil. Commits that already carry metadata are left
+// alone. It returns how many notes were written; a failing group does not stop the
+// others.
+func RecordRebaseSquashes(ctx context.Context, repoPath string, groups []RewriteGroup, actions map[string]string) (int, error) {
+	b := backend.For(repoPath)
+	nr := NewNotesReader(repoPath)
+	recorded := 0
+	var errs []error
+	for _, g := range groups {
+		if len(g.Old) < 2 {
+			continue
+		}
+		root, err := b.ShortHash(ctx, g.New)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rewritten commit %s: %w", g.New, err))
+			continue
+		}
+		if nr.HasMetadata(ctx, root) {
+			continue
+		}
+		base, err := b.ShortHash(ctx, g.New+"^")
+		if err != nil {
+			errs = append(errs, fmt.Errorf("parent of %s: %w", root, err))
+			continue
+		}
+		children, err := b.ShortHashes(ctx, g.Old)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("squashed commits of %s: %w", root, err))
+			continue
+		}
+		meta, err := BuildMetadata(ctx, repoPath, root, base, children, StrategyRebase)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("record %s: %w", root, err))
+			continue
+		}
+		for i, old := range g.Old {
+			meta.Children[i].Action = actions[old]
+		}
+		if err := WriteSquashMetadata(ctx, repoPath, meta); err != nil {
+			errs = append(errs, fmt.Errorf("record %s: %w", root, err))
+			continue
+		}
+		recorded++
+	}
+	return recorded, errors.Join(errs...)
+}
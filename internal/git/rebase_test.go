@@ -0,0 +1,138 @@
+package git
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/widefix/squash-tree/internal/metadata"
+)
+
+func TestParseRewriteMapping(t *testing.T) {
+	in := "c4 N\nc2 N extra\n\nc5 N\nc3 M\nc2 N\n"
+	groups, err := ParseRewriteMapping(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ParseRewriteMapping: %v", err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("groups = %+v, want 2", groups)
+	}
+	if groups[0].New != "N" || strings.Join(groups[0].Old, ",") != "c4,c2,c5" {
+		t.Errorf("groups[0] = %+v", groups[0])
+	}
+	if groups[1].New != "M" || strings.Join(groups[1].Old, ",") != "c3" {
+		t.Errorf("groups[1] = %+v", groups[1])
+	}
+	if _, err := ParseRewriteMapping(strings.NewReader("lonely\n")); err == nil {
+		t.Error("expected error for a line without a new commit")
+	}
+}
+
+func TestParseRebaseDone(t *testing.T) {
+	in := "pick a1 one\n# comment\nexec make\ns b2 two\nfixup c3 three\nfixup -C d4 four\nr e5 five\nlabel onto\n"
+	actions, err := ParseRebaseDone(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ParseRebaseDone: %v", err)
+	}
+	want := map[string]string{"a1": "pick", "b2": "squash", "c3": "fixup", "d4": "squash", "e5": "reword"}
+	if len(actions) != len(want) {
+		t.Errorf("actions = %v, want %v", actions, want)
+	}
+	for h, a := range want {
+		if actions[h] != a {
+			t.Errorf("action of %s = %q, want %q", h, actions[h], a)
+		}
+	}
+}
+
+// commitFile commits a new file named after msg, so commits can be reordered without conflicts.
+func commitFile(t *testing.T, repoPath, msg string) string {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(repoPath, msg+".txt"), []byte(msg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run(t, repoPath, "add", msg+".txt")
+	run(t, repoPath, "commit", "-q", "-m", msg)
+	return strings.TrimSpace(run(t, repoPath, "rev-parse", "--short", "HEAD"))
+}
+
+func TestRecordRebaseSquashes_ReorderedTodo(t *testing.T) {
+	requireGit(t)
+	repoPath, cleanup := initTempRepo(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	makeCommit(t, repoPath, "base")
+	base := strings.TrimSpace(run(t, repoPath, "rev-parse", "--short", "HEAD"))
+	c := []string{"", commitFile(t, repoPath, "c1"), commitFile(t, repoPath, "c2"), commitFile(t, repoPath, "c3"), commitFile(t, repoPath, "c4"), commitFile(t, repoPath, "c5")}
+
+	// Capture the mapping git hands post-rewrite for a todo list that moves c4 ahead of
+	// c2 and squashes c2 and c5 into it.
+	dir := t.TempDir()
+	mapping, done := filepath.Join(dir, "mapping"), filepath.Join(dir, "done")
+	hook := filepath.Join(repoPath, ".git", "hooks", "post-rewrite")
+	script := "#!/bin/sh\n[ \"$1\" = rebase ] || exit 0\ncat > " + mapping + "\ncp .git/" + RebaseDoneFile + " " + done + "\n"
+	if err := os.WriteFile(hook, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	todo := filepath.Join(dir, "todo")
+	plan := "pick " + c[1] + "\npick " + c[4] + "\nsquash " + c[2] + "\nfixup " + c[5] + "\npick " + c[3] + "\n"
+	if err := os.WriteFile(todo, []byte(plan), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run(t, repoPath, "-c", "sequence.editor=cp "+todo, "-c", "core.editor=true", "rebase", "-q", "-i", base)
+
+	f, err := os.Open(mapping)
+	if err != nil {
+		t.Fatalf("post-rewrite mapping: %v", err)
+	}
+	defer f.Close()
+	groups, err := ParseRewriteMapping(f)
+	if err != nil {
+		t.Fatalf("ParseRewriteMapping: %v", err)
+	}
+	f, err = os.Open(done)
+	if err != nil {
+		t.Fatalf("rebase done file: %v", err)
+	}
+	defer f.Close()
+	actions, err := ParseRebaseDone(f)
+	if err != nil {
+		t.Fatalf("ParseRebaseDone: %v", err)
+	}
+	n, err := RecordRebaseSquashes(ctx, repoPath, groups, actions)
+	if err != nil || n != 1 {
+		t.Fatalf("RecordRebaseSquashes = %d, %v; want 1", n, err)
+	}
+
+	meta, err := NewNotesReader(repoPath).ReadMetadata(ctx, "HEAD^")
+	if err != nil {
+		t.Fatalf("ReadMetadata: %v", err)
+	}
+	if meta.Strategy != StrategyRebase || meta.Base != c[1] {
+		t.Errorf("strategy %q base %q, want %q %q", meta.Strategy, meta.Base, StrategyRebase, c[1])
+	}
+	want := []string{c[4], c[2], c[5]}
+	wantActions := []string{metadata.ActionPick, metadata.ActionSquash, metadata.ActionFixup}
+	if len(meta.Children) != len(want) {
+		t.Fatalf("children = %+v, want %v", meta.Children, want)
+	}
+	for i, ch := range meta.Children {
+		if ch.Hash != want[i] || ch.Order != i+1 || ch.Action != wantActions[i] {
+			t.Errorf("child %d = %+v, want %s order %d action %s", i, ch, want[i], i+1, wantActions[i])
+		}
+	}
+	if meta.Children[2].Message != "c5" {
+		t.Errorf("discarded fixup message not recorded: %+v", meta.Children[2])
+	}
+	if NewNotesReader(repoPath).HasMetadata(ctx, "HEAD") {
+		t.Error("plain pick of c3 was recorded")
+	}
+
+	// Running again (e.g. a second hook invocation) leaves the note as is.
+	if n, err := RecordRebaseSquashes(ctx, repoPath, groups, actions); err != nil || n != 0 {
+		t.Errorf("second RecordRebaseSquashes = %d, %v; want 0", n, err)
+	}
+}
@@ -0,0 +1,73 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/widefix/squash-tree/internal/backend"
+	"github.com/widefix/squash-tree/internal/metadata"
+)
+
+// CarryRewrittenMetadata keeps squash metadata attached to squash commits that were
+// rewritten on their own, by `git commit --amend` or a rebase pick, reword or edit: for
+// every group with a single old commit that carries metadata, the note is copied to the
+// new commit with root, message and identities taken from it and rewritten_from naming
+// the old root, and the children's preservation refs are created under the new root in
+// the same transaction. The old note and its refs stay, since the old commit may still
+// be reachable from another branch; `remove-metadata` drops them. New commits that
+// already carry metadata are left alone. It returns how many notes were written; a
+// failing group does not stop the others.
+func CarryRewrittenMetadata(ctx context.Context, repoPath string, groups []RewriteGroup) (int, error) {
+	b := backend.For(repoPath)
+	nr := NewNotesReader(repoPath)
+	carried := 0
+	var errs []error
+	for _, g := range groups {
+		if len(g.Old) != 1 || !nr.HasMetadata(ctx, g.Old[0]) || nr.HasMetadata(ctx, g.New) {
+			continue
+		}
+		meta, err := nr.ReadMetadata(ctx, g.Old[0])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("carry metadata of %s: %w", g.Old[0], err))
+			continue
+		}
+		root, err := b.ShortHash(ctx, g.New)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rewritten commit %s: %w", g.New, err))
+			continue
+		}
+		from := meta.Root
+		if err := moveRoot(ctx, repoPath, meta, root); err != nil {
+			errs = append(errs, fmt.Errorf("carry metadata to %s: %w", root, err))
+			continue
+		}
+		meta.RewrittenFrom = from
+		if err := WriteSquashMetadata(ctx, repoPath, meta); err != nil {
+			errs = append(errs, fmt.Errorf("carry metadata to %s: %w", root, err))
+			continue
+		}
+		carried++
+	}
+	return carried, errors.Join(errs...)
+}
+
+// moveRoot points meta at root, a copy of the squash commit meta.Root with the same
+// changes. Base and children are the commits that were squashed and stay as recorded.
+func moveRoot(ctx context.Context, repoPath string, meta *metadata.SquashMetadata, root string) error {
+	meta.Root = root
+	meta.Message, _ = getCommitMessage(ctx, repoPath, root)
+	if major, _, _ := metadata.ParseSpecVersion(meta.Spec); major >= 2 && (meta.Author != nil || meta.Committer != nil) {
+		author, committer, err := CommitIdentities(ctx, repoPath, root)
+		if err != nil {
+			return fmt.Errorf("read identities of %s: %w", root, err)
+		}
+		if meta.Author != nil {
+			meta.Author = author
+		}
+		if meta.Committer != nil {
+			meta.Committer = committer
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package git
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCarryRewrittenMetadata_AmendAndRebase(t *testing.T) {
+	requireGit(t)
+	repoPath, cleanup := initTempRepo(t)
+	defer cleanup()
+	ctx := context.Background()
+	nr := NewNotesReader(repoPath)
+
+	base := makeCommit(t, repoPath, "base")
+	c1 := commitFile(t, repoPath, "one")
+	c2 := commitFile(t, repoPath, "two")
+	res, err := Squash(ctx, repoPath, base, "HEAD", SquashOptions{Message: "squash"})
+	if err != nil {
+		t.Fatalf("Squash: %v", err)
+	}
+	squashed := res.Metadata.Root
+
+	run(t, repoPath, "commit", "-q", "--amend", "-m", "squash, reworded")
+	amended := strings.TrimSpace(run(t, repoPath, "rev-parse", "HEAD"))
+	groups := []RewriteGroup{{New: amended, Old: []string{res.Commit}}}
+	if n, err := CarryRewrittenMetadata(ctx, repoPath, groups); err != nil || n != 1 {
+		t.Fatalf("CarryRewrittenMetadata(amend) = %d, %v", n, err)
+	}
+	meta, err := nr.ReadMetadata(ctx, amended)
+	if err != nil {
+		t.Fatalf("ReadMetadata: %v", err)
+	}
+	if meta.Spec != "squash-tree/v1.1" {
+		t.Errorf("spec = %s, want squash-tree/v1.1 for rewritten_from", meta.Spec)
+	}
+	if meta.RewrittenFrom != squashed || meta.Message != "squash, reworded" || meta.Base != base ||
+		len(meta.Children) != 2 || meta.Children[0].Hash != c1 || meta.Children[1].Hash != c2 {
+		t.Errorf("carried metadata = %+v", meta)
+	}
+	childFulls := []string{}
+	for _, c := range []string{c1, c2} {
+		full, _ := FullHash(ctx, repoPath, c)
+		childFulls = append(childFulls, full)
+	}
+	if ok, err := PreservationRefsExist(ctx, repoPath, amended, childFulls); err != nil || !ok {
+		t.Errorf("preservation refs under the amended root missing: %v", err)
+	}
+	if !nr.HasMetadata(ctx, res.Commit) {
+		t.Error("note on the old root was removed")
+	}
+	if n, err := CarryRewrittenMetadata(ctx, repoPath, groups); err != nil || n != 0 {
+		t.Errorf("second CarryRewrittenMetadata = %d, %v; want 0", n, err)
+	}
+
+	// A rebase onto a new base picks the squash commit unchanged.
+	run(t, repoPath, "branch", "feature")
+	run(t, repoPath, "reset", "-q", "--hard", base)
+	commitFile(t, repoPath, "upstream")
+	run(t, repoPath, "checkout", "-q", "feature")
+	run(t, repoPath, "rebase", "-q", "-")
+	rebased := strings.TrimSpace(run(t, repoPath, "rev-parse", "HEAD"))
+	groups = []RewriteGroup{{New: rebased, Old: []string{amended}}}
+	if n, err := CarryRewrittenMetadata(ctx, repoPath, groups); err != nil || n != 1 {
+		t.Fatalf("CarryRewrittenMetadata(rebase) = %d, %v", n, err)
+	}
+	meta, err = nr.ReadMetadata(ctx, rebased)
+	if err != nil {
+		t.Fatalf("ReadMetadata: %v", err)
+	}
+	if !strings.HasPrefix(amended, meta.RewrittenFrom) || meta.Base != base {
+		t.Errorf("rebased metadata = %+v, want rewritten_from %s", meta, amended)
+	}
+}
+
+func TestCarryRewrittenMetadata_IgnoresSquashGroupsAndPlainCommits(t *testing.T) {
+	requireGit(t)
+	repoPath, cleanup := initTempRepo(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	base := makeCommit(t, repoPath, "base")
+	c1 := commitFile(t, repoPath, "one")
+	c2 := commitFile(t, repoPath, "two")
+	if err := WriteMetadata(ctx, repoPath, c2, base, []string{c1}, StrategyManual); err != nil {
+		t.Fatalf("WriteMetadata: %v", err)
+	}
+	c3 := commitFile(t, repoPath, "three")
+	groups := []RewriteGroup{{New: c3, Old: []string{c2, c1}}, {New: c2, Old: []string{c1}}}
+	if n, err := CarryRewrittenMetadata(ctx, repoPath, groups); err != nil || n != 0 {
+		t.Errorf("CarryRewrittenMetadata = %d, %v; want 0", n, err)
+	}
+	if NewNotesReader(repoPath).HasMetadata(ctx, c3) {
+		t.Error("metadata carried to the result of a squash group")
+	}
+}
@@ -0,0 +1,139 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/widefix/squash-tree/internal/backend"
+	"github.com/widefix/squash-tree/internal/metadata"
+)
+
+// StrategyManual marks metadata for squashes made by hand or with the squash command.
+const StrategyManual = "manual"
+
+// SquashOptions configures Squash.
+type SquashOptions struct {
+	// Message of the squash commit; empty means the messages of the squashed
+	// commits, oldest first, as an interactive rebase would combine them.
+	Message string
+	// Branch, if set, is created at the squash commit and nothing else is moved.
+	Branch string
+}
+
+// SquashResult describes a squash made by Squash.
+type SquashResult struct {
+	Commit   string // full hash of the squash commit
+	Ref      string // ref moved or created to point at it, "" if none
+	Metadata *metadata.SquashMetadata
+	// DeletedBranch is the merged branch deleted by SquashMergeBranch, if any.
+	DeletedBranch string
+}
+
+// Squash replaces the commits base..tip by a single commit on top of base with the
+// tree of tip, like `git reset --soft base && git commit`. The squash commit's note,
+// the preservation refs of the squashed commits and the ref update are written in one
+// transaction, so the branch moves only if the metadata is recorded too.
+//
+// The ref updated is opts.Branch (created, it must not exist), else the branch tip
+// names, else HEAD if tip is HEAD and detached. A tip given as a plain commit moves no
+// ref. Because the tree does not change, the index and working tree are left alone.
+func Squash(ctx context.Context, repoPath, base, tip string, opts SquashOptions) (*SquashResult, error) {
+	baseFull, err := FullHash(ctx, repoPath, base+"^{commit}")
+	if err != nil {
+		return nil, fmt.Errorf("resolve base %q: %w", base, err)
+	}
+	tipFull, err := FullHash(ctx, repoPath, tip+"^{commit}")
+	if err != nil {
+		return nil, fmt.Errorf("resolve tip %q: %w", tip, err)
+	}
+	if err := runGit(ctx, repoPath, "merge-base", "--is-ancestor", baseFull, tipFull); err != nil {
+		return nil, fmt.Errorf("%s is not an ancestor of %s", base, tip)
+	}
+	out, err := backend.Command(ctx, repoPath, "rev-list", "--reverse", baseFull+".."+tipFull).Output()
+	if err != nil {
+		return nil, fmt.Errorf("git rev-list %s..%s: %w", base, tip, err)
+	}
+	children := strings.Fields(string(out))
+	if len(children) == 0 {
+		return nil, fmt.Errorf("nothing to squash in %s..%s", base, tip)
+	}
+
+	var update *backend.RefUpdate
+	if opts.Branch != "" {
+		if err := runGit(ctx, repoPath, "check-ref-format", "--branch", opts.Branch); err != nil {
+			return nil, fmt.Errorf("invalid branch name %q", opts.Branch)
+		}
+		if runGit(ctx, repoPath, "rev-parse", "--verify", "--quiet", "refs/heads/"+opts.Branch) == nil {
+			return nil, fmt.Errorf("branch %s already exists", opts.Branch)
+		}
+		update = &backend.RefUpdate{Ref: "refs/heads/" + opts.Branch, Old: backend.ZeroHash}
+	} else if ref := symbolicFullName(ctx, repoPath, tip); strings.HasPrefix(ref, "refs/heads/") || ref == "HEAD" {
+		update = &backend.RefUpdate{Ref: ref, Old: tipFull}
+	}
+
+	message := opts.Message
+	if message == "" {
+		if message, err = combinedMessage(ctx, repoPath, baseFull, tipFull); err != nil {
+			return nil, err
+		}
+	}
+	cmd := backend.Command(ctx, repoPath, "commit-tree", tipFull+"^{tree}", "-p", baseFull, "-F", "-")
+	cmd.Stdin = strings.NewReader(message)
+	out, err = cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git commit-tree: %w", withStderr(err))
+	}
+	commit := strings.TrimSpace(string(out))
+
+	b := backend.For(repoPath)
+	shorts, err := b.ShortHashes(ctx, append([]string{commit, baseFull}, children...))
+	if err != nil {
+		return nil, err
+	}
+	meta, err := BuildMetadata(ctx, repoPath, shorts[0], shorts[1], shorts[2:], StrategyManual)
+	if err != nil {
+		return nil, err
+	}
+	result := &SquashResult{Commit: commit, Metadata: meta}
+	var extra []backend.RefUpdate
+	if update != nil {
+		update.New = commit
+		result.Ref = update.Ref
+		extra = append(extra, *update)
+	}
+	if err := writeSquashMetadata(ctx, repoPath, meta, backend.NoteAdd, extra...); err != nil {
+		if update != nil {
+			if current, _ := FullHash(ctx, repoPath, update.Ref); current != "" && current != update.Old {
+				return nil, fmt.Errorf("%s changed while squashing; nothing was written", update.Ref)
+			}
+		}
+		return nil, err
+	}
+	return result, nil
+}
+
+// symbolicFullName returns the ref rev names (e.g. refs/heads/main, or HEAD when
+// detached), or "" if it does not name a ref.
+func symbolicFullName(ctx context.Context, repoPath, rev string) string {
+	out, err := backend.Command(ctx, repoPath, "rev-parse", "--symbolic-full-name", rev).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// combinedMessage joins the full messages of base..tip, oldest first.
+func combinedMessage(ctx context.Context, repoPath, base, tip string) (string, error) {
+	out, err := backend.Command(ctx, repoPath, "log", "--reverse", "--format=%B%x00", base+".."+tip).Output()
+	if err != nil {
+		return "", fmt.Errorf("git log %s..%s: %w", base, tip, err)
+	}
+	var parts []string
+	for _, msg := range strings.Split(string(out), "\x00") {
+		if msg = strings.TrimSpace(msg); msg != "" {
+			parts = append(parts, msg)
+		}
+	}
+	return strings.Join(parts, "\n\n") + "\n", nil
+}
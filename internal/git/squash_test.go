@@ -0,0 +1,134 @@
+package git
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/widefix/squash-tree/internal/backend"
+)
+
+func TestSquash_MovesCurrentBranch(t *testing.T) {
+	requireGit(t)
+	repoPath, cleanup := initTempRepo(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	base := makeCommit(t, repoPath, "base")
+	c1 := commitFile(t, repoPath, "one")
+	c2 := commitFile(t, repoPath, "two")
+	tipTree := strings.TrimSpace(run(t, repoPath, "rev-parse", "HEAD^{tree}"))
+	branch := strings.TrimSpace(run(t, repoPath, "symbolic-ref", "HEAD"))
+
+	res, err := Squash(ctx, repoPath, base, "HEAD", SquashOptions{})
+	if err != nil {
+		t.Fatalf("Squash: %v", err)
+	}
+	if res.Ref != branch {
+		t.Errorf("Ref = %q, want %q", res.Ref, branch)
+	}
+	if head, _ := FullHash(ctx, repoPath, "HEAD"); head != res.Commit {
+		t.Errorf("HEAD = %s, want squash %s", head, res.Commit)
+	}
+	if got := strings.TrimSpace(run(t, repoPath, "rev-parse", "HEAD^{tree}")); got != tipTree {
+		t.Errorf("tree = %s, want %s", got, tipTree)
+	}
+	if parent, _ := backend.For(repoPath).ShortHash(ctx, "HEAD^"); parent != base {
+		t.Errorf("parent = %s, want %s", parent, base)
+	}
+	if msg := run(t, repoPath, "log", "-1", "--format=%B"); msg != "one\n\ntwo\n\n" {
+		t.Errorf("message = %q", msg)
+	}
+
+	meta, err := NewNotesReader(repoPath).ReadMetadata(ctx, "HEAD")
+	if err != nil {
+		t.Fatalf("ReadMetadata: %v", err)
+	}
+	if meta.Strategy != StrategyManual || meta.Base != base || len(meta.Children) != 2 || meta.Children[0].Hash != c1 || meta.Children[1].Hash != c2 {
+		t.Errorf("metadata = %+v", meta)
+	}
+	childFulls := []string{}
+	for _, c := range []string{c1, c2} {
+		full, _ := FullHash(ctx, repoPath, c)
+		childFulls = append(childFulls, full)
+	}
+	if ok, err := PreservationRefsExist(ctx, repoPath, res.Commit, childFulls); err != nil || !ok {
+		t.Errorf("preservation refs missing: %v", err)
+	}
+}
+
+func TestSquash_NewBranchLeavesTipAlone(t *testing.T) {
+	requireGit(t)
+	repoPath, cleanup := initTempRepo(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	base := makeCommit(t, repoPath, "base")
+	commitFile(t, repoPath, "one")
+	commitFile(t, repoPath, "two")
+	head, _ := FullHash(ctx, repoPath, "HEAD")
+
+	res, err := Squash(ctx, repoPath, base, "HEAD", SquashOptions{Message: "Add one and two", Branch: "squashed"})
+	if err != nil {
+		t.Fatalf("Squash: %v", err)
+	}
+	if got, _ := FullHash(ctx, repoPath, "HEAD"); got != head {
+		t.Error("HEAD moved although --branch was given")
+	}
+	if got, _ := FullHash(ctx, repoPath, "refs/heads/squashed"); got != res.Commit {
+		t.Errorf("squashed = %s, want %s", got, res.Commit)
+	}
+	if msg := strings.TrimSpace(run(t, repoPath, "log", "-1", "--format=%s", "squashed")); msg != "Add one and two" {
+		t.Errorf("message = %q", msg)
+	}
+	if _, err := Squash(ctx, repoPath, base, "HEAD", SquashOptions{Branch: "squashed"}); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("second Squash onto the same branch: got %v", err)
+	}
+}
+
+func TestSquash_NothingMovesOnFailure(t *testing.T) {
+	requireGit(t)
+	repoPath, cleanup := initTempRepo(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	base := makeCommit(t, repoPath, "base")
+	commitFile(t, repoPath, "one")
+	commitFile(t, repoPath, "two")
+	head, _ := FullHash(ctx, repoPath, "HEAD")
+
+	if _, err := Squash(ctx, repoPath, "HEAD", base, SquashOptions{}); err == nil {
+		t.Error("expected error when base is not an ancestor of tip")
+	}
+	// A ref below the new branch name makes the transaction fail after the note has
+	// been built; neither the note nor the branch may be written.
+	run(t, repoPath, "branch", "topic/x")
+	if _, err := Squash(ctx, repoPath, base, "HEAD", SquashOptions{Branch: "topic"}); err == nil {
+		t.Fatal("expected error for a branch name blocked by topic/x")
+	}
+	if got, _ := FullHash(ctx, repoPath, "HEAD"); got != head {
+		t.Error("HEAD moved")
+	}
+	if notes, err := backend.For(repoPath).ListNotes(ctx, NotesRef); err != nil || len(notes) != 0 {
+		t.Errorf("notes written: %v, %v", notes, err)
+	}
+}
+
+func TestSquash_AnnotatedTagRange(t *testing.T) {
+	requireGit(t)
+	repoPath, cleanup := initTempRepo(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	base := makeCommit(t, repoPath, "base")
+	run(t, repoPath, "tag", "-a", "-m", "release", "v1")
+	commitFile(t, repoPath, "one")
+	commitFile(t, repoPath, "two")
+	res, err := Squash(ctx, repoPath, "v1", "HEAD", SquashOptions{Message: "x"})
+	if err != nil {
+		t.Fatalf("Squash(v1..HEAD): %v", err)
+	}
+	if res.Metadata.Base != base || len(res.Metadata.Children) != 2 {
+		t.Errorf("metadata = %+v", res.Metadata)
+	}
+}
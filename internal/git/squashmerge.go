@@ -0,0 +1,145 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/widefix/squash-tree/internal/backend"
+)
+
+// StrategyMerge marks metadata recorded for `git merge --squash` and the commit after it.
+const StrategyMerge = "merge"
+
+// squashMergeFile holds the squash merge in progress in the worktree's git directory:
+// HEAD and the merged tip at merge time, then "commit" once a commit has started
+// while git's SQUASH_MSG was still present.
+const squashMergeFile = "SQUASH_TREE_MERGE"
+
+type squashMerge struct {
+	head, tip  string
+	committing bool
+}
+
+// GitDir returns the absolute git directory of the worktree at repoPath.
+func GitDir(ctx context.Context, repoPath string) (string, error) {
+	out, err := backend.Command(ctx, repoPath, "rev-parse", "--absolute-git-dir").Output()
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse --absolute-git-dir: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// BeginSquashMerge runs after `git merge --squash`, which stages the result without
+// committing or writing MERGE_HEAD. It saves HEAD and merged, the commit that was
+// merged, for FinishSquashMerge. The post-merge hook passes merged from the revision
+// git names in GIT_REFLOG_ACTION ("merge <rev>"), or FETCH_HEAD for `git pull --squash`.
+func BeginSquashMerge(ctx context.Context, repoPath, merged string) error {
+	gitDir, err := GitDir(ctx, repoPath)
+	if err != nil {
+		return err
+	}
+	tip, err := FullHash(ctx, repoPath, merged+"^{commit}")
+	if err != nil {
+		return fmt.Errorf("resolve merged commit %s: %w", merged, err)
+	}
+	head, err := FullHash(ctx, repoPath, "HEAD")
+	if err != nil {
+		return fmt.Errorf("resolve HEAD: %w", err)
+	}
+	return writeSquashMerge(gitDir, squashMerge{head: head, tip: tip})
+}
+
+// PrepareSquashMergeCommit runs when a commit starts. If SQUASH_MSG is still present
+// the commit is the squash commit and the saved merge is marked for recording;
+// otherwise the merge was aborted or reset and its state is dropped.
+func PrepareSquashMergeCommit(ctx context.Context, repoPath string) error {
+	gitDir, err := GitDir(ctx, repoPath)
+	if err != nil {
+		return err
+	}
+	state, ok, err := readSquashMerge(gitDir)
+	if err != nil || !ok {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(gitDir, "SQUASH_MSG")); err != nil {
+		return os.Remove(filepath.Join(gitDir, squashMergeFile))
+	}
+	state.committing = true
+	return writeSquashMerge(gitDir, state)
+}
+
+// FinishSquashMerge runs after a commit. If the commit completes a saved squash merge
+// (it was prepared as one and its parent is the HEAD at merge time), it records
+// metadata with base = merge-base and children = base..tip, and reports true. The
+// saved state is removed either way once a commit has been made from it.
+func FinishSquashMerge(ctx context.Context, repoPath string) (bool, error) {
+	gitDir, err := GitDir(ctx, repoPath)
+	if err != nil {
+		return false, err
+	}
+	state, ok, err := readSquashMerge(gitDir)
+	if err != nil || !ok || !state.committing {
+		return false, err
+	}
+	os.Remove(filepath.Join(gitDir, squashMergeFile))
+
+	parent, err := FullHash(ctx, repoPath, "HEAD^")
+	if err != nil || parent != state.head {
+		return false, nil
+	}
+	out, err := backend.Command(ctx, repoPath, "merge-base", state.head, state.tip).Output()
+	if err != nil {
+		return false, fmt.Errorf("git merge-base %s %s: %w", state.head, state.tip, err)
+	}
+	base := strings.TrimSpace(string(out))
+	out, err = backend.Command(ctx, repoPath, "rev-list", "--reverse", base+".."+state.tip).Output()
+	if err != nil {
+		return false, fmt.Errorf("git rev-list %s..%s: %w", base, state.tip, err)
+	}
+	b := backend.For(repoPath)
+	shorts, err := b.ShortHashes(ctx, append([]string{base}, strings.Fields(string(out))...))
+	if err != nil {
+		return false, err
+	}
+	if len(shorts) < 2 {
+		return false, nil
+	}
+	root, err := b.ShortHash(ctx, "HEAD")
+	if err != nil {
+		return false, err
+	}
+	if NewNotesReader(repoPath).HasMetadata(ctx, root) {
+		return false, nil
+	}
+	if err := WriteMetadata(ctx, repoPath, root, shorts[0], shorts[1:], StrategyMerge); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func readSquashMerge(gitDir string) (squashMerge, bool, error) {
+	data, err := os.ReadFile(filepath.Join(gitDir, squashMergeFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return squashMerge{}, false, nil
+	}
+	if err != nil {
+		return squashMerge{}, false, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return squashMerge{}, false, fmt.Errorf("malformed %s", squashMergeFile)
+	}
+	return squashMerge{head: fields[0], tip: fields[1], committing: len(fields) > 2 && fields[2] == "commit"}, true, nil
+}
+
+func writeSquashMerge(gitDir string, s squashMerge) error {
+	data := s.head + "\n" + s.tip + "\n"
+	if s.committing {
+		data += "commit\n"
+	}
+	return os.WriteFile(filepath.Join(gitDir, squashMergeFile), []byte(data), 0644)
+}
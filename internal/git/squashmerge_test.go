@@ -0,0 +1,87 @@
+package git
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// squashMergeRepo returns a repo on main with a feature branch of two commits merged
+// with --squash but not yet committed, plus the short hashes of base, f1 and f2.
+func squashMergeRepo(t *testing.T) (repoPath, base, f1, f2 string) {
+	t.Helper()
+	requireGit(t)
+	repoPath, cleanup := initTempRepo(t)
+	t.Cleanup(cleanup)
+
+	base = makeCommit(t, repoPath, "base")
+	run(t, repoPath, "checkout", "-q", "-b", "feature")
+	f1 = makeCommitUnique(t, repoPath, "feature one", "1")
+	f2 = makeCommitUnique(t, repoPath, "feature two", "2")
+	run(t, repoPath, "checkout", "-q", "-")
+	if err := os.WriteFile(filepath.Join(repoPath, "other.txt"), []byte("main"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run(t, repoPath, "add", "other.txt")
+	run(t, repoPath, "commit", "-q", "-m", "main work")
+	run(t, repoPath, "merge", "--squash", "feature")
+	return repoPath, base, f1, f2
+}
+
+func TestSquashMerge_RecordsCommit(t *testing.T) {
+	repoPath, base, f1, f2 := squashMergeRepo(t)
+	ctx := context.Background()
+
+	// The hooks run begin after the merge, prepare when the commit starts and
+	// finish after it.
+	if err := BeginSquashMerge(ctx, repoPath, "feature"); err != nil {
+		t.Fatalf("BeginSquashMerge: %v", err)
+	}
+	if err := PrepareSquashMergeCommit(ctx, repoPath); err != nil {
+		t.Fatalf("PrepareSquashMergeCommit: %v", err)
+	}
+	run(t, repoPath, "commit", "-q", "-m", "squash feature")
+	recorded, err := FinishSquashMerge(ctx, repoPath)
+	if err != nil || !recorded {
+		t.Fatalf("FinishSquashMerge = %v, %v", recorded, err)
+	}
+
+	meta, err := NewNotesReader(repoPath).ReadMetadata(ctx, "HEAD")
+	if err != nil {
+		t.Fatalf("ReadMetadata: %v", err)
+	}
+	if meta.Strategy != StrategyMerge || meta.Base != base {
+		t.Errorf("strategy %q base %q, want %q %q", meta.Strategy, meta.Base, StrategyMerge, base)
+	}
+	if len(meta.Children) != 2 || meta.Children[0].Hash != f1 || meta.Children[1].Hash != f2 {
+		t.Errorf("children = %+v, want %s, %s", meta.Children, f1, f2)
+	}
+	if _, err := os.Stat(filepath.Join(repoPath, ".git", squashMergeFile)); !os.IsNotExist(err) {
+		t.Error("squash merge state left behind")
+	}
+}
+
+func TestSquashMerge_ResetDiscardsState(t *testing.T) {
+	repoPath, _, _, _ := squashMergeRepo(t)
+	ctx := context.Background()
+
+	if err := BeginSquashMerge(ctx, repoPath, "feature"); err != nil {
+		t.Fatalf("BeginSquashMerge: %v", err)
+	}
+	run(t, repoPath, "reset", "-q", "--hard")
+	makeCommitUnique(t, repoPath, "unrelated", "u")
+	if err := PrepareSquashMergeCommit(ctx, repoPath); err != nil {
+		t.Fatalf("PrepareSquashMergeCommit: %v", err)
+	}
+	recorded, err := FinishSquashMerge(ctx, repoPath)
+	if err != nil || recorded {
+		t.Errorf("FinishSquashMerge after reset = %v, %v; want false", recorded, err)
+	}
+	if NewNotesReader(repoPath).HasMetadata(ctx, "HEAD") {
+		t.Error("unrelated commit was recorded as a squash merge")
+	}
+	if _, err := os.Stat(filepath.Join(repoPath, ".git", squashMergeFile)); !os.IsNotExist(err) {
+		t.Error("squash merge state kept after reset")
+	}
+}
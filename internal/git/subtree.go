@@ -0,0 +1,171 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/widefix/squash-tree/internal/backend"
+	"github.com/widefix/squash-tree/internal/metadata"
+)
+
+// StrategySubtree marks metadata recorded for the squash commits of
+// `git subtree add/pull --squash`.
+const StrategySubtree = "subtree"
+
+// SubtreeSquash is a synthetic commit made by `git subtree --squash`: the content of
+// upstream commit Split under Dir. PrevSplit is the upstream commit of the previous
+// squash of Dir (its first parent), or "" for `git subtree add`.
+type SubtreeSquash struct {
+	Commit    string
+	Dir       string
+	Split     string
+	PrevSplit string
+}
+
+// ParseSubtreeSquash reads the git-subtree-dir and git-subtree-split trailers of rev.
+// It returns nil and no error if rev is not a subtree squash commit.
+func ParseSubtreeSquash(ctx context.Context, repoPath, rev string) (*SubtreeSquash, error) {
+	commit, parent, dir, split, err := subtreeTrailers(ctx, repoPath, rev)
+	if err != nil || split == "" {
+		return nil, err
+	}
+	s := &SubtreeSquash{Commit: commit, Dir: dir, Split: split}
+	if parent == "" {
+		return s, nil
+	}
+	_, _, prevDir, prevSplit, err := subtreeTrailers(ctx, repoPath, parent)
+	if err != nil {
+		return nil, err
+	}
+	if prevSplit == "" || prevDir != dir {
+		return nil, fmt.Errorf("parent of subtree squash %s is not a squash of %s", commit, dir)
+	}
+	s.PrevSplit = prevSplit
+	return s, nil
+}
+
+// subtreeTrailers returns the full hash of rev, its first parent ("" for a root commit)
+// and, if it is a squash commit (subject "Squashed '<dir>/' ..."), its subtree
+// directory and split commit.
+func subtreeTrailers(ctx context.Context, repoPath, rev string) (commit, parent, dir, split string, err error) {
+	out, err := backend.Command(ctx, repoPath, "log", "-1", "--format=%H%n%P%n%B", rev).Output()
+	if err != nil {
+		return "", "", "", "", fmt.Errorf("git log %s: %w", rev, err)
+	}
+	lines := strings.Split(string(out), "\n")
+	if len(lines) < 2 {
+		return "", "", "", "", fmt.Errorf("git log %s: unexpected output", rev)
+	}
+	commit = lines[0]
+	if parents := strings.Fields(lines[1]); len(parents) > 0 {
+		parent = parents[0]
+	}
+	if len(lines) < 3 || !strings.HasPrefix(lines[2], "Squashed '") {
+		return commit, parent, "", "", nil
+	}
+	for _, line := range lines[3:] {
+		if v, ok := strings.CutPrefix(line, "git-subtree-dir: "); ok {
+			dir = strings.TrimSpace(v)
+		} else if v, ok := strings.CutPrefix(line, "git-subtree-split: "); ok {
+			split = strings.TrimSpace(v)
+		}
+	}
+	if dir == "" {
+		split = ""
+	}
+	return commit, parent, dir, split, nil
+}
+
+// RecordSubtree records the subtree squash commit rev with the upstream commits it
+// collapses as children: PrevSplit..Split in order, with base PrevSplit. A squash made
+// by `git subtree add` contains the whole upstream history, so it is recorded with
+// base metadata.NoBase and Split as its only child. The upstream commits must be in
+// the repository (they are after `git subtree add/pull`); preservation refs then keep
+// them.
+// It returns the short hash of the recorded commit.
+func RecordSubtree(ctx context.Context, repoPath, rev string) (string, error) {
+	s, err := ParseSubtreeSquash(ctx, repoPath, rev)
+	if err != nil {
+		return "", err
+	}
+	if s == nil {
+		return "", fmt.Errorf("%s is not a git subtree --squash commit", rev)
+	}
+	return recordSubtreeSquash(ctx, repoPath, s)
+}
+
+func recordSubtreeSquash(ctx context.Context, repoPath string, s *SubtreeSquash) (string, error) {
+	b := backend.For(repoPath)
+	for _, c := range []string{s.Split, s.PrevSplit} {
+		if c != "" && !b.ObjectExists(ctx, c) {
+			return "", fmt.Errorf("upstream commit %s of %s is not in this repository; fetch it first", c, s.Dir)
+		}
+	}
+	if s.PrevSplit == "" {
+		shorts, err := b.ShortHashes(ctx, []string{s.Commit, s.Split})
+		if err != nil {
+			return "", err
+		}
+		if err := WriteMetadata(ctx, repoPath, shorts[0], metadata.NoBase, shorts[1:], StrategySubtree); err != nil {
+			return "", err
+		}
+		return shorts[0], nil
+	}
+	span := s.PrevSplit + ".." + s.Split
+	out, err := backend.Command(ctx, repoPath, "rev-list", "--reverse", span).Output()
+	if err != nil {
+		return "", fmt.Errorf("git rev-list %s: %w", span, err)
+	}
+	children := strings.Fields(string(out))
+	if len(children) == 0 {
+		return "", fmt.Errorf("no upstream commits between %s and %s", s.PrevSplit, s.Split)
+	}
+	shorts, err := b.ShortHashes(ctx, append([]string{s.Commit, s.PrevSplit}, children...))
+	if err != nil {
+		return "", err
+	}
+	if err := WriteMetadata(ctx, repoPath, shorts[0], shorts[1], shorts[2:], StrategySubtree); err != nil {
+		return "", err
+	}
+	return shorts[0], nil
+}
+
+// RecordSubtreeMerge records the subtree squash commits merged by rev (as made by
+// `git subtree pull --squash`) and any earlier squashes of the same directory not yet
+// recorded, such as the one from `git subtree add`, which runs no hook. Squashes
+// that already carry metadata are skipped. It returns the short hashes recorded.
+func RecordSubtreeMerge(ctx context.Context, repoPath, rev string) ([]string, error) {
+	out, err := backend.Command(ctx, repoPath, "rev-list", "--no-walk", "--parents", rev).Output()
+	if err != nil {
+		return nil, fmt.Errorf("git rev-list %s: %w", rev, err)
+	}
+	nr := NewNotesReader(repoPath)
+	var recorded []string
+	for _, parent := range strings.Fields(string(out))[1:] {
+		var chain []*SubtreeSquash
+		for next := parent; next != ""; {
+			s, err := ParseSubtreeSquash(ctx, repoPath, next)
+			if err != nil {
+				return recorded, err
+			}
+			if s == nil || nr.HasMetadata(ctx, s.Commit) {
+				break
+			}
+			chain = append(chain, s)
+			next = ""
+			if s.PrevSplit != "" {
+				next = s.Commit + "^"
+			}
+		}
+		// Oldest first, so an interruption leaves a recorded prefix of the chain.
+		for i := len(chain) - 1; i >= 0; i-- {
+			short, err := recordSubtreeSquash(ctx, repoPath, chain[i])
+			if err != nil {
+				return recorded, err
+			}
+			recorded = append(recorded, short)
+		}
+	}
+	return recorded, nil
+}
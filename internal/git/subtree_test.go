@@ -0,0 +1,94 @@
+package git
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/widefix/squash-tree/internal/metadata"
+)
+
+// subtreeRepo builds the commits `git subtree add` and `git subtree pull` (both with
+// --squash) would make for an upstream history u1..u4 on branch "up": squash1 at u2,
+// squash2 at u4 on top of it, and the merge of squash2 into main.
+func subtreeRepo(t *testing.T) (repoPath string, up []string, squash1, squash2, merge string) {
+	t.Helper()
+	requireGit(t)
+	repoPath, cleanup := initTempRepo(t)
+	t.Cleanup(cleanup)
+
+	makeCommit(t, repoPath, "main")
+	mainBranch := strings.TrimSpace(run(t, repoPath, "symbolic-ref", "--short", "HEAD"))
+	run(t, repoPath, "checkout", "-q", "--orphan", "up")
+	run(t, repoPath, "rm", "-rq", "--cached", ".")
+	for _, name := range []string{"u1", "u2", "u3", "u4"} {
+		up = append(up, commitFile(t, repoPath, name))
+	}
+	run(t, repoPath, "checkout", "-q", "-f", mainBranch)
+
+	tree := strings.TrimSpace(run(t, repoPath, "rev-parse", "HEAD^{tree}"))
+	commitTree := func(msg string, parents ...string) string {
+		args := []string{"commit-tree", tree, "-m", msg}
+		for _, p := range parents {
+			args = append(args, "-p", p)
+		}
+		return strings.TrimSpace(run(t, repoPath, args...))
+	}
+	split := func(short string) string {
+		return strings.TrimSpace(run(t, repoPath, "rev-parse", short))
+	}
+	squash1 = commitTree("Squashed 'vendor/up/' content from commit " + up[1] + "\n\ngit-subtree-dir: vendor/up\ngit-subtree-split: " + split(up[1]))
+	merge1 := commitTree("Merge commit '"+squash1+"' as 'vendor/up'", "HEAD", squash1)
+	squash2 = commitTree("Squashed 'vendor/up/' changes from "+up[1]+".."+up[3]+"\n\ngit-subtree-dir: vendor/up\ngit-subtree-split: "+split(up[3]), squash1)
+	merge = commitTree("Merge commit '"+squash2+"'", merge1, squash2)
+	return repoPath, up, squash1, squash2, merge
+}
+
+func TestRecordSubtree(t *testing.T) {
+	repoPath, up, squash1, squash2, _ := subtreeRepo(t)
+	ctx := context.Background()
+	nr := NewNotesReader(repoPath)
+
+	if _, err := RecordSubtree(ctx, repoPath, squash1); err != nil {
+		t.Fatalf("RecordSubtree(add): %v", err)
+	}
+	meta, err := nr.ReadMetadata(ctx, squash1)
+	if err != nil {
+		t.Fatalf("ReadMetadata: %v", err)
+	}
+	if meta.Strategy != StrategySubtree || meta.Base != metadata.NoBase || len(meta.Children) != 1 || meta.Children[0].Hash != up[1] {
+		t.Errorf("add squash metadata = %+v", meta)
+	}
+
+	if _, err := RecordSubtree(ctx, repoPath, squash2); err != nil {
+		t.Fatalf("RecordSubtree(pull): %v", err)
+	}
+	meta, err = nr.ReadMetadata(ctx, squash2)
+	if err != nil {
+		t.Fatalf("ReadMetadata: %v", err)
+	}
+	if meta.Base != up[1] || len(meta.Children) != 2 || meta.Children[0].Hash != up[2] || meta.Children[1].Hash != up[3] {
+		t.Errorf("pull squash metadata = %+v", meta)
+	}
+
+	if _, err := RecordSubtree(ctx, repoPath, "HEAD"); err == nil || !strings.Contains(err.Error(), "not a git subtree") {
+		t.Errorf("RecordSubtree(HEAD): got %v", err)
+	}
+}
+
+func TestRecordSubtreeMerge_RecordsEarlierSquashes(t *testing.T) {
+	repoPath, _, squash1, squash2, merge := subtreeRepo(t)
+	ctx := context.Background()
+
+	recorded, err := RecordSubtreeMerge(ctx, repoPath, merge)
+	if err != nil {
+		t.Fatalf("RecordSubtreeMerge: %v", err)
+	}
+	want := []string{squash1, squash2}
+	if len(recorded) != 2 || !strings.HasPrefix(want[0], recorded[0]) || !strings.HasPrefix(want[1], recorded[1]) {
+		t.Errorf("recorded = %v, want %v oldest first", recorded, want)
+	}
+	if recorded, err := RecordSubtreeMerge(ctx, repoPath, merge); err != nil || len(recorded) != 0 {
+		t.Errorf("second RecordSubtreeMerge = %v, %v; want nothing", recorded, err)
+	}
+}
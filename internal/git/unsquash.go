@@ -0,0 +1,220 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/widefix/squash-tree/internal/backend"
+	"github.com/widefix/squash-tree/internal/metadata"
+)
+
+const UnsquashBranchPrefix = "unsquash/"
+
+// Unsquash recreates the children of the squash commit root on a new branch that
+// starts at the recorded base, by cherry-picking the preserved child commits in order
+// (nested squashes are picked as single commits). If the note records the children's
+// parents, their branches and merges are recreated instead (see replayTopology). The
+// work happens in a temporary worktree, so the caller's checkout and existing
+// branches are never touched.
+// It returns the name of the created branch. The temporary worktree is removed even
+// when ctx is cancelled.
+func Unsquash(ctx context.Context, repoPath, root, branch string) (string, error) {
+	meta, err := NewNotesReader(repoPath).ReadMetadata(ctx, root)
+	if err != nil {
+		return "", err
+	}
+	if meta.Base == metadata.NoBase {
+		return "", fmt.Errorf("%s has no base commit to recreate its children on", meta.Root)
+	}
+	if branch == "" {
+		branch = UnsquashBranchPrefix + meta.Root
+	}
+	if err := runGit(ctx, repoPath, "check-ref-format", "--branch", branch); err != nil {
+		return "", fmt.Errorf("invalid branch name %q", branch)
+	}
+	if runGit(ctx, repoPath, "rev-parse", "--verify", "--quiet", "refs/heads/"+branch) == nil {
+		return "", fmt.Errorf("branch %s already exists", branch)
+	}
+
+	children := append(meta.Children[:0:0], meta.Children...)
+	sort.Slice(children, func(i, j int) bool { return children[i].Order < children[j].Order })
+
+	dir, err := os.MkdirTemp("", "squash-tree-unsquash-*")
+	if err != nil {
+		return "", fmt.Errorf("create worktree dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := runGit(ctx, repoPath, "worktree", "add", "--detach", dir, meta.Base); err != nil {
+		return "", fmt.Errorf("git worktree add: %w", err)
+	}
+	cleanup := context.WithoutCancel(ctx)
+	defer runGit(cleanup, repoPath, "worktree", "remove", "--force", dir)
+
+	tip := "HEAD"
+	if hasTopology(children) {
+		if tip, err = replayTopology(ctx, dir, children); err != nil {
+			runGit(cleanup, dir, "cherry-pick", "--abort")
+			return "", err
+		}
+	} else {
+		for _, c := range children {
+			if err := cherryPickChild(ctx, dir, c); err != nil {
+				runGit(cleanup, dir, "cherry-pick", "--abort")
+				return "", err
+			}
+		}
+	}
+	if err := runGit(ctx, dir, "branch", branch, tip); err != nil {
+		return "", fmt.Errorf("git branch %s: %w", branch, err)
+	}
+	return branch, nil
+}
+
+func cherryPickChild(ctx context.Context, dir string, c metadata.ChildCommit) error {
+	if err := runGit(ctx, dir, "cherry-pick", "--allow-empty", "--keep-redundant-commits", c.Hash); err != nil {
+		return fmt.Errorf("cherry-pick %s (child %d): %w", c.Hash, c.Order, err)
+	}
+	return nil
+}
+
+func hasTopology(children []metadata.ChildCommit) bool {
+	for _, c := range children {
+		if len(c.Parents) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// replayTopology recreates children, sorted by order, with their recorded parents in
+// the worktree dir: parents that are children themselves are replaced by their
+// recreated commits, the others (the base, merged-in commits) are kept. A child with
+// one parent is cherry-picked onto it; a merge is recreated from its original tree and
+// message, which keeps how its conflicts were resolved. It returns the recreated tip:
+// the last child that is no other child's parent.
+func replayTopology(ctx context.Context, dir string, children []metadata.ChildCommit) (string, error) {
+	fulls := make([]string, len(children))
+	inSet := make(map[string]bool)
+	for i, c := range children {
+		full, err := FullHash(ctx, dir, c.Hash)
+		if err != nil {
+			return "", fmt.Errorf("resolve child %s: %w", c.Hash, err)
+		}
+		fulls[i] = full
+		inSet[full] = true
+	}
+	parents := make([][]string, len(children))
+	isParent := make(map[string]bool)
+	for i, c := range children {
+		if len(c.Parents) == 0 {
+			return "", fmt.Errorf("child %s has no recorded parents", c.Hash)
+		}
+		for _, p := range c.Parents {
+			full, err := FullHash(ctx, dir, p)
+			if err != nil {
+				return "", fmt.Errorf("resolve parent %s of %s: %w", p, c.Hash, err)
+			}
+			parents[i] = append(parents[i], full)
+			isParent[full] = true
+		}
+	}
+
+	replayed := make(map[string]string)
+	for len(replayed) < len(children) {
+		progress := false
+		for i, c := range children {
+			if _, done := replayed[fulls[i]]; done {
+				continue
+			}
+			newParents, ready := mapParents(parents[i], inSet, replayed)
+			if !ready {
+				continue
+			}
+			commit, err := replayChild(ctx, dir, c, newParents)
+			if err != nil {
+				return "", err
+			}
+			replayed[fulls[i]] = commit
+			progress = true
+		}
+		if !progress {
+			return "", fmt.Errorf("recorded parents of the children form a cycle")
+		}
+	}
+	for i := len(children) - 1; i >= 0; i-- {
+		if !isParent[fulls[i]] {
+			return replayed[fulls[i]], nil
+		}
+	}
+	return replayed[fulls[len(fulls)-1]], nil
+}
+
+// mapParents replaces the parents that are children by their recreated commits; it
+// reports false if one of them has not been recreated yet.
+func mapParents(parents []string, inSet map[string]bool, replayed map[string]string) ([]string, bool) {
+	mapped := make([]string, len(parents))
+	for i, p := range parents {
+		mapped[i] = p
+		if inSet[p] {
+			commit, ok := replayed[p]
+			if !ok {
+				return nil, false
+			}
+			mapped[i] = commit
+		}
+	}
+	return mapped, true
+}
+
+func replayChild(ctx context.Context, dir string, c metadata.ChildCommit, parents []string) (string, error) {
+	if len(parents) == 1 {
+		if err := runGit(ctx, dir, "checkout", "-q", "--detach", parents[0]); err != nil {
+			return "", fmt.Errorf("checkout parent of %s: %w", c.Hash, err)
+		}
+		if err := cherryPickChild(ctx, dir, c); err != nil {
+			return "", err
+		}
+		return FullHash(ctx, dir, "HEAD")
+	}
+	message, err := backend.Command(ctx, dir, "log", "-1", "--format=%B", c.Hash).Output()
+	if err != nil {
+		return "", fmt.Errorf("read message of %s: %w", c.Hash, err)
+	}
+	author, _, err := CommitIdentities(ctx, dir, c.Hash)
+	if err != nil {
+		return "", err
+	}
+	args := []string{"commit-tree", c.Hash + "^{tree}"}
+	for _, p := range parents {
+		args = append(args, "-p", p)
+	}
+	cmd := backend.Command(ctx, dir, append(args, "-F", "-")...)
+	cmd.Stdin = strings.NewReader(strings.TrimSuffix(string(message), "\n"))
+	cmd.Env = append(cmd.Env, "GIT_AUTHOR_NAME="+author.Name, "GIT_AUTHOR_EMAIL="+author.Email, "GIT_AUTHOR_DATE="+author.Date)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("recreate merge %s (child %d): %w", c.Hash, c.Order, withStderr(err))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func runGit(ctx context.Context, dir string, args ...string) error {
+	if out, err := backend.Command(ctx, dir, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// withStderr adds what git printed on stderr to err, an error from (*exec.Cmd).Output.
+func withStderr(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return err
+}
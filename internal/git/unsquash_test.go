@@ -0,0 +1,113 @@
+package git
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnsquash_RecreatesChildrenOnNewBranch(t *testing.T) {
+	requireGit(t)
+	repoPath, cleanup := initTempRepo(t)
+	defer cleanup()
+
+	base := makeCommit(t, repoPath, "base")
+	c1 := makeCommitUnique(t, repoPath, "child one", "1")
+	c2 := makeCommitUnique(t, repoPath, "child two", "2")
+	run(t, repoPath, "reset", "--soft", base)
+	run(t, repoPath, "commit", "-m", "squashed")
+	root := strings.TrimSpace(run(t, repoPath, "rev-parse", "--short", "HEAD"))
+
+	if err := WriteMetadata(context.Background(), repoPath, root, base, []string{c1, c2}, "manual"); err != nil {
+		t.Fatalf("WriteMetadata: %v", err)
+	}
+
+	branch, err := Unsquash(context.Background(), repoPath, root, "")
+	if err != nil {
+		t.Fatalf("Unsquash: %v", err)
+	}
+	if branch != UnsquashBranchPrefix+root {
+		t.Errorf("branch = %q", branch)
+	}
+
+	log := run(t, repoPath, "log", "--format=%s", base+".."+branch)
+	if log != "child two\nchild one\n" {
+		t.Errorf("log = %q", log)
+	}
+	if diff := run(t, repoPath, "diff", root, branch); diff != "" {
+		t.Errorf("unsquashed tree differs from squash: %s", diff)
+	}
+	if head := strings.TrimSpace(run(t, repoPath, "rev-parse", "--short", "HEAD")); head != root {
+		t.Errorf("HEAD moved to %s", head)
+	}
+
+	if _, err := Unsquash(context.Background(), repoPath, root, ""); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("second Unsquash: got %v", err)
+	}
+}
+
+func TestUnsquash_RecreatesMergesInsideTheBranch(t *testing.T) {
+	requireGit(t)
+	repoPath, cleanup := initTempRepo(t)
+	defer cleanup()
+	ctx := context.Background()
+	write := func(content string) {
+		if err := os.WriteFile(filepath.Join(repoPath, "shared.txt"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		run(t, repoPath, "add", "shared.txt")
+	}
+
+	makeCommit(t, repoPath, "base")
+	mainBranch := strings.TrimSpace(run(t, repoPath, "symbolic-ref", "--short", "HEAD"))
+	run(t, repoPath, "checkout", "-q", "-b", "feature")
+	write("feature\n")
+	run(t, repoPath, "commit", "-q", "-m", "a")
+	run(t, repoPath, "checkout", "-q", mainBranch)
+	write("main\n")
+	run(t, repoPath, "commit", "-q", "-m", "main change")
+	// Merge main into the feature branch mid-way, resolving the conflict by hand.
+	run(t, repoPath, "checkout", "-q", "feature")
+	exec.Command("git", "-C", repoPath, "merge", "-q", mainBranch).Run()
+	write("resolved\n")
+	run(t, repoPath, "commit", "-q", "--no-edit")
+	commitFile(t, repoPath, "b")
+	run(t, repoPath, "checkout", "-q", mainBranch)
+
+	res, err := SquashMergeBranch(ctx, repoPath, "feature", MergeOptions{Message: "feature"})
+	if err != nil {
+		t.Fatalf("SquashMergeBranch: %v", err)
+	}
+	if res.Metadata.Spec != "squash-tree/v1.1" {
+		t.Errorf("spec = %s, want squash-tree/v1.1 for parents", res.Metadata.Spec)
+	}
+	children := res.Metadata.Children
+	if len(children) != 3 || len(children[1].Parents) != 2 || children[1].Parents[0] != children[0].Hash || children[2].Parents[0] != children[1].Hash {
+		t.Fatalf("children = %+v, want a, the merge and b with their parents", children)
+	}
+
+	branch, err := Unsquash(ctx, repoPath, res.Commit, "")
+	if err != nil {
+		t.Fatalf("Unsquash: %v", err)
+	}
+	if parents := strings.Fields(run(t, repoPath, "rev-parse", branch+"~1^@")); len(parents) != 2 {
+		t.Errorf("parents of the recreated merge = %v, want 2", parents)
+	}
+	if diff := run(t, repoPath, "diff", res.Commit, branch); diff != "" {
+		t.Errorf("unsquashed tree differs from squash: %s", diff)
+	}
+}
+
+func run(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v %s", args, err, out)
+	}
+	return string(out)
+}
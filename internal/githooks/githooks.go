@@ -11,7 +11,7 @@ import (
 //go:embed scripts/*
 var scriptsFS embed.FS
 
-// Scripts returns the embedded hook script contents, keyed by hook name (e.g. "pre-rebase").
+// Scripts returns the embedded hook script contents, keyed by hook name (e.g. "post-rewrite").
 func Scripts() (map[string]string, error) {
 	out := make(map[string]string)
 	err := fs.WalkDir(scriptsFS, "scripts", func(path string, d fs.DirEntry, err error) error {
@@ -18,7 +18,7 @@ func TestWriteToDir_CreatesExpectedFiles(t *testing.T) {
 		t.Fatalf("WriteToDir: %v", err)
 	}
 
-	expected := []string{"pre-rebase", "post-rewrite", "post-merge", "prepare-commit-msg"}
+	expected := []string{"post-rewrite", "post-merge", "prepare-commit-msg", "post-commit", "post-index-change"}
 	for _, name := range expected {
 		p := filepath.Join(dir, name)
 		info, err := os.Stat(p)
@@ -32,15 +32,41 @@ func TestWriteToDir_CreatesExpectedFiles(t *testing.T) {
 	}
 }
 
-func TestScripts_ContentContainsAddMetadata(t *testing.T) {
+func TestScripts_RunRecordingSteps(t *testing.T) {
 	scripts, err := Scripts()
 	if err != nil {
 		t.Fatalf("Scripts: %v", err)
 	}
-	if !strings.Contains(scripts["post-rewrite"], "add-metadata") {
-		t.Error("post-rewrite should contain add-metadata")
+	if !strings.Contains(scripts["post-rewrite"], `record-rewrite "$1"`) {
+		t.Error("post-rewrite should run record-rewrite for amends and rebases")
 	}
-	if !strings.Contains(scripts["post-merge"], "add-metadata") {
-		t.Error("post-merge should contain add-metadata")
+	for hook, step := range map[string]string{"post-merge": "begin", "prepare-commit-msg": "prepare", "post-commit": "commit"} {
+		if !strings.Contains(scripts[hook], "squash-merge "+step) {
+			t.Errorf("%s should run squash-merge %s", hook, step)
+		}
+	}
+	if !strings.Contains(scripts["post-merge"], "record-subtree --merge") {
+		t.Error("post-merge should run record-subtree --merge")
+	}
+	if !strings.Contains(scripts["post-commit"], "record-backport --from=CHERRY_PICK_HEAD") {
+		t.Error("post-commit should run record-backport for cherry-picks")
+	}
+	for hook, step := range map[string]string{"post-index-change": "pick", "prepare-commit-msg": "prepare", "post-commit": "commit"} {
+		if !strings.Contains(scripts[hook], "cherry-pick "+step) {
+			t.Errorf("%s should run cherry-pick %s", hook, step)
+		}
+	}
+}
+
+func TestScripts_UsePerWorktreeGitDir(t *testing.T) {
+	scripts, err := Scripts()
+	if err != nil {
+		t.Fatalf("Scripts: %v", err)
+	}
+	for name, content := range scripts {
+		// In a linked worktree .git is a file; state must go to `git rev-parse --git-dir`.
+		if strings.Contains(content, ".git/") {
+			t.Errorf("%s: refers to .git/ instead of the worktree's git directory", name)
+		}
 	}
 }
@@ -0,0 +1,134 @@
+package gitobj
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const minAbbrev = 7
+
+// allHashes returns the sorted names of all loose and packed objects. Callers hold r.mu.
+func (r *Repo) allHashes() []string {
+	if r.index != nil {
+		return r.index
+	}
+	r.loadPacks()
+	seen := make(map[string]bool)
+	for _, dir := range r.objDirs {
+		subdirs, _ := os.ReadDir(dir)
+		for _, sub := range subdirs {
+			if !sub.IsDir() || len(sub.Name()) != 2 || !isHex(sub.Name()) {
+				continue
+			}
+			files, _ := os.ReadDir(filepath.Join(dir, sub.Name()))
+			for _, f := range files {
+				if h := sub.Name() + f.Name(); isFullHash(h) {
+					seen[h] = true
+				}
+			}
+		}
+	}
+	for _, p := range r.packs {
+		for _, h := range p.hashes() {
+			seen[h] = true
+		}
+	}
+	index := make([]string, 0, len(seen))
+	for h := range seen {
+		index = append(index, h)
+	}
+	sort.Strings(index)
+	r.index = index
+	return index
+}
+
+// ResolvePrefix returns the single object whose name starts with the hex prefix.
+func (r *Repo) ResolvePrefix(prefix string) (string, error) {
+	prefix = strings.ToLower(prefix)
+	if len(prefix) < 4 || !isHex(prefix) {
+		return "", fmt.Errorf("invalid object name %q", prefix)
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		r.mu.Lock()
+		if attempt == 1 {
+			r.index = nil
+		}
+		index := r.allHashes()
+		r.mu.Unlock()
+		i := sort.SearchStrings(index, prefix)
+		if i < len(index) && strings.HasPrefix(index[i], prefix) {
+			if i+1 < len(index) && strings.HasPrefix(index[i+1], prefix) {
+				return "", fmt.Errorf("short object ID %s is ambiguous", prefix)
+			}
+			return index[i], nil
+		}
+	}
+	return "", fmt.Errorf("object %s: %w", prefix, ErrNotFound)
+}
+
+// Abbrev returns the shortest unique abbreviation of hash that is at least as long as
+// git's default (core.abbrev, or a length derived from the number of packed objects).
+func (r *Repo) Abbrev(hash string) string {
+	r.mu.Lock()
+	index := r.allHashes()
+	if i := sort.SearchStrings(index, hash); i == len(index) || index[i] != hash {
+		r.index = nil
+		index = r.allHashes()
+	}
+	n := r.defaultAbbrev()
+	r.mu.Unlock()
+
+	i := sort.SearchStrings(index, hash)
+	for _, j := range []int{i - 1, i + 1} {
+		if j < 0 || j >= len(index) {
+			continue
+		}
+		if common := commonPrefix(hash, index[j]); common+1 > n {
+			n = common + 1
+		}
+	}
+	if n > len(hash) {
+		n = len(hash)
+	}
+	return hash[:n]
+}
+
+// defaultAbbrev mirrors git's choice of abbreviation length. Callers hold r.mu.
+func (r *Repo) defaultAbbrev() int {
+	switch v := strings.ToLower(r.config.Get("core", "abbrev")); v {
+	case "", "auto":
+	case "no":
+		return 40
+	default:
+		if n, err := strconv.Atoi(v); err == nil && n >= 4 {
+			return n
+		}
+	}
+	count := 0
+	for _, p := range r.packs {
+		count += p.count
+	}
+	// git expects a collision among 2^bits objects after 2^(bits/2) of them, and
+	// a hex digit carries 4 bits: len = ceil((msb(count)+1) / 2).
+	bits := 0
+	for c := count; c > 0; c >>= 1 {
+		bits++
+	}
+	n := (bits + 1) / 2
+	if n < minAbbrev {
+		n = minAbbrev
+	}
+	return n
+}
+
+func commonPrefix(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
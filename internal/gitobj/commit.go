@@ -0,0 +1,177 @@
+package gitobj
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Commit is a parsed commit object.
+type Commit struct {
+	Tree      string
+	Parents   []string
+	Author    string // "Name <email> unix-time tz", as stored
+	Committer string
+	Message   string
+}
+
+// Subject returns the first paragraph of the message joined into one line, like %s.
+func (c *Commit) Subject() string {
+	var lines []string
+	for _, line := range strings.Split(c.Message, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			if len(lines) > 0 {
+				break
+			}
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, " ")
+}
+
+// ReadCommit reads and parses the commit with the given full hash.
+func (r *Repo) ReadCommit(hash string) (*Commit, error) {
+	t, data, err := r.ReadObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	if t != TypeCommit {
+		return nil, fmt.Errorf("object %s is a %s, not a commit", hash, t)
+	}
+	return parseCommit(data)
+}
+
+func parseCommit(data []byte) (*Commit, error) {
+	c := &Commit{}
+	header, msg, _ := bytes.Cut(data, []byte("\n\n"))
+	c.Message = string(msg)
+	for _, line := range strings.Split(string(header), "\n") {
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "tree":
+			c.Tree = value
+		case "parent":
+			c.Parents = append(c.Parents, value)
+		case "author":
+			c.Author = value
+		case "committer":
+			c.Committer = value
+		}
+	}
+	if !isFullHash(c.Tree) {
+		return nil, fmt.Errorf("malformed commit: missing tree")
+	}
+	return c, nil
+}
+
+// Encode serializes the commit in git's object format.
+func (c *Commit) Encode() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "tree %s\n", c.Tree)
+	for _, p := range c.Parents {
+		fmt.Fprintf(&b, "parent %s\n", p)
+	}
+	fmt.Fprintf(&b, "author %s\ncommitter %s\n\n%s", c.Author, c.Committer, c.Message)
+	return b.Bytes()
+}
+
+// Peel follows tags from hash until it reaches an object of type want (or any
+// non-tag object when want is 0).
+func (r *Repo) Peel(hash string, want ObjectType) (string, error) {
+	for depth := 0; depth < 20; depth++ {
+		t, data, err := r.ReadObject(hash)
+		if err != nil {
+			return "", err
+		}
+		if t == want || (want == 0 && t != TypeTag) {
+			return hash, nil
+		}
+		switch t {
+		case TypeTag:
+			line, _, _ := bytes.Cut(data, []byte("\n"))
+			target, ok := strings.CutPrefix(string(line), "object ")
+			if !ok || !isFullHash(target) {
+				return "", fmt.Errorf("malformed tag %s", hash)
+			}
+			hash = target
+		case TypeCommit:
+			if want != TypeTree {
+				return "", fmt.Errorf("object %s is a commit, not a %s", hash, want)
+			}
+			c, err := parseCommit(data)
+			if err != nil {
+				return "", err
+			}
+			return c.Tree, nil
+		default:
+			return "", fmt.Errorf("object %s is a %s, not a %s", hash, t, want)
+		}
+	}
+	return "", fmt.Errorf("tag chain too long at %s", hash)
+}
+
+// TreeEntry is one entry of a tree object.
+type TreeEntry struct {
+	Mode string // e.g. "100644", "40000"
+	Name string
+	Hash string
+}
+
+// IsTree reports whether the entry is a subdirectory.
+func (e TreeEntry) IsTree() bool {
+	return e.Mode == "40000"
+}
+
+// ReadTree reads and parses the tree with the given full hash.
+func (r *Repo) ReadTree(hash string) ([]TreeEntry, error) {
+	t, data, err := r.ReadObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	if t != TypeTree {
+		return nil, fmt.Errorf("object %s is a %s, not a tree", hash, t)
+	}
+	var entries []TreeEntry
+	for len(data) > 0 {
+		sp := bytes.IndexByte(data, ' ')
+		nul := bytes.IndexByte(data, 0)
+		if sp < 0 || nul < sp || len(data) < nul+21 {
+			return nil, fmt.Errorf("malformed tree %s", hash)
+		}
+		entries = append(entries, TreeEntry{
+			Mode: string(data[:sp]),
+			Name: string(data[sp+1 : nul]),
+			Hash: hex.EncodeToString(data[nul+1 : nul+21]),
+		})
+		data = data[nul+21:]
+	}
+	return entries, nil
+}
+
+// EncodeTree serializes entries in git's canonical tree order.
+func EncodeTree(entries []TreeEntry) ([]byte, error) {
+	sorted := append([]TreeEntry(nil), entries...)
+	sort.Slice(sorted, func(i, j int) bool { return treeSortKey(sorted[i]) < treeSortKey(sorted[j]) })
+	var b bytes.Buffer
+	for _, e := range sorted {
+		raw, err := hex.DecodeString(e.Hash)
+		if err != nil || len(raw) != 20 {
+			return nil, fmt.Errorf("tree entry %s: invalid object name %q", e.Name, e.Hash)
+		}
+		fmt.Fprintf(&b, "%s %s\x00", e.Mode, e.Name)
+		b.Write(raw)
+	}
+	return b.Bytes(), nil
+}
+
+// treeSortKey orders directories as if their names ended in "/", as git does.
+func treeSortKey(e TreeEntry) string {
+	if e.IsTree() {
+		return e.Name + "/"
+	}
+	return e.Name
+}
@@ -0,0 +1,147 @@
+package gitobj
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Config holds git configuration values keyed by "section.key" or
+// "section.subsection.key" (section and key lower-cased). Later sources override
+// earlier ones. Include directives are not followed.
+type Config struct {
+	values map[string][]string
+}
+
+// LoadConfig reads the global config files followed by the repository config at path.
+// Missing files are skipped.
+func LoadConfig(path string) (*Config, error) {
+	c := &Config{values: make(map[string][]string)}
+	for _, p := range append(globalConfigPaths(), path) {
+		if err := c.parseFile(p); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func globalConfigPaths() []string {
+	if p := os.Getenv("GIT_CONFIG_GLOBAL"); p != "" {
+		return []string{p}
+	}
+	var paths []string
+	xdg := os.Getenv("XDG_CONFIG_HOME")
+	home, _ := os.UserHomeDir()
+	if xdg == "" && home != "" {
+		xdg = filepath.Join(home, ".config")
+	}
+	if xdg != "" {
+		paths = append(paths, filepath.Join(xdg, "git", "config"))
+	}
+	if home != "" {
+		paths = append(paths, filepath.Join(home, ".gitconfig"))
+	}
+	return paths
+}
+
+// Get returns the last value of key in section (with an optional subsection, e.g.
+// Get("remote", "origin", "url")), or "" if it is not set. A key present without a
+// value (boolean true) returns "true".
+func (c *Config) Get(parts ...string) string {
+	vs := c.values[configKey(parts...)]
+	if len(vs) == 0 {
+		return ""
+	}
+	return vs[len(vs)-1]
+}
+
+func configKey(parts ...string) string {
+	if len(parts) == 3 {
+		return strings.ToLower(parts[0]) + "." + parts[1] + "." + strings.ToLower(parts[2])
+	}
+	return strings.ToLower(strings.Join(parts, "."))
+}
+
+func (c *Config) parseFile(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	section := ""
+	sc := bufio.NewScanner(f)
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			end := strings.LastIndex(line, "]")
+			if end < 0 {
+				return fmt.Errorf("%s:%d: malformed section header", path, lineNo)
+			}
+			section = parseSection(line[1:end])
+			line = strings.TrimSpace(line[end+1:])
+			if line == "" || line[0] == '#' || line[0] == ';' {
+				continue
+			}
+		}
+		if section == "" {
+			return fmt.Errorf("%s:%d: key outside of a section", path, lineNo)
+		}
+		name, value, hasValue := strings.Cut(line, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !hasValue {
+			value = "true"
+		} else {
+			value = parseValue(value)
+		}
+		key := section + "." + name
+		c.values[key] = append(c.values[key], value)
+	}
+	return sc.Err()
+}
+
+// parseSection turns `core` or `remote "origin"` into "core" or "remote.origin".
+func parseSection(s string) string {
+	name, sub, ok := strings.Cut(strings.TrimSpace(s), " ")
+	if !ok {
+		// Deprecated [section.subsection] syntax: the subsection is lower-cased.
+		return strings.ToLower(name)
+	}
+	return strings.ToLower(name) + "." + strings.Trim(strings.TrimSpace(sub), `"`)
+}
+
+// parseValue strips comments and quotes and processes escapes.
+func parseValue(s string) string {
+	var b strings.Builder
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch == '"':
+			quoted = !quoted
+		case ch == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(s[i])
+			}
+		case (ch == '#' || ch == ';') && !quoted:
+			return strings.TrimSpace(b.String())
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,299 @@
+package gitobj
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func requireGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available:", err)
+	}
+}
+
+func run(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v %s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// newRepo creates a repository with a main branch of three commits, a side branch
+// merged into it, an annotated tag and a squash-tree note.
+func newRepo(t *testing.T) string {
+	t.Helper()
+	requireGit(t)
+	dir := t.TempDir()
+	run(t, dir, "init", "-b", "main")
+	run(t, dir, "config", "user.email", "test@test")
+	run(t, dir, "config", "user.name", "Test")
+	run(t, dir, "config", "commit.gpgsign", "false")
+	commit := func(msg, content string) {
+		if err := os.WriteFile(filepath.Join(dir, "f.txt"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		run(t, dir, "add", "f.txt")
+		run(t, dir, "commit", "-m", msg)
+	}
+	commit("first", strings.Repeat("line\n", 200))
+	commit("second\n\nbody text", strings.Repeat("line\n", 200)+"more\n")
+	run(t, dir, "checkout", "-b", "side")
+	if err := os.WriteFile(filepath.Join(dir, "g.txt"), []byte("side"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run(t, dir, "add", "g.txt")
+	run(t, dir, "commit", "-m", "side work")
+	run(t, dir, "checkout", "main")
+	commit("third   subject \nwrapped", strings.Repeat("line\n", 200)+"more\nand more\n")
+	run(t, dir, "merge", "--no-ff", "-m", "merge side", "side")
+	run(t, dir, "tag", "-a", "-m", "release", "v1", "HEAD~1")
+	run(t, dir, "notes", "--ref", "refs/notes/squash-tree", "add", "-m", `{"spec":"x"}`, "HEAD")
+	return dir
+}
+
+var revs = []string{"HEAD", "@", "main", "side", "refs/heads/main", "v1", "v1^{}", "v1^{commit}", "HEAD^", "HEAD^2", "HEAD~2", "HEAD^1~1", "HEAD^{tree}", "HEAD^0", "main~1^"}
+
+func checkAgainstGit(t *testing.T, dir string) {
+	t.Helper()
+	r, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer r.Close()
+
+	for _, rev := range revs {
+		want := run(t, dir, "rev-parse", rev)
+		got, err := r.RevParse(rev)
+		if err != nil || got != want {
+			t.Errorf("RevParse(%q) = %q, %v; want %q", rev, got, err, want)
+			continue
+		}
+		if short := run(t, dir, "rev-parse", "--short", rev); r.Abbrev(got) != short {
+			t.Errorf("Abbrev(%s) = %q, want %q", rev, r.Abbrev(got), short)
+		}
+		if abbrev, err := r.RevParse(got[:9]); err != nil || abbrev != got {
+			t.Errorf("RevParse(%q) = %q, %v", got[:9], abbrev, err)
+		}
+	}
+
+	for _, rev := range []string{"HEAD", "HEAD~1", "HEAD~2", "side"} {
+		hash, _ := r.RevParse(rev)
+		c, err := r.ReadCommit(hash)
+		if err != nil {
+			t.Fatalf("ReadCommit(%s): %v", rev, err)
+		}
+		if want := run(t, dir, "log", "-1", "--format=%s", rev); c.Subject() != want {
+			t.Errorf("Subject(%s) = %q, want %q", rev, c.Subject(), want)
+		}
+		raw := run(t, dir, "cat-file", "commit", rev)
+		if got := strings.TrimSpace(string(c.Encode())); got != raw {
+			t.Errorf("Encode(%s) round trip:\n%s\nwant\n%s", rev, got, raw)
+		}
+	}
+
+	head, _ := r.RevParse("HEAD")
+	data, found, err := r.ReadNote("refs/notes/squash-tree", head)
+	if err != nil || !found || strings.TrimSpace(string(data)) != `{"spec":"x"}` {
+		t.Errorf("ReadNote = %q, %v, %v", data, found, err)
+	}
+	if _, found, _ := r.ReadNote("refs/notes/squash-tree", run(t, dir, "rev-parse", "side")); found {
+		t.Error("ReadNote found a note on an unannotated commit")
+	}
+
+	for _, bad := range []string{"nope", "HEAD~10", "HEAD^3", "HEAD@{1}", "main..side"} {
+		if _, err := r.RevParse(bad); err == nil {
+			t.Errorf("RevParse(%q): expected error", bad)
+		}
+	}
+}
+
+func TestLooseObjects(t *testing.T) {
+	checkAgainstGit(t, newRepo(t))
+}
+
+func TestPackedObjectsAndRefs(t *testing.T) {
+	dir := newRepo(t)
+	run(t, dir, "gc", "--aggressive", "--prune=now")
+	if _, err := os.Stat(filepath.Join(dir, ".git", "refs", "heads", "main")); err == nil {
+		t.Fatal("expected gc to pack refs")
+	}
+	checkAgainstGit(t, dir)
+}
+
+func TestAddNoteReadableByGit(t *testing.T) {
+	dir := newRepo(t)
+	r, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	side, _ := r.RevParse("side")
+	if err := r.AddNote("refs/notes/squash-tree", side, []byte("native note\n")); err != nil {
+		t.Fatalf("AddNote: %v", err)
+	}
+	if got := run(t, dir, "notes", "--ref", "refs/notes/squash-tree", "show", "side"); got != "native note" {
+		t.Errorf("git notes show = %q", got)
+	}
+	if got := run(t, dir, "notes", "--ref", "refs/notes/squash-tree", "show", "HEAD"); got != `{"spec":"x"}` {
+		t.Errorf("existing note lost: %q", got)
+	}
+	if err := r.AddNote("refs/notes/squash-tree", side, []byte("again")); err == nil {
+		t.Error("second AddNote: expected ErrNoteExists")
+	}
+	run(t, dir, "fsck", "--strict")
+
+	notes, err := r.ListNotes("refs/notes/squash-tree")
+	if err != nil || len(notes) != 2 {
+		t.Fatalf("ListNotes = %v, %v", notes, err)
+	}
+	if want := run(t, dir, "notes", "--ref", "refs/notes/squash-tree", "list"); fmt.Sprintf("%s %s\n%s %s", notes[0].Blob, notes[0].Object, notes[1].Blob, notes[1].Object) != want {
+		t.Errorf("ListNotes = %v, git notes list = %q", notes, want)
+	}
+}
+
+func TestNotesFanout(t *testing.T) {
+	dir := newRepo(t)
+	r, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Notes for enough (nonexistent) objects that git switches to a fanout tree.
+	for i := 0; i < 300; i++ {
+		obj := HashObject(TypeBlob, []byte(fmt.Sprint(i)))
+		if err := r.AddNote("refs/notes/test", obj, []byte(fmt.Sprintln(i))); err != nil {
+			t.Fatalf("AddNote %d: %v", i, err)
+		}
+	}
+	run(t, dir, "notes", "--ref", "refs/notes/test", "add", "-m", "head note", "HEAD")
+	if tree := run(t, dir, "ls-tree", "refs/notes/test"); !strings.Contains(tree, "tree ") {
+		t.Fatalf("git did not write a fanout tree:\n%.300s", tree)
+	}
+	r.Close()
+
+	r, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	notes, err := r.ListNotes("refs/notes/test")
+	if err != nil || len(notes) != 301 {
+		t.Fatalf("ListNotes: %d notes, %v", len(notes), err)
+	}
+	head, _ := r.RevParse("HEAD")
+	if data, found, err := r.ReadNote("refs/notes/test", head); err != nil || !found || string(data) != "head note\n" {
+		t.Errorf("ReadNote(HEAD) = %q, %v, %v", data, found, err)
+	}
+	side, _ := r.RevParse("side")
+	if err := r.AddNote("refs/notes/test", side, []byte("fanout\n")); err != nil {
+		t.Fatalf("AddNote into fanout: %v", err)
+	}
+	if got := run(t, dir, "notes", "--ref", "refs/notes/test", "show", "side"); got != "fanout" {
+		t.Errorf("git notes show side = %q", got)
+	}
+	if n := len(strings.Split(run(t, dir, "notes", "--ref", "refs/notes/test", "list"), "\n")); n != 302 {
+		t.Errorf("git notes list: %d notes, want 302", n)
+	}
+}
+
+func TestUpdateRefCompareAndSwap(t *testing.T) {
+	dir := newRepo(t)
+	r, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	head, _ := r.RevParse("HEAD")
+	side, _ := r.RevParse("side")
+
+	if err := r.UpdateRef("refs/test/x", head, ZeroHash); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := r.UpdateRef("refs/test/x", side, ZeroHash); err == nil {
+		t.Error("create over existing ref: expected ErrRefChanged")
+	}
+	if err := r.UpdateRef("refs/test/x", side, head); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if got := run(t, dir, "rev-parse", "refs/test/x"); got != side {
+		t.Errorf("refs/test/x = %s, want %s", got, side)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	data := "[core]\n\tabbrev = 10 ; comment\n[squashTree]\n\tbackend = native\n[remote \"Origin\"]\n\turl = \"a#b\"\n[flag]\n\ton\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "none"))
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		parts []string
+		want  string
+	}{
+		{[]string{"core", "abbrev"}, "10"},
+		{[]string{"squashtree", "Backend"}, "native"},
+		{[]string{"remote", "Origin", "url"}, "a#b"},
+		{[]string{"flag", "on"}, "true"},
+		{[]string{"core", "missing"}, ""},
+	} {
+		if got := c.Get(tc.parts...); got != tc.want {
+			t.Errorf("Get(%v) = %q, want %q", tc.parts, got, tc.want)
+		}
+	}
+}
+
+func TestUpdateRefWritesReflogs(t *testing.T) {
+	dir := newRepo(t)
+	r, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	head, _ := r.RevParse("HEAD")
+	side, _ := r.RevParse("side")
+
+	if err := r.UpdateRefs([]RefUpdate{{Name: "refs/heads/main", New: side, Old: head, Message: "test: move main"}}); err != nil {
+		t.Fatalf("update main: %v", err)
+	}
+	for _, ref := range []string{"main", "HEAD"} {
+		if got := run(t, dir, "rev-parse", ref+"@{1}"); got != head {
+			t.Errorf("%s@{1} = %s, want %s", ref, got, head)
+		}
+		if got := run(t, dir, "log", "-g", "-1", "--format=%gs", ref); got != "test: move main" {
+			t.Errorf("%s reflog message = %q", ref, got)
+		}
+	}
+	if err := r.UpdateRef("refs/test/x", head, ZeroHash); err != nil {
+		t.Fatalf("create refs/test/x: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git", "logs", "refs", "test", "x")); !os.IsNotExist(err) {
+		t.Errorf("refs/test/x got a reflog: %v", err)
+	}
+	if err := r.UpdateRef("refs/heads/side", ZeroHash, side); err != nil {
+		t.Fatalf("delete side: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git", "logs", "refs", "heads", "side")); !os.IsNotExist(err) {
+		t.Errorf("reflog of a deleted branch kept: %v", err)
+	}
+}
+
+func TestOpenRejectsReftable(t *testing.T) {
+	dir := newRepo(t)
+	run(t, dir, "config", "extensions.refStorage", "reftable")
+	if _, err := Open(dir); err == nil || !strings.Contains(err.Error(), "ref storage") {
+		t.Errorf("Open = %v, want unsupported ref storage", err)
+	}
+}
@@ -0,0 +1,62 @@
+package gitobj
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ident returns the author or committer identity ("AUTHOR" or "COMMITTER") in git's
+// "Name <email> unix-time tz" form, from GIT_<ROLE>_NAME/EMAIL/DATE, then
+// <role>.name/email, then user.name/email. Unlike git it does not guess an identity
+// from the host name.
+func (r *Repo) Ident(role string) (string, error) {
+	lower := strings.ToLower(role)
+	name := firstNonEmpty(os.Getenv("GIT_"+role+"_NAME"), r.config.Get(lower, "name"), r.config.Get("user", "name"))
+	email := firstNonEmpty(os.Getenv("GIT_"+role+"_EMAIL"), r.config.Get(lower, "email"), r.config.Get("user", "email"))
+	if name == "" || email == "" {
+		return "", fmt.Errorf("%s identity unknown: set user.name and user.email", lower)
+	}
+	date, err := identDate(os.Getenv("GIT_" + role + "_DATE"))
+	if err != nil {
+		return "", fmt.Errorf("GIT_%s_DATE: %w", role, err)
+	}
+	return fmt.Sprintf("%s <%s> %s", name, email, date), nil
+}
+
+// identDate formats s ("<unix> <tz>", "@<unix> <tz>" or RFC 3339; empty means now)
+// as "<unix> <tz>".
+func identDate(s string) (string, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "@")
+	if s == "" {
+		return formatIdentTime(time.Now()), nil
+	}
+	if unix, tz, ok := strings.Cut(s, " "); ok {
+		if _, err := strconv.ParseInt(unix, 10, 64); err == nil && len(tz) == 5 && (tz[0] == '+' || tz[0] == '-') {
+			return s, nil
+		}
+	}
+	if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return s + " +0000", nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return "", fmt.Errorf("unsupported date format %q", s)
+	}
+	return formatIdentTime(t), nil
+}
+
+func formatIdentTime(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10) + " " + t.Format("-0700")
+}
+
+func firstNonEmpty(vs ...string) string {
+	for _, v := range vs {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,292 @@
+package gitobj
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrNoteExists is returned by AddNote when the object already has a note.
+var ErrNoteExists = errors.New("note already exists")
+
+// ErrNoteNotFound is returned by RemoveNoteCommit when the object has no note.
+var ErrNoteNotFound = errors.New("no note found")
+
+// emptyTree is the hash of the tree with no entries.
+var emptyTree = HashObject(TypeTree, nil)
+
+// Note is one entry of a notes ref.
+type Note struct {
+	Object string // the annotated object
+	Blob   string // the note content
+}
+
+// notesTree returns the notes commit and its tree for notesRef; both are "" if the
+// ref does not exist yet.
+func (r *Repo) notesTree(notesRef string) (commit, tree string, err error) {
+	commit, err = r.ReadRef(notesRef)
+	if errors.Is(err, ErrNotFound) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	c, err := r.ReadCommit(commit)
+	if err != nil {
+		return "", "", fmt.Errorf("notes ref %s: %w", notesRef, err)
+	}
+	return commit, c.Tree, nil
+}
+
+// ReadNote returns the note attached to object under notesRef. found is false if the
+// object has no note.
+func (r *Repo) ReadNote(notesRef, object string) (data []byte, found bool, err error) {
+	_, tree, err := r.notesTree(notesRef)
+	if err != nil || tree == "" {
+		return nil, false, err
+	}
+	blob, err := r.findNote(tree, object)
+	if err != nil || blob == "" {
+		return nil, false, err
+	}
+	_, data, err = r.ReadObject(blob)
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// findNote looks rest up in a notes tree, descending into fanout directories
+// ("ab/cdef...") as needed. It returns "" if there is no note.
+func (r *Repo) findNote(tree, rest string) (string, error) {
+	entries, err := r.ReadTree(tree)
+	if err != nil {
+		return "", err
+	}
+	for _, e := range entries {
+		switch {
+		case !e.IsTree() && e.Name == rest:
+			return e.Hash, nil
+		case e.IsTree() && len(e.Name) == 2 && len(rest) > 2 && e.Name == rest[:2]:
+			if blob, err := r.findNote(e.Hash, rest[2:]); err != nil || blob != "" {
+				return blob, err
+			}
+		}
+	}
+	return "", nil
+}
+
+// ListNotes returns all notes under notesRef, ordered by annotated object.
+func (r *Repo) ListNotes(notesRef string) ([]Note, error) {
+	_, tree, err := r.notesTree(notesRef)
+	if err != nil || tree == "" {
+		return nil, err
+	}
+	var notes []Note
+	var walk func(tree, prefix string) error
+	walk = func(tree, prefix string) error {
+		entries, err := r.ReadTree(tree)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			name := prefix + e.Name
+			switch {
+			case !isHex(e.Name):
+				// Not a note (git allows other files in a notes tree).
+			case e.IsTree() && len(e.Name) == 2 && len(name) < 40:
+				if err := walk(e.Hash, name); err != nil {
+					return err
+				}
+			case !e.IsTree() && len(name) == 40:
+				notes = append(notes, Note{Object: name, Blob: e.Hash})
+			}
+		}
+		return nil
+	}
+	if err := walk(tree, ""); err != nil {
+		return nil, err
+	}
+	sort.Slice(notes, func(i, j int) bool { return notes[i].Object < notes[j].Object })
+	return notes, nil
+}
+
+// AddNote attaches data as a new note to object under notesRef, committing to the
+// notes ref the way `git notes add` does. It fails with ErrNoteExists if the object
+// already has a note, and with ErrRefChanged if the notes ref moved while the new
+// notes commit was being built.
+func (r *Repo) AddNote(notesRef, object string, data []byte) error {
+	commit, parent, err := r.NoteCommit(notesRef, object, data, false)
+	if err != nil {
+		return err
+	}
+	return r.UpdateRef(notesRef, commit, parent)
+}
+
+// NoteCommit writes the notes commit that sets data as the note of object, without
+// updating notesRef. parent is the notes commit it builds on, or ZeroHash if notesRef
+// does not exist yet, so it can be passed as the old value of the ref update. Unless
+// replace is set it fails with ErrNoteExists if the object already has a note; a
+// replaced note stays in the notes ref's history.
+func (r *Repo) NoteCommit(notesRef, object string, data []byte, replace bool) (commit, parent string, err error) {
+	if !isFullHash(object) {
+		return "", "", fmt.Errorf("add note: invalid object name %q", object)
+	}
+	parent, tree, err := r.notesTree(notesRef)
+	if err != nil {
+		return "", "", err
+	}
+	if tree != "" {
+		existing, err := r.findNote(tree, object)
+		if err != nil {
+			return "", "", err
+		}
+		if existing != "" && !replace {
+			return "", "", fmt.Errorf("object %s: %w", object, ErrNoteExists)
+		}
+		if existing != "" {
+			if tree, err = r.removeNote(tree, object); err != nil {
+				return "", "", err
+			}
+		}
+	}
+
+	blob, err := r.WriteObject(TypeBlob, data)
+	if err != nil {
+		return "", "", err
+	}
+	newTree, err := r.insertNote(tree, object, blob)
+	if err != nil {
+		return "", "", err
+	}
+	return r.notesCommit(parent, newTree, "Notes added by 'git notes add'\n")
+}
+
+// RemoveNoteCommit writes the notes commit that removes the note of object, like
+// NoteCommit. It fails with ErrNoteNotFound if the object has no note.
+func (r *Repo) RemoveNoteCommit(notesRef, object string) (commit, parent string, err error) {
+	if !isFullHash(object) {
+		return "", "", fmt.Errorf("remove note: invalid object name %q", object)
+	}
+	parent, tree, err := r.notesTree(notesRef)
+	if err != nil {
+		return "", "", err
+	}
+	existing := ""
+	if tree != "" {
+		if existing, err = r.findNote(tree, object); err != nil {
+			return "", "", err
+		}
+	}
+	if existing == "" {
+		return "", "", fmt.Errorf("object %s: %w", object, ErrNoteNotFound)
+	}
+	newTree, err := r.removeNote(tree, object)
+	if err != nil {
+		return "", "", err
+	}
+	return r.notesCommit(parent, newTree, "Notes removed by 'git notes remove'\n")
+}
+
+// notesCommit writes a notes commit for tree on top of parent ("" for the first one)
+// and returns it with parent, or ZeroHash in its place.
+func (r *Repo) notesCommit(parent, tree, message string) (string, string, error) {
+	author, err := r.Ident("AUTHOR")
+	if err != nil {
+		return "", "", err
+	}
+	committer, err := r.Ident("COMMITTER")
+	if err != nil {
+		return "", "", err
+	}
+	c := &Commit{Tree: tree, Author: author, Committer: committer, Message: message}
+	if parent != "" {
+		c.Parents = []string{parent}
+	} else {
+		parent = ZeroHash
+	}
+	commit, err := r.WriteObject(TypeCommit, c.Encode())
+	if err != nil {
+		return "", "", err
+	}
+	return commit, parent, nil
+}
+
+// removeNote returns tree without the note for rest, dropping fanout directories it
+// leaves empty.
+func (r *Repo) removeNote(tree, rest string) (string, error) {
+	entries, err := r.ReadTree(tree)
+	if err != nil {
+		return "", err
+	}
+	for i, e := range entries {
+		switch {
+		case !e.IsTree() && e.Name == rest:
+			return r.writeTree(append(entries[:i:i], entries[i+1:]...))
+		case e.IsTree() && len(e.Name) == 2 && len(rest) > 2 && e.Name == rest[:2]:
+			found, err := r.findNote(e.Hash, rest[2:])
+			if err != nil {
+				return "", err
+			}
+			if found == "" {
+				continue
+			}
+			sub, err := r.removeNote(e.Hash, rest[2:])
+			if err != nil {
+				return "", err
+			}
+			if sub == emptyTree {
+				return r.writeTree(append(entries[:i:i], entries[i+1:]...))
+			}
+			entries[i].Hash = sub
+			return r.writeTree(entries)
+		}
+	}
+	return tree, nil
+}
+
+// insertNote returns a tree equal to tree (which may be "") plus a note for rest.
+// It follows the fanout already present: an existing "ab" directory is descended
+// into, and a new one is created when the level already uses fanout directories.
+func (r *Repo) insertNote(tree, rest, blob string) (string, error) {
+	var entries []TreeEntry
+	if tree != "" {
+		var err error
+		if entries, err = r.ReadTree(tree); err != nil {
+			return "", err
+		}
+	}
+	fanout := false
+	for i, e := range entries {
+		if !e.IsTree() || len(e.Name) != 2 || !isHex(e.Name) {
+			continue
+		}
+		fanout = true
+		if e.Name == rest[:2] {
+			sub, err := r.insertNote(e.Hash, rest[2:], blob)
+			if err != nil {
+				return "", err
+			}
+			entries[i].Hash = sub
+			return r.writeTree(entries)
+		}
+	}
+	if fanout && len(rest) > 2 {
+		sub, err := r.insertNote("", rest[2:], blob)
+		if err != nil {
+			return "", err
+		}
+		entries = append(entries, TreeEntry{Mode: "40000", Name: rest[:2], Hash: sub})
+	} else {
+		entries = append(entries, TreeEntry{Mode: "100644", Name: rest, Hash: blob})
+	}
+	return r.writeTree(entries)
+}
+
+func (r *Repo) writeTree(entries []TreeEntry) (string, error) {
+	data, err := EncodeTree(entries)
+	if err != nil {
+		return "", err
+	}
+	return r.WriteObject(TypeTree, data)
+}
@@ -0,0 +1,184 @@
+package gitobj
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ObjectType is the type of a git object, numbered as in pack files.
+type ObjectType int
+
+const (
+	TypeCommit ObjectType = 1
+	TypeTree   ObjectType = 2
+	TypeBlob   ObjectType = 3
+	TypeTag    ObjectType = 4
+)
+
+func (t ObjectType) String() string {
+	switch t {
+	case TypeCommit:
+		return "commit"
+	case TypeTree:
+		return "tree"
+	case TypeBlob:
+		return "blob"
+	case TypeTag:
+		return "tag"
+	}
+	return "unknown"
+}
+
+func parseType(s string) (ObjectType, error) {
+	for _, t := range []ObjectType{TypeCommit, TypeTree, TypeBlob, TypeTag} {
+		if t.String() == s {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown object type %q", s)
+}
+
+// ErrNotFound is returned for objects and refs that do not exist.
+var ErrNotFound = errors.New("not found")
+
+// ZeroHash is the all-zero object name git uses for "no object".
+const ZeroHash = "0000000000000000000000000000000000000000"
+
+// ReadObject returns the type and content of the object with the given full hash.
+func (r *Repo) ReadObject(hash string) (ObjectType, []byte, error) {
+	if !isFullHash(hash) {
+		return 0, nil, fmt.Errorf("invalid object name %q", hash)
+	}
+	for _, dir := range r.objDirs {
+		t, data, err := readLoose(dir, hash)
+		if err == nil {
+			return t, data, nil
+		}
+		if !os.IsNotExist(err) {
+			return 0, nil, fmt.Errorf("read object %s: %w", hash, err)
+		}
+	}
+	t, data, err := r.readPacked(hash)
+	if err != nil {
+		return 0, nil, fmt.Errorf("object %s: %w", hash, err)
+	}
+	return t, data, nil
+}
+
+// HasObject reports whether the object with the given full hash exists.
+func (r *Repo) HasObject(hash string) bool {
+	if !isFullHash(hash) {
+		return false
+	}
+	for _, dir := range r.objDirs {
+		if _, err := os.Stat(loosePath(dir, hash)); err == nil {
+			return true
+		}
+	}
+	p, _ := r.findPacked(hash)
+	return p != nil
+}
+
+func loosePath(dir, hash string) string {
+	return filepath.Join(dir, hash[:2], hash[2:])
+}
+
+func readLoose(dir, hash string) (ObjectType, []byte, error) {
+	f, err := os.Open(loosePath(dir, hash))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+	zr, err := zlib.NewReader(f)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer zr.Close()
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return 0, nil, err
+	}
+	nul := bytes.IndexByte(raw, 0)
+	if nul < 0 {
+		return 0, nil, fmt.Errorf("malformed loose object header")
+	}
+	typ, size, ok := bytes.Cut(raw[:nul], []byte(" "))
+	if !ok {
+		return 0, nil, fmt.Errorf("malformed loose object header")
+	}
+	t, err := parseType(string(typ))
+	if err != nil {
+		return 0, nil, err
+	}
+	n, err := strconv.Atoi(string(size))
+	if err != nil || n != len(raw)-nul-1 {
+		return 0, nil, fmt.Errorf("loose object size mismatch")
+	}
+	return t, raw[nul+1:], nil
+}
+
+// HashObject returns the object name of content stored as type t.
+func HashObject(t ObjectType, data []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s %d\x00", t, len(data))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// WriteObject stores data as a loose object and returns its hash. Writing an object
+// that already exists is a no-op.
+func (r *Repo) WriteObject(t ObjectType, data []byte) (string, error) {
+	hash := HashObject(t, data)
+	if r.HasObject(hash) {
+		return hash, nil
+	}
+	path := loosePath(r.objDirs[0], hash)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("write object: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "tmp_obj_")
+	if err != nil {
+		return "", fmt.Errorf("write object: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	zw := zlib.NewWriter(tmp)
+	fmt.Fprintf(zw, "%s %d\x00", t, len(data))
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("write object: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("write object: %w", err)
+	}
+	os.Chmod(tmp.Name(), 0444)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("write object: %w", err)
+	}
+	r.mu.Lock()
+	r.index = nil
+	r.mu.Unlock()
+	return hash, nil
+}
+
+func isFullHash(s string) bool {
+	return len(s) == 40 && isHex(s)
+}
+
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,355 @@
+package gitobj
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	packOfsDelta = 6
+	packRefDelta = 7
+
+	maxDeltaDepth  = 10000
+	packCacheBytes = 32 << 20
+)
+
+// pack is an opened pack file with its index loaded into memory.
+type pack struct {
+	name    string
+	file    *os.File
+	count   int
+	fanout  [256]uint32
+	names   []byte // count*20 sorted object names
+	offsets []int64
+
+	mu         sync.Mutex
+	cache      map[int64]cachedObject // decoded delta bases by offset
+	cacheBytes int
+}
+
+type cachedObject struct {
+	t    ObjectType
+	data []byte
+}
+
+func openPack(idxPath string) (*pack, error) {
+	idx, err := os.ReadFile(idxPath)
+	if err != nil {
+		return nil, err
+	}
+	p := &pack{name: idxPath, cache: make(map[int64]cachedObject)}
+	if err := p.parseIndex(idx); err != nil {
+		return nil, fmt.Errorf("%s: %w", idxPath, err)
+	}
+	p.file, err = os.Open(strings.TrimSuffix(idxPath, ".idx") + ".pack")
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *pack) close() {
+	p.file.Close()
+}
+
+func (p *pack) parseIndex(idx []byte) error {
+	be := binary.BigEndian
+	fanoutAt, v2 := 0, false
+	if len(idx) >= 8 && bytes.Equal(idx[:4], []byte("\xfftOc")) {
+		if v := be.Uint32(idx[4:8]); v != 2 {
+			return fmt.Errorf("unsupported pack index version %d", v)
+		}
+		fanoutAt, v2 = 8, true
+	}
+	if len(idx) < fanoutAt+256*4 {
+		return fmt.Errorf("truncated pack index")
+	}
+	for i := range p.fanout {
+		p.fanout[i] = be.Uint32(idx[fanoutAt+i*4:])
+	}
+	p.count = int(p.fanout[255])
+	p.offsets = make([]int64, p.count)
+	at := fanoutAt + 256*4
+
+	if !v2 {
+		if len(idx) < at+p.count*24 {
+			return fmt.Errorf("truncated pack index")
+		}
+		p.names = make([]byte, 0, p.count*20)
+		for i := 0; i < p.count; i++ {
+			e := idx[at+i*24:]
+			p.offsets[i] = int64(be.Uint32(e))
+			p.names = append(p.names, e[4:24]...)
+		}
+		return nil
+	}
+
+	namesAt := at
+	offsAt := namesAt + p.count*20 + p.count*4
+	largeAt := offsAt + p.count*4
+	if len(idx) < largeAt {
+		return fmt.Errorf("truncated pack index")
+	}
+	p.names = idx[namesAt : namesAt+p.count*20]
+	for i := 0; i < p.count; i++ {
+		off := be.Uint32(idx[offsAt+i*4:])
+		if off&0x80000000 == 0 {
+			p.offsets[i] = int64(off)
+			continue
+		}
+		l := largeAt + int(off&0x7fffffff)*8
+		if len(idx) < l+8 {
+			return fmt.Errorf("truncated pack index")
+		}
+		p.offsets[i] = int64(be.Uint64(idx[l:]))
+	}
+	return nil
+}
+
+// find returns the pack offset of the object with the given binary name.
+func (p *pack) find(name []byte) (int64, bool) {
+	lo := 0
+	if name[0] > 0 {
+		lo = int(p.fanout[name[0]-1])
+	}
+	hi := int(p.fanout[name[0]])
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(p.names[(lo+i)*20:(lo+i+1)*20], name) >= 0
+	})
+	if i < hi && bytes.Equal(p.names[i*20:(i+1)*20], name) {
+		return p.offsets[i], true
+	}
+	return 0, false
+}
+
+func (p *pack) hashes() []string {
+	out := make([]string, p.count)
+	for i := range out {
+		out[i] = hex.EncodeToString(p.names[i*20 : (i+1)*20])
+	}
+	return out
+}
+
+// loadPacks opens the packs of all object directories, keeping already open ones.
+// Callers hold r.mu.
+func (r *Repo) loadPacks() {
+	open := make(map[string]*pack, len(r.packs))
+	for _, p := range r.packs {
+		open[p.name] = p
+	}
+	var packs []*pack
+	for _, dir := range r.objDirs {
+		idxs, _ := filepath.Glob(filepath.Join(dir, "pack", "*.idx"))
+		for _, idx := range idxs {
+			if p, ok := open[idx]; ok {
+				packs = append(packs, p)
+				delete(open, idx)
+				continue
+			}
+			// Packs that disappear or are half-written are skipped, as git does.
+			if p, err := openPack(idx); err == nil {
+				packs = append(packs, p)
+			}
+		}
+	}
+	for _, p := range open {
+		p.close()
+	}
+	r.packs = packs
+	r.loaded = true
+}
+
+// findPacked locates an object in the packs, rescanning the pack directories once on
+// a miss in case a concurrent gc or fetch added new packs.
+func (r *Repo) findPacked(hash string) (*pack, int64) {
+	name, err := hex.DecodeString(hash)
+	if err != nil || len(name) != 20 {
+		return nil, 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for attempt := 0; attempt < 2; attempt++ {
+		if !r.loaded || attempt == 1 {
+			r.loadPacks()
+		}
+		for _, p := range r.packs {
+			if off, ok := p.find(name); ok {
+				return p, off
+			}
+		}
+	}
+	return nil, 0
+}
+
+func (r *Repo) readPacked(hash string) (ObjectType, []byte, error) {
+	p, off := r.findPacked(hash)
+	if p == nil {
+		return 0, nil, ErrNotFound
+	}
+	return r.readPackObject(p, off, 0)
+}
+
+func (r *Repo) readPackObject(p *pack, off int64, depth int) (ObjectType, []byte, error) {
+	if depth > maxDeltaDepth {
+		return 0, nil, fmt.Errorf("delta chain too deep")
+	}
+	p.mu.Lock()
+	if c, ok := p.cache[off]; ok {
+		p.mu.Unlock()
+		return c.t, c.data, nil
+	}
+	p.mu.Unlock()
+
+	br := bufio.NewReader(io.NewSectionReader(p.file, off, math.MaxInt64-off))
+	b, err := br.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	kind := int(b>>4) & 7
+	size := int64(b & 0x0f)
+	for shift := 4; b&0x80 != 0; shift += 7 {
+		if b, err = br.ReadByte(); err != nil {
+			return 0, nil, err
+		}
+		size |= int64(b&0x7f) << shift
+	}
+
+	var baseType ObjectType
+	var base []byte
+	switch kind {
+	case packOfsDelta:
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		rel := int64(b & 0x7f)
+		for b&0x80 != 0 {
+			if b, err = br.ReadByte(); err != nil {
+				return 0, nil, err
+			}
+			rel = (rel+1)<<7 | int64(b&0x7f)
+		}
+		if baseType, base, err = r.readPackObject(p, off-rel, depth+1); err != nil {
+			return 0, nil, err
+		}
+	case packRefDelta:
+		name := make([]byte, 20)
+		if _, err := io.ReadFull(br, name); err != nil {
+			return 0, nil, err
+		}
+		if baseType, base, err = r.ReadObject(hex.EncodeToString(name)); err != nil {
+			return 0, nil, err
+		}
+	case int(TypeCommit), int(TypeTree), int(TypeBlob), int(TypeTag):
+	default:
+		return 0, nil, fmt.Errorf("unknown pack object type %d at offset %d", kind, off)
+	}
+
+	zr, err := zlib.NewReader(br)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer zr.Close()
+	data := make([]byte, size)
+	if _, err := io.ReadFull(zr, data); err != nil {
+		return 0, nil, fmt.Errorf("inflate pack object at offset %d: %w", off, err)
+	}
+
+	t := ObjectType(kind)
+	if base != nil {
+		t = baseType
+		if data, err = applyDelta(base, data); err != nil {
+			return 0, nil, fmt.Errorf("pack object at offset %d: %w", off, err)
+		}
+	}
+	if depth > 0 {
+		p.mu.Lock()
+		if p.cacheBytes+len(data) > packCacheBytes {
+			p.cache, p.cacheBytes = make(map[int64]cachedObject), 0
+		}
+		p.cache[off] = cachedObject{t: t, data: data}
+		p.cacheBytes += len(data)
+		p.mu.Unlock()
+	}
+	return t, data, nil
+}
+
+// applyDelta applies a git delta to base.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	srcSize, delta := deltaSize(delta)
+	if srcSize != len(base) {
+		return nil, fmt.Errorf("delta base size mismatch")
+	}
+	dstSize, delta := deltaSize(delta)
+	out := make([]byte, 0, dstSize)
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		switch {
+		case op&0x80 != 0:
+			var offset, size int
+			for i := 0; i < 4; i++ {
+				if op&(1<<i) != 0 {
+					if len(delta) == 0 {
+						return nil, fmt.Errorf("truncated delta")
+					}
+					offset |= int(delta[0]) << (8 * i)
+					delta = delta[1:]
+				}
+			}
+			for i := 0; i < 3; i++ {
+				if op&(0x10<<i) != 0 {
+					if len(delta) == 0 {
+						return nil, fmt.Errorf("truncated delta")
+					}
+					size |= int(delta[0]) << (8 * i)
+					delta = delta[1:]
+				}
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if offset+size > len(base) {
+				return nil, fmt.Errorf("delta copy out of range")
+			}
+			out = append(out, base[offset:offset+size]...)
+		case op != 0:
+			if int(op) > len(delta) {
+				return nil, fmt.Errorf("truncated delta")
+			}
+			out = append(out, delta[:op]...)
+			delta = delta[op:]
+		default:
+			return nil, fmt.Errorf("invalid delta opcode 0")
+		}
+	}
+	if len(out) != dstSize {
+		return nil, fmt.Errorf("delta result size mismatch")
+	}
+	return out, nil
+}
+
+func deltaSize(delta []byte) (int, []byte) {
+	size, shift := 0, 0
+	for len(delta) > 0 {
+		b := delta[0]
+		delta = delta[1:]
+		size |= int(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return size, delta
+}
@@ -0,0 +1,463 @@
+package gitobj
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrRefChanged is returned by UpdateRef when the ref does not have the expected old value.
+var ErrRefChanged = errors.New("ref changed concurrently")
+
+// ErrRefLocked is returned by UpdateRef when another writer holds the ref's lock file.
+var ErrRefLocked = errors.New("ref is locked")
+
+// refPath returns the loose file of a ref.
+func (r *Repo) refPath(name string) string {
+	return filepath.Join(r.refDir(name), filepath.FromSlash(name))
+}
+
+// refDir returns the git directory holding a ref and its reflog. Pseudo-refs and
+// per-worktree refs live in the worktree's git directory, everything else in the
+// common directory.
+func (r *Repo) refDir(name string) string {
+	if !strings.HasPrefix(name, "refs/") || strings.HasPrefix(name, "refs/worktree/") ||
+		strings.HasPrefix(name, "refs/bisect/") || strings.HasPrefix(name, "refs/rewritten/") {
+		return r.gitDir
+	}
+	return r.commonDir
+}
+
+// ReadRef returns the object a fully qualified ref (or HEAD) points to, following
+// symbolic refs. It returns ErrNotFound if the ref does not exist.
+func (r *Repo) ReadRef(name string) (string, error) {
+	for depth := 0; depth < 5; depth++ {
+		data, err := os.ReadFile(r.refPath(name))
+		if err == nil {
+			v := strings.TrimSpace(string(data))
+			if target, ok := strings.CutPrefix(v, "ref: "); ok {
+				name = target
+				continue
+			}
+			if !isFullHash(v) {
+				return "", fmt.Errorf("ref %s: malformed value %q", name, v)
+			}
+			return v, nil
+		}
+		if !os.IsNotExist(err) && !isDirErr(err) {
+			return "", fmt.Errorf("read ref %s: %w", name, err)
+		}
+		packed, err := r.packedRefs()
+		if err != nil {
+			return "", err
+		}
+		if v, ok := packed[name]; ok {
+			return v, nil
+		}
+		return "", fmt.Errorf("ref %s: %w", name, ErrNotFound)
+	}
+	return "", fmt.Errorf("ref %s: symbolic ref loop", name)
+}
+
+// isDirErr reports a read of a directory, e.g. refs/heads/a when refs/heads/a/b exists.
+func isDirErr(err error) bool {
+	var pe *os.PathError
+	if errors.As(err, &pe) {
+		if info, serr := os.Stat(pe.Path); serr == nil && info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Repo) packedRefs() (map[string]string, error) {
+	f, err := os.Open(filepath.Join(r.commonDir, "packed-refs"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read packed-refs: %w", err)
+	}
+	defer f.Close()
+	refs := make(map[string]string)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" || line[0] == '#' || line[0] == '^' {
+			continue
+		}
+		hash, name, ok := strings.Cut(line, " ")
+		if ok && isFullHash(hash) {
+			refs[name] = hash
+		}
+	}
+	return refs, sc.Err()
+}
+
+// dwimRules are the places git looks for a short ref name, in order.
+var dwimRules = []string{"%s", "refs/%s", "refs/tags/%s", "refs/heads/%s", "refs/remotes/%s", "refs/remotes/%s/HEAD"}
+
+// DWIMRef expands a short ref name the way git does ("main" -> refs/heads/main) and
+// returns the full ref name and the object it points to.
+func (r *Repo) DWIMRef(short string) (string, string, error) {
+	for _, rule := range dwimRules {
+		name := fmt.Sprintf(rule, short)
+		if name == short && !strings.HasPrefix(name, "refs/") && !isPseudoRef(name) {
+			continue
+		}
+		hash, err := r.ReadRef(name)
+		if err == nil {
+			return name, hash, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return "", "", err
+		}
+	}
+	return "", "", fmt.Errorf("ref %s: %w", short, ErrNotFound)
+}
+
+func isPseudoRef(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !('A' <= c && c <= 'Z' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// UpdateRef sets a fully qualified ref to newHash if its current value is oldHash.
+// An empty oldHash skips the check; ZeroHash requires that the ref does not exist.
+// The ref is locked with a .lock file the way git does, so concurrent git processes
+// and other callers serialize on it; a held lock fails the update.
+func (r *Repo) UpdateRef(name, newHash, oldHash string) error {
+	return r.UpdateRefs([]RefUpdate{{Name: name, New: newHash, Old: oldHash}})
+}
+
+// RefUpdate is one change in an UpdateRefs transaction. New ZeroHash deletes the ref;
+// Old is checked like UpdateRef's oldHash. Message goes into the reflog entries.
+type RefUpdate struct {
+	Name, New, Old string
+	Message        string
+}
+
+// UpdateRefs applies updates as one transaction, like `git update-ref --stdin` with
+// start/prepare/commit: every ref is locked and checked before any is written, so a
+// failed check or a held lock leaves all of them unchanged. Deleted refs are also
+// removed from packed-refs, and their reflogs with them. Updates are appended to the
+// reflogs git would write (see reflogs).
+func (r *Repo) UpdateRefs(updates []RefUpdate) error {
+	var locked, olds []string
+	packedLock := ""
+	defer func() {
+		for _, path := range locked {
+			os.Remove(path + ".lock")
+		}
+		if packedLock != "" {
+			os.Remove(packedLock)
+		}
+	}()
+	var deleted []string
+	logs := make([][]string, len(updates))
+	needIdent := false
+	for i, u := range updates {
+		path, old, err := r.lockRef(u)
+		if err != nil {
+			return err
+		}
+		locked, olds = append(locked, path), append(olds, old)
+		if u.New == ZeroHash {
+			deleted = append(deleted, u.Name)
+			continue
+		}
+		logs[i] = r.reflogs(u.Name)
+		needIdent = needIdent || len(logs[i]) > 0
+	}
+	if len(deleted) > 0 {
+		var err error
+		if packedLock, err = r.lockPackedRefs(deleted); err != nil {
+			return err
+		}
+	}
+	ident := ""
+	if needIdent {
+		var err error
+		if ident, err = r.Ident("COMMITTER"); err != nil {
+			return fmt.Errorf("update reflog: %w", err)
+		}
+	}
+
+	if packedLock != "" {
+		if err := os.Rename(packedLock, strings.TrimSuffix(packedLock, ".lock")); err != nil {
+			return fmt.Errorf("update packed-refs: %w", err)
+		}
+		packedLock = ""
+	}
+	for i := range updates {
+		path, u := locked[0], updates[i]
+		if u.New == ZeroHash {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("delete ref %s: %w", u.Name, err)
+			}
+			os.Remove(path + ".lock")
+			pruneRefDirs(filepath.Dir(path))
+			if log := r.reflogPath(u.Name); os.Remove(log) == nil {
+				pruneRefDirs(filepath.Dir(log))
+			}
+		} else {
+			// Like git, log the update while the ref is still locked.
+			for _, log := range logs[i] {
+				if err := appendReflog(log, reflogEntry(olds[i], u.New, ident, u.Message)); err != nil {
+					return fmt.Errorf("update reflog of %s: %w", u.Name, err)
+				}
+			}
+			if err := os.Rename(path+".lock", path); err != nil {
+				return fmt.Errorf("update ref %s: %w", u.Name, err)
+			}
+		}
+		locked = locked[1:]
+	}
+	return nil
+}
+
+// reflogs returns the reflog files an update of name appends to, following
+// core.logAllRefUpdates: by default a non-bare repository logs branches,
+// remote-tracking refs, notes and HEAD, "always" logs every ref, and a ref whose
+// reflog already exists is always logged. An update of the branch HEAD points to is
+// logged for HEAD too, as git does.
+func (r *Repo) reflogs(name string) []string {
+	var logs []string
+	for _, ref := range []string{name, r.headTarget(name)} {
+		if ref == "" {
+			continue
+		}
+		path := r.reflogPath(ref)
+		if _, err := os.Stat(path); err == nil || r.autocreateReflog(ref) {
+			logs = append(logs, path)
+		}
+	}
+	return logs
+}
+
+// headTarget returns "HEAD" if HEAD is a symbolic ref to name, or "".
+func (r *Repo) headTarget(name string) string {
+	data, err := os.ReadFile(filepath.Join(r.gitDir, "HEAD"))
+	if err != nil {
+		return ""
+	}
+	if target, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "ref: "); ok && target == name {
+		return "HEAD"
+	}
+	return ""
+}
+
+func (r *Repo) autocreateReflog(name string) bool {
+	switch strings.ToLower(r.config.Get("core", "logallrefupdates")) {
+	case "always":
+		return true
+	case "false", "no", "off", "0":
+		return false
+	case "":
+		if isTrue(r.config.Get("core", "bare")) {
+			return false
+		}
+	}
+	return name == "HEAD" || strings.HasPrefix(name, "refs/heads/") ||
+		strings.HasPrefix(name, "refs/remotes/") || strings.HasPrefix(name, "refs/notes/")
+}
+
+// reflogPath returns the reflog file of a ref, next to the ref under logs/.
+func (r *Repo) reflogPath(name string) string {
+	return filepath.Join(r.refDir(name), "logs", filepath.FromSlash(name))
+}
+
+// reflogEntry formats a reflog line the way git does: "<old> <new> <ident>\t<msg>",
+// with the message folded onto one line.
+func reflogEntry(old, new, ident, msg string) string {
+	msg = strings.Join(strings.Fields(msg), " ")
+	return fmt.Sprintf("%s %s %s\t%s\n", old, new, ident, msg)
+}
+
+func appendReflog(path, entry string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(entry); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func isTrue(v string) bool {
+	switch strings.ToLower(v) {
+	case "true", "yes", "on", "1":
+		return true
+	}
+	return false
+}
+
+// lockRef creates the lock file for u holding its new value, after checking its old
+// value under the lock. It returns the ref's path and its current value (ZeroHash if
+// it does not exist).
+func (r *Repo) lockRef(u RefUpdate) (string, string, error) {
+	if !isFullHash(u.New) {
+		return "", "", fmt.Errorf("update ref %s: invalid object name %q", u.Name, u.New)
+	}
+	path := r.refPath(u.Name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", "", fmt.Errorf("update ref %s: %w", u.Name, err)
+	}
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		return "", "", fmt.Errorf("update ref %s: refs exist below it", u.Name)
+	}
+	lock, err := os.OpenFile(path+".lock", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return "", "", fmt.Errorf("update ref %s: %w", u.Name, ErrRefLocked)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("update ref %s: cannot lock: %w", u.Name, err)
+	}
+	fail := func(err error) (string, string, error) {
+		lock.Close()
+		os.Remove(path + ".lock")
+		return "", "", fmt.Errorf("update ref %s: %w", u.Name, err)
+	}
+
+	current, err := r.ReadRef(u.Name)
+	if errors.Is(err, ErrNotFound) {
+		current, err = ZeroHash, nil
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if u.Old != "" && current != u.Old {
+		return fail(fmt.Errorf("expected %s, found %s: %w", u.Old, current, ErrRefChanged))
+	}
+	if _, err := lock.WriteString(u.New + "\n"); err != nil {
+		return fail(err)
+	}
+	if err := lock.Close(); err != nil {
+		os.Remove(path + ".lock")
+		return "", "", fmt.Errorf("update ref %s: %w", u.Name, err)
+	}
+	return path, current, nil
+}
+
+// lockPackedRefs writes packed-refs.lock with the named refs (and their peeled lines)
+// left out and returns its path, or "" if none of them is packed.
+func (r *Repo) lockPackedRefs(names []string) (string, error) {
+	path := filepath.Join(r.commonDir, "packed-refs")
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("read packed-refs: %w", err)
+	}
+	drop := make(map[string]bool, len(names))
+	for _, name := range names {
+		drop[name] = true
+	}
+	var kept strings.Builder
+	changed, skipping := false, false
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		if line[0] == '^' {
+			if !skipping {
+				kept.WriteString(line)
+			}
+			continue
+		}
+		_, name, _ := strings.Cut(strings.TrimSuffix(line, "\n"), " ")
+		skipping = line[0] != '#' && drop[name]
+		if skipping {
+			changed = true
+			continue
+		}
+		kept.WriteString(line)
+	}
+	if !changed {
+		return "", nil
+	}
+	lock, err := os.OpenFile(path+".lock", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return "", fmt.Errorf("update packed-refs: %w", ErrRefLocked)
+	}
+	if err != nil {
+		return "", fmt.Errorf("update packed-refs: cannot lock: %w", err)
+	}
+	if _, err := lock.WriteString(kept.String()); err != nil {
+		lock.Close()
+		os.Remove(path + ".lock")
+		return "", fmt.Errorf("update packed-refs: %w", err)
+	}
+	if err := lock.Close(); err != nil {
+		os.Remove(path + ".lock")
+		return "", fmt.Errorf("update packed-refs: %w", err)
+	}
+	return path + ".lock", nil
+}
+
+// pruneRefDirs removes directories left empty by a deleted ref, up to refs/.
+func pruneRefDirs(dir string) {
+	for filepath.Base(dir) != "refs" && os.Remove(dir) == nil {
+		dir = filepath.Dir(dir)
+	}
+}
+
+// ListRefs returns the sorted names of all loose and packed refs starting with prefix,
+// which must begin with "refs/".
+func (r *Repo) ListRefs(prefix string) ([]string, error) {
+	seen := make(map[string]bool)
+	packed, err := r.packedRefs()
+	if err != nil {
+		return nil, err
+	}
+	for name := range packed {
+		if strings.HasPrefix(name, prefix) {
+			seen[name] = true
+		}
+	}
+	start := filepath.Join(r.commonDir, filepath.FromSlash(prefix[:strings.LastIndex(prefix, "/")+1]))
+	err = filepath.WalkDir(start, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(path, ".lock") {
+			return nil
+		}
+		rel, err := filepath.Rel(r.commonDir, path)
+		if err != nil {
+			return err
+		}
+		if name := filepath.ToSlash(rel); strings.HasPrefix(name, prefix) {
+			seen[name] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list refs %s: %w", prefix, err)
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
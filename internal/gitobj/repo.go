@@ -0,0 +1,112 @@
+// Package gitobj reads and writes a repository's object database, refs, notes and
+// config directly, without running git. It supports SHA-1 repositories with loose
+// objects, version 2 pack indexes and the files ref storage with packed-refs.
+package gitobj
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Repo is an opened repository. It is safe for concurrent use.
+type Repo struct {
+	gitDir    string // per-worktree git directory (HEAD, worktree-local refs)
+	commonDir string // shared git directory (objects, refs, config)
+	objDirs   []string
+	config    *Config
+
+	mu     sync.Mutex
+	packs  []*pack
+	loaded bool
+	index  []string // sorted hashes of all objects, for abbreviations; nil until needed
+}
+
+// Open opens the repository whose worktree (or bare git directory) is path.
+func Open(path string) (*Repo, error) {
+	gitDir, err := findGitDir(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Repo{gitDir: gitDir, commonDir: gitDir}
+	if data, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		r.commonDir = resolvePath(gitDir, strings.TrimSpace(string(data)))
+	}
+
+	r.config, err = LoadConfig(filepath.Join(r.commonDir, "config"))
+	if err != nil {
+		return nil, err
+	}
+	if format := r.config.Get("extensions", "objectformat"); format != "" && format != "sha1" {
+		return nil, fmt.Errorf("unsupported object format %s", format)
+	}
+	if storage := r.config.Get("extensions", "refstorage"); storage != "" && storage != "files" {
+		return nil, fmt.Errorf("unsupported ref storage %s", storage)
+	}
+
+	objects := filepath.Join(r.commonDir, "objects")
+	r.objDirs = []string{objects}
+	if data, err := os.ReadFile(filepath.Join(objects, "info", "alternates")); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				r.objDirs = append(r.objDirs, resolvePath(objects, line))
+			}
+		}
+	}
+	return r, nil
+}
+
+// Config returns the merged global and repository configuration.
+func (r *Repo) Config() *Config {
+	return r.config
+}
+
+// Close releases open pack files.
+func (r *Repo) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range r.packs {
+		p.close()
+	}
+	r.packs, r.loaded, r.index = nil, false, nil
+	return nil
+}
+
+func findGitDir(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	dotGit := filepath.Join(abs, ".git")
+	info, err := os.Stat(dotGit)
+	switch {
+	case err == nil && info.IsDir():
+		return dotGit, nil
+	case err == nil:
+		data, err := os.ReadFile(dotGit)
+		if err != nil {
+			return "", err
+		}
+		dir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+		if !ok {
+			return "", fmt.Errorf("%s: malformed .git file", dotGit)
+		}
+		return resolvePath(abs, dir), nil
+	}
+	if _, err := os.Stat(filepath.Join(abs, "objects")); err == nil {
+		if _, err := os.Stat(filepath.Join(abs, "HEAD")); err == nil {
+			return abs, nil // bare repository
+		}
+	}
+	return "", fmt.Errorf("%s: not a git repository", abs)
+}
+
+func resolvePath(base, p string) string {
+	if filepath.IsAbs(p) {
+		return filepath.Clean(p)
+	}
+	return filepath.Join(base, p)
+}
@@ -0,0 +1,112 @@
+package gitobj
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupportedRevision is returned by RevParse for revision syntax it does not
+// implement (ranges, reflogs, :/search, ...); callers may fall back to git.
+var ErrUnsupportedRevision = errors.New("unsupported revision syntax")
+
+// RevParse resolves a revision to a full object name. It understands full and
+// abbreviated hashes, ref names as git expands them, HEAD and @, and the suffixes
+// ~N, ^N and ^{type}/^{}.
+func (r *Repo) RevParse(rev string) (string, error) {
+	base, suffix := rev, ""
+	if i := strings.IndexAny(rev, "^~"); i >= 0 {
+		base, suffix = rev[:i], rev[i:]
+	}
+	if base == "" || strings.ContainsAny(base, ": \t") || strings.Contains(base, "..") || strings.Contains(base, "@{") {
+		return "", fmt.Errorf("%q: %w", rev, ErrUnsupportedRevision)
+	}
+	hash, err := r.resolveBase(base)
+	if err != nil {
+		return "", err
+	}
+
+	for suffix != "" {
+		op := suffix[0]
+		suffix = suffix[1:]
+		if op == '^' && strings.HasPrefix(suffix, "{") {
+			end := strings.IndexByte(suffix, '}')
+			if end < 0 {
+				return "", fmt.Errorf("%q: %w", rev, ErrUnsupportedRevision)
+			}
+			var want ObjectType
+			if name := suffix[1:end]; name != "" {
+				if want, err = parseType(name); err != nil {
+					return "", fmt.Errorf("%q: %w", rev, ErrUnsupportedRevision)
+				}
+			}
+			suffix = suffix[end+1:]
+			if hash, err = r.Peel(hash, want); err != nil {
+				return "", err
+			}
+			continue
+		}
+		digits := len(suffix) - len(strings.TrimLeft(suffix, "0123456789"))
+		n := 1
+		if digits > 0 {
+			if n, err = strconv.Atoi(suffix[:digits]); err != nil {
+				return "", fmt.Errorf("%q: %w", rev, ErrUnsupportedRevision)
+			}
+			suffix = suffix[digits:]
+		}
+		if hash, err = r.Peel(hash, TypeCommit); err != nil {
+			return "", err
+		}
+		if op == '^' {
+			if n == 0 {
+				continue
+			}
+			if hash, err = r.parent(hash, n); err != nil {
+				return "", fmt.Errorf("%q: %w", rev, err)
+			}
+			continue
+		}
+		for ; n > 0; n-- {
+			if hash, err = r.parent(hash, 1); err != nil {
+				return "", fmt.Errorf("%q: %w", rev, err)
+			}
+		}
+	}
+	return hash, nil
+}
+
+func (r *Repo) resolveBase(base string) (string, error) {
+	if base == "@" {
+		base = "HEAD"
+	}
+	lower := strings.ToLower(base)
+	if isFullHash(lower) {
+		if !r.HasObject(lower) {
+			return "", fmt.Errorf("object %s: %w", lower, ErrNotFound)
+		}
+		return lower, nil
+	}
+	_, hash, err := r.DWIMRef(base)
+	if err == nil {
+		return hash, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return "", err
+	}
+	if len(lower) >= 4 && isHex(lower) {
+		return r.ResolvePrefix(lower)
+	}
+	return "", fmt.Errorf("unknown revision %q: %w", base, ErrNotFound)
+}
+
+func (r *Repo) parent(hash string, n int) (string, error) {
+	c, err := r.ReadCommit(hash)
+	if err != nil {
+		return "", err
+	}
+	if n > len(c.Parents) {
+		return "", fmt.Errorf("commit %s has no parent %d: %w", hash, n, ErrNotFound)
+	}
+	return c.Parents[n-1], nil
+}
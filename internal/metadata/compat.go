@@ -0,0 +1,217 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Versioning policy: a spec is "squash-tree/v<major>" or "squash-tree/v<major>.<minor>".
+// Notes with a supported major version are read with that major's rules; a newer minor
+// version or unknown fields only produce warnings, and unknown fields are written back
+// unchanged. Any other major version is rejected with ErrUnsupportedSpec.
+
+const specPrefix = "squash-tree/v"
+
+// supportedMinor is the newest minor version understood for each supported major version.
+// Minor version 1 of both adds the child fields action and parents, rewritten_from
+// and derived_from.
+var supportedMinor = map[int]int{
+	1: 1,
+	2: 1,
+}
+
+// ErrUnsupportedSpec is matched (via errors.Is) by errors for notes whose spec major
+// version this build cannot read.
+var ErrUnsupportedSpec = errors.New("unsupported spec version")
+
+// UnsupportedSpecError carries the spec string of a note that cannot be read.
+type UnsupportedSpecError struct {
+	Spec string
+}
+
+func (e *UnsupportedSpecError) Error() string {
+	return fmt.Sprintf("unsupported spec version: %s (expected %s or %s)", e.Spec, SpecVersionV1, SpecVersionV2)
+}
+
+func (e *UnsupportedSpecError) Unwrap() error {
+	return ErrUnsupportedSpec
+}
+
+// ParseSpecVersion splits a spec string into its major and minor version.
+func ParseSpecVersion(spec string) (major, minor int, err error) {
+	v, ok := strings.CutPrefix(spec, specPrefix)
+	if !ok {
+		return 0, 0, fmt.Errorf("malformed spec %q", spec)
+	}
+	majorStr, minorStr, hasMinor := strings.Cut(v, ".")
+	major, err = strconv.Atoi(majorStr)
+	if err != nil || major < 1 {
+		return 0, 0, fmt.Errorf("malformed spec %q", spec)
+	}
+	if hasMinor {
+		minor, err = strconv.Atoi(minorStr)
+		if err != nil || minor < 0 {
+			return 0, 0, fmt.Errorf("malformed spec %q", spec)
+		}
+	}
+	return major, minor, nil
+}
+
+// checkSpec returns the major version to validate with, or an *UnsupportedSpecError.
+func checkSpec(m *SquashMetadata) (int, error) {
+	major, minor, err := ParseSpecVersion(m.Spec)
+	if err != nil {
+		return 0, &UnsupportedSpecError{Spec: m.Spec}
+	}
+	known, ok := supportedMinor[major]
+	if !ok {
+		return 0, &UnsupportedSpecError{Spec: m.Spec}
+	}
+	if minor > known {
+		m.Warnings = append(m.Warnings, fmt.Sprintf("spec %s is newer than this build supports; reading with %s%d rules", m.Spec, specPrefix, major))
+	}
+	return major, nil
+}
+
+// RaiseSpecMinor raises the minor version of m.Spec to the lowest one that defines
+// every optional field m uses, so readers of an older minor version warn about them
+// instead of silently dropping them. A newer minor version is left as is.
+func (m *SquashMetadata) RaiseSpecMinor() {
+	major, minor, err := ParseSpecVersion(m.Spec)
+	if err != nil {
+		return
+	}
+	if need := fieldsMinor(m); need > minor {
+		m.Spec = fmt.Sprintf("%s%d.%d", specPrefix, major, need)
+	}
+}
+
+// fieldsMinor returns the minor version that introduced the newest field m uses.
+func fieldsMinor(m *SquashMetadata) int {
+	if m.RewrittenFrom != "" || m.DerivedFrom != "" {
+		return 1
+	}
+	for _, c := range m.Children {
+		if c.Action != "" || len(c.Parents) > 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+func (m *SquashMetadata) UnmarshalJSON(data []byte) error {
+	type plain SquashMetadata
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	extra, err := unknownFields(data, reflect.TypeOf(p))
+	if err != nil {
+		return err
+	}
+	*m = SquashMetadata(p)
+	m.Extra = extra
+	return nil
+}
+
+func (m SquashMetadata) MarshalJSON() ([]byte, error) {
+	type plain SquashMetadata
+	data, err := json.Marshal(plain(m))
+	if err != nil {
+		return nil, err
+	}
+	return appendFields(data, m.Extra)
+}
+
+func (c *ChildCommit) UnmarshalJSON(data []byte) error {
+	type plain ChildCommit
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	extra, err := unknownFields(data, reflect.TypeOf(p))
+	if err != nil {
+		return err
+	}
+	*c = ChildCommit(p)
+	c.Extra = extra
+	return nil
+}
+
+func (c ChildCommit) MarshalJSON() ([]byte, error) {
+	type plain ChildCommit
+	data, err := json.Marshal(plain(c))
+	if err != nil {
+		return nil, err
+	}
+	return appendFields(data, c.Extra)
+}
+
+// unknownWarnings describes unknown fields preserved in m and its children.
+func unknownWarnings(m *SquashMetadata) []string {
+	var warnings []string
+	if len(m.Extra) > 0 {
+		warnings = append(warnings, fmt.Sprintf("unknown fields preserved: %s", strings.Join(sortedKeys(m.Extra), ", ")))
+	}
+	for _, c := range m.Children {
+		if len(c.Extra) > 0 {
+			warnings = append(warnings, fmt.Sprintf("unknown fields preserved in child %s: %s", c.Hash, strings.Join(sortedKeys(c.Extra), ", ")))
+		}
+	}
+	return warnings
+}
+
+// unknownFields returns the members of the JSON object data that t has no json tag for.
+func unknownFields(data []byte, t reflect.Type) (map[string]json.RawMessage, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		delete(raw, name)
+	}
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	return raw, nil
+}
+
+// appendFields adds extra members, in key order, to the end of the JSON object obj.
+func appendFields(obj []byte, extra map[string]json.RawMessage) ([]byte, error) {
+	if len(extra) == 0 {
+		return obj, nil
+	}
+	var buf bytes.Buffer
+	buf.Write(obj[:len(obj)-1])
+	empty := bytes.Equal(bytes.TrimSpace(obj), []byte("{}"))
+	for i, k := range sortedKeys(extra) {
+		key, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 || !empty {
+			buf.WriteByte(',')
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(extra[k])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func sortedKeys(m map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
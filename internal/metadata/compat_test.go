@@ -0,0 +1,155 @@
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseSpecVersion(t *testing.T) {
+	tests := []struct {
+		spec         string
+		major, minor int
+		wantErr      bool
+	}{
+		{"squash-tree/v1", 1, 0, false},
+		{"squash-tree/v2.3", 2, 3, false},
+		{"squash-tree/v0", 0, 0, true},
+		{"squash-tree/vx", 0, 0, true},
+		{"v1", 0, 0, true},
+	}
+	for _, tt := range tests {
+		major, minor, err := ParseSpecVersion(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSpecVersion(%q): err = %v", tt.spec, err)
+			continue
+		}
+		if major != tt.major || minor != tt.minor {
+			t.Errorf("ParseSpecVersion(%q) = %d.%d, want %d.%d", tt.spec, major, minor, tt.major, tt.minor)
+		}
+	}
+}
+
+func TestParse_NewerMinorWithUnknownFields(t *testing.T) {
+	data := []byte(`{
+		"spec": "squash-tree/v2.9",
+		"type": "squash",
+		"root": "r",
+		"base": "b",
+		"children": [{"hash": "c", "order": 1, "patch_id": "p1"}],
+		"created_at": "2026-01-01T00:00:00Z",
+		"strategy": "rebase",
+		"signature": {"alg": "ed25519", "sig": "abc"}
+	}`)
+	meta, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(meta.Warnings) != 3 {
+		t.Fatalf("Warnings: got %q", meta.Warnings)
+	}
+	if !strings.Contains(meta.Warnings[0], "newer") || !strings.Contains(meta.Warnings[1], "signature") || !strings.Contains(meta.Warnings[2], "patch_id") {
+		t.Errorf("Warnings: got %q", meta.Warnings)
+	}
+
+	out, err := json.Marshal(meta)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var roundTrip map[string]interface{}
+	if err := json.Unmarshal(out, &roundTrip); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if roundTrip["spec"] != "squash-tree/v2.9" {
+		t.Errorf("spec not preserved: %v", roundTrip["spec"])
+	}
+	sig, ok := roundTrip["signature"].(map[string]interface{})
+	if !ok || sig["alg"] != "ed25519" {
+		t.Errorf("signature not preserved: %s", out)
+	}
+	child := roundTrip["children"].([]interface{})[0].(map[string]interface{})
+	if child["patch_id"] != "p1" {
+		t.Errorf("child patch_id not preserved: %s", out)
+	}
+}
+
+func TestParse_UnsupportedMajor(t *testing.T) {
+	// A future major version may change field types; it must still fail with ErrUnsupportedSpec.
+	_, err := Parse([]byte(`{"spec":"squash-tree/v3","children":{"layout":"dag"}}`))
+	if !errors.Is(err, ErrUnsupportedSpec) {
+		t.Fatalf("Parse(v3): got %v, want ErrUnsupportedSpec", err)
+	}
+	var specErr *UnsupportedSpecError
+	if !errors.As(err, &specErr) || specErr.Spec != "squash-tree/v3" {
+		t.Errorf("UnsupportedSpecError: got %+v", specErr)
+	}
+}
+
+func TestParse_KnownVersionHasNoWarnings(t *testing.T) {
+	meta, err := Parse([]byte(`{"spec":"squash-tree/v1","type":"squash","root":"r","base":"b","children":[{"hash":"c","order":1}],"created_at":"2026-01-01T00:00:00Z"}`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(meta.Warnings) != 0 || meta.Extra != nil {
+		t.Errorf("Warnings=%q Extra=%v", meta.Warnings, meta.Extra)
+	}
+}
+
+func TestParse_UnknownActionIsAWarning(t *testing.T) {
+	meta, err := Parse([]byte(`{"spec":"squash-tree/v1.1","type":"squash","root":"r","base":"b","children":[{"hash":"c","order":1,"action":"drop"}],"created_at":"2026-01-01T00:00:00Z"}`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(meta.Warnings) != 1 || !strings.Contains(meta.Warnings[0], `unknown action "drop"`) {
+		t.Errorf("Warnings: got %q", meta.Warnings)
+	}
+	if meta.Children[0].Action != "drop" {
+		t.Errorf("action not preserved: %q", meta.Children[0].Action)
+	}
+}
+
+func TestRaiseSpecMinor(t *testing.T) {
+	tests := []struct {
+		spec   string
+		action string
+		want   string
+	}{
+		{SpecVersionV1, "", SpecVersionV1},
+		{SpecVersionV1, ActionFixup, "squash-tree/v1.1"},
+		{SpecVersionV2, ActionSquash, "squash-tree/v2.1"},
+		{"squash-tree/v2.3", ActionSquash, "squash-tree/v2.3"},
+	}
+	for _, tt := range tests {
+		m := &SquashMetadata{Spec: tt.spec, Children: []ChildCommit{{Hash: "c", Order: 1, Action: tt.action}}}
+		m.RaiseSpecMinor()
+		if m.Spec != tt.want {
+			t.Errorf("RaiseSpecMinor(%s, action %q) = %s, want %s", tt.spec, tt.action, m.Spec, tt.want)
+		}
+	}
+	for _, m := range []*SquashMetadata{
+		{Spec: SpecVersionV1, RewrittenFrom: "r0", Children: []ChildCommit{{Hash: "c", Order: 1}}},
+		{Spec: SpecVersionV1, DerivedFrom: "r0", Children: []ChildCommit{{Hash: "c", Order: 1}}},
+		{Spec: SpecVersionV1, Children: []ChildCommit{{Hash: "c", Order: 1, Parents: []string{"b"}}}},
+	} {
+		if m.RaiseSpecMinor(); m.Spec != "squash-tree/v1.1" {
+			t.Errorf("RaiseSpecMinor(%+v) = %s, want squash-tree/v1.1", m, m.Spec)
+		}
+	}
+}
+
+func TestParse_MalformedV1AuthorIsAWarning(t *testing.T) {
+	for _, author := range []string{"foo>", "<>"} {
+		data := `{"spec":"squash-tree/v1","type":"squash","root":"r","base":"b","children":[{"hash":"c","order":1}],"created_at":"2026-01-01T00:00:00Z","author":"` + author + `"}`
+		meta, err := Parse([]byte(data))
+		if err != nil {
+			t.Fatalf("Parse(author %q): %v", author, err)
+		}
+		if meta.Author == nil || meta.Author.Name != author || meta.Author.Email != "" {
+			t.Errorf("Author = %+v, want name %q", meta.Author, author)
+		}
+		if len(meta.Warnings) != 1 || !strings.Contains(meta.Warnings[0], "author") {
+			t.Errorf("Warnings = %v, want one about the author", meta.Warnings)
+		}
+	}
+}
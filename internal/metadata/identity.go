@@ -0,0 +1,77 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Identity is a person recorded in v2 metadata (author or committer of the squash).
+type Identity struct {
+	Name  string `json:"name"`
+	Email string `json:"email,omitempty"`
+	Date  string `json:"date,omitempty"`
+
+	// unparsed marks a v1 string that is not "Name <email>"; it is kept whole as Name.
+	unparsed bool
+}
+
+// ParseIdentity parses "Name <email>" or a bare name.
+func ParseIdentity(s string) (Identity, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Identity{}, fmt.Errorf("empty identity")
+	}
+	open := strings.LastIndex(s, "<")
+	if open < 0 {
+		if strings.Contains(s, ">") {
+			return Identity{}, fmt.Errorf("invalid identity %q (expected \"Name <email>\")", s)
+		}
+		return Identity{Name: s}, nil
+	}
+	if !strings.HasSuffix(s, ">") {
+		return Identity{}, fmt.Errorf("invalid identity %q (expected \"Name <email>\")", s)
+	}
+	id := Identity{
+		Name:  strings.TrimSpace(s[:open]),
+		Email: strings.TrimSpace(s[open+1 : len(s)-1]),
+	}
+	if id.Name == "" && id.Email == "" {
+		return Identity{}, fmt.Errorf("invalid identity %q", s)
+	}
+	return id, nil
+}
+
+func (id Identity) String() string {
+	if id.Email == "" {
+		return id.Name
+	}
+	if id.Name == "" {
+		return "<" + id.Email + ">"
+	}
+	return id.Name + " <" + id.Email + ">"
+}
+
+// UnmarshalJSON accepts both the v2 object form and the v1 "author": "<string>" form.
+// A v1 string that does not parse is kept as the name; Parse warns about it.
+func (id *Identity) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		parsed, err := ParseIdentity(s)
+		if err != nil {
+			parsed = Identity{Name: s, unparsed: true}
+		}
+		*id = parsed
+		return nil
+	}
+	type plain Identity
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*id = Identity(p)
+	return nil
+}
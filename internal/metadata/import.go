@@ -0,0 +1,120 @@
+package metadata
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ImportRecord is one line of an `import --from` file: a squash made on a server,
+// exported from the hosting platform's API. See docs/spec.md for the format.
+type ImportRecord struct {
+	Line      int      `json:"-"`
+	Squash    string   `json:"squash"`
+	Base      string   `json:"base"`
+	Children  []string `json:"children"`
+	Author    string   `json:"author,omitempty"`
+	PR        int      `json:"pr,omitempty"`
+	URL       string   `json:"url,omitempty"`
+	Strategy  string   `json:"strategy,omitempty"`
+	CreatedAt string   `json:"created_at,omitempty"`
+}
+
+// RecordError reports a failure for a single record of an import file.
+type RecordError struct {
+	Line int
+	Err  error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+type ImportInputs struct {
+	From   string
+	DryRun bool
+}
+
+func ParseImportFlags(args []string) (ImportInputs, error) {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	from := fs.String("from", "", "JSON lines file with one squash record per line (- for stdin)")
+	dryRun := fs.Bool("dry-run", false, "Validate records without writing metadata")
+	if err := fs.Parse(args); err != nil {
+		return ImportInputs{}, err
+	}
+	if fs.NArg() > 0 {
+		return ImportInputs{}, fmt.Errorf("import: unexpected argument %q", fs.Arg(0))
+	}
+	if *from == "" {
+		return ImportInputs{}, fmt.Errorf("import requires --from")
+	}
+	return ImportInputs{From: *from, DryRun: *dryRun}, nil
+}
+
+// ReadImportRecords reads JSON lines from r. Blank lines are skipped. Records that
+// fail to decode or validate are returned as *RecordError values instead of aborting
+// the whole file; only a read failure is returned as the final error.
+func ReadImportRecords(r io.Reader) ([]ImportRecord, []*RecordError, error) {
+	var records []ImportRecord
+	var recordErrs []*RecordError
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var rec ImportRecord
+		if err := json.Unmarshal([]byte(text), &rec); err != nil {
+			recordErrs = append(recordErrs, &RecordError{Line: line, Err: fmt.Errorf("failed to parse record JSON: %w", err)})
+			continue
+		}
+		rec.Line = line
+		if rec.Strategy == "" {
+			rec.Strategy = StrategyGitHub
+		}
+		if err := rec.validate(); err != nil {
+			recordErrs = append(recordErrs, &RecordError{Line: line, Err: err})
+			continue
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return records, recordErrs, fmt.Errorf("read import records: %w", err)
+	}
+	return records, recordErrs, nil
+}
+
+func (rec *ImportRecord) validate() error {
+	if rec.Squash == "" {
+		return fmt.Errorf("record missing required field: squash")
+	}
+	if rec.Base == "" {
+		return fmt.Errorf("record missing required field: base")
+	}
+	if len(rec.Children) == 0 {
+		return fmt.Errorf("record must have at least one child commit")
+	}
+	for i, c := range rec.Children {
+		if strings.TrimSpace(c) == "" {
+			return fmt.Errorf("child commit at index %d missing hash", i)
+		}
+	}
+	if rec.CreatedAt != "" {
+		if _, err := time.Parse(time.RFC3339, rec.CreatedAt); err != nil {
+			return fmt.Errorf("invalid created_at %q: %w", rec.CreatedAt, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package metadata
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadImportRecords(t *testing.T) {
+	input := strings.Join([]string{
+		`{"squash":"s1","base":"b1","children":["c1","c2"],"author":"Jane <jane@example.com>","pr":12,"url":"https://example.com/pr/12"}`,
+		``,
+		`{"squash":"s2","base":"b2","children":["c3"],"strategy":"gitlab","created_at":"2026-01-02T03:04:05Z"}`,
+		`not json`,
+		`{"squash":"s3","base":"b3","children":[]}`,
+		`{"squash":"s4","base":"b4","children":["c4"],"created_at":"yesterday"}`,
+	}, "\n")
+
+	records, recordErrs, err := ReadImportRecords(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ReadImportRecords: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("records: got %d, want 2", len(records))
+	}
+	if records[0].Line != 1 || records[0].Strategy != StrategyGitHub || records[0].PR != 12 || len(records[0].Children) != 2 {
+		t.Errorf("records[0]: %+v", records[0])
+	}
+	if records[1].Line != 3 || records[1].Strategy != StrategyGitLab {
+		t.Errorf("records[1]: %+v", records[1])
+	}
+
+	wantLines := []int{4, 5, 6}
+	if len(recordErrs) != len(wantLines) {
+		t.Fatalf("recordErrs: got %v", recordErrs)
+	}
+	for i, e := range recordErrs {
+		if e.Line != wantLines[i] {
+			t.Errorf("recordErrs[%d].Line = %d, want %d", i, e.Line, wantLines[i])
+		}
+	}
+	if !strings.Contains(recordErrs[1].Error(), "at least one child") {
+		t.Errorf("recordErrs[1]: %v", recordErrs[1])
+	}
+}
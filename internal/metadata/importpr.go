@@ -0,0 +1,144 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	StrategyGitHub = "github"
+	StrategyGitLab = "gitlab"
+)
+
+// PRMapping describes one squash merge made by a hosting platform: the squash
+// commit on the target branch, the PR head it was made from and the target base.
+type PRMapping struct {
+	Squash   string `json:"squash"`
+	Head     string `json:"head"`
+	Base     string `json:"base"`
+	Strategy string `json:"strategy,omitempty"`
+}
+
+type ImportPRInputs struct {
+	Mappings []PRMapping
+	File     string // batch mapping file; Mappings is empty when set
+	Strategy string // strategy of File rows that do not name one
+	Remote   string
+}
+
+func ParseImportPRFlags(args []string) (ImportPRInputs, error) {
+	fs := flag.NewFlagSet("import-pr", flag.ContinueOnError)
+	squash := fs.String("squash", "", "Squash commit created by the hosting platform")
+	head := fs.String("head", "", "PR head ref, e.g. refs/pull/42/head")
+	base := fs.String("base", "", "Target branch the PR was merged into")
+	strategy := fs.String("strategy", StrategyGitHub, "Strategy: github or gitlab")
+	file := fs.String("file", "", "JSON or CSV file with squash,head,base[,strategy] mappings")
+	remote := fs.String("remote", "origin", "Remote to fetch missing PR head refs from")
+	if err := fs.Parse(args); err != nil {
+		return ImportPRInputs{}, err
+	}
+	if fs.NArg() > 0 {
+		return ImportPRInputs{}, fmt.Errorf("import-pr: unexpected argument %q", fs.Arg(0))
+	}
+	if err := validatePRStrategy(*strategy); err != nil {
+		return ImportPRInputs{}, err
+	}
+	if *file != "" {
+		if *squash != "" || *head != "" || *base != "" {
+			return ImportPRInputs{}, fmt.Errorf("import-pr: --file cannot be combined with --squash, --head or --base")
+		}
+		return ImportPRInputs{File: *file, Strategy: *strategy, Remote: *remote}, nil
+	}
+	if *squash == "" || *head == "" || *base == "" {
+		return ImportPRInputs{}, fmt.Errorf("import-pr requires --squash, --head, and --base (or --file)")
+	}
+	return ImportPRInputs{
+		Mappings: []PRMapping{{Squash: *squash, Head: *head, Base: *base, Strategy: *strategy}},
+		Remote:   *remote,
+	}, nil
+}
+
+// ParsePRMappings reads a batch mapping file. JSON input is an array of
+// PRMapping objects; anything else is read as CSV with the columns
+// squash,head,base and an optional strategy. A header row is skipped. Mappings
+// without a strategy get defaultStrategy, or github if it is empty.
+func ParsePRMappings(data []byte, defaultStrategy string) ([]PRMapping, error) {
+	if defaultStrategy == "" {
+		defaultStrategy = StrategyGitHub
+	}
+	trimmed := bytes.TrimSpace(data)
+	var mappings []PRMapping
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &mappings); err != nil {
+			return nil, fmt.Errorf("failed to parse mapping JSON: %w", err)
+		}
+	} else {
+		var err error
+		mappings, err = parsePRMappingsCSV(trimmed)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range mappings {
+		m := &mappings[i]
+		if m.Squash == "" || m.Head == "" || m.Base == "" {
+			return nil, fmt.Errorf("mapping %d: squash, head and base are required", i+1)
+		}
+		if m.Strategy == "" {
+			m.Strategy = defaultStrategy
+		}
+		if err := validatePRStrategy(m.Strategy); err != nil {
+			return nil, fmt.Errorf("mapping %d: %w", i+1, err)
+		}
+	}
+	return mappings, nil
+}
+
+func parsePRMappingsCSV(data []byte) ([]PRMapping, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	r.Comment = '#'
+
+	var mappings []PRMapping
+	for first := true; ; first = false {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse mapping CSV: %w", err)
+		}
+		if first && strings.EqualFold(strings.TrimSpace(rec[0]), "squash") {
+			continue
+		}
+		// Report the physical line; comments and quoted newlines are not records.
+		line, _ := r.FieldPos(0)
+		if len(rec) < 3 || len(rec) > 4 {
+			return nil, fmt.Errorf("mapping CSV line %d: expected squash,head,base[,strategy]", line)
+		}
+		m := PRMapping{
+			Squash: strings.TrimSpace(rec[0]),
+			Head:   strings.TrimSpace(rec[1]),
+			Base:   strings.TrimSpace(rec[2]),
+		}
+		if len(rec) == 4 {
+			m.Strategy = strings.TrimSpace(rec[3])
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings, nil
+}
+
+func validatePRStrategy(s string) error {
+	if s != StrategyGitHub && s != StrategyGitLab {
+		return fmt.Errorf("unsupported strategy for import-pr: %s (expected %s or %s)", s, StrategyGitHub, StrategyGitLab)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package metadata
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePRMappings_JSON(t *testing.T) {
+	data := []byte(`[
+		{"squash": "s1", "head": "refs/pull/1/head", "base": "main"},
+		{"squash": "s2", "head": "refs/merge-requests/2/head", "base": "main", "strategy": "gitlab"}
+	]`)
+	got, err := ParsePRMappings(data, "")
+	if err != nil {
+		t.Fatalf("ParsePRMappings: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("len: got %d, want 2", len(got))
+	}
+	if got[0].Strategy != StrategyGitHub {
+		t.Errorf("default strategy: got %q", got[0].Strategy)
+	}
+	if got[1].Strategy != StrategyGitLab || got[1].Head != "refs/merge-requests/2/head" {
+		t.Errorf("mapping[1]: %+v", got[1])
+	}
+}
+
+func TestParsePRMappings_CSV(t *testing.T) {
+	data := []byte("squash,head,base,strategy\ns1, refs/pull/1/head, main\n# comment\ns2,refs/pull/2/head,release,gitlab\n")
+	got, err := ParsePRMappings(data, "")
+	if err != nil {
+		t.Fatalf("ParsePRMappings: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("len: got %d, want 2", len(got))
+	}
+	if got[0].Squash != "s1" || got[0].Head != "refs/pull/1/head" || got[0].Base != "main" || got[0].Strategy != StrategyGitHub {
+		t.Errorf("mapping[0]: %+v", got[0])
+	}
+	if got[1].Base != "release" || got[1].Strategy != StrategyGitLab {
+		t.Errorf("mapping[1]: %+v", got[1])
+	}
+}
+
+func TestParsePRMappings_DefaultStrategy(t *testing.T) {
+	got, err := ParsePRMappings([]byte("s1,h1,main\ns2,h2,main,github\n"), StrategyGitLab)
+	if err != nil {
+		t.Fatalf("ParsePRMappings: %v", err)
+	}
+	if got[0].Strategy != StrategyGitLab || got[1].Strategy != StrategyGitHub {
+		t.Errorf("strategies = %q, %q; want gitlab, github", got[0].Strategy, got[1].Strategy)
+	}
+}
+
+func TestParsePRMappings_CSVErrorLine(t *testing.T) {
+	_, err := ParsePRMappings([]byte("squash,head,base\n# comment\n\ns1,\"refs/pull/1/head\",main\ns2,h2\n"), "")
+	if err == nil || !strings.Contains(err.Error(), "line 5") {
+		t.Errorf("error = %v, want one naming line 5", err)
+	}
+}
+
+func TestParsePRMappings_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"missing base json", `[{"squash":"s","head":"h"}]`, "required"},
+		{"bad strategy", `[{"squash":"s","head":"h","base":"b","strategy":"bitbucket"}]`, "unsupported strategy"},
+		{"too few columns", "s1,h1\n", "expected squash,head,base"},
+		{"invalid json", `[{"squash":`, "JSON"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePRMappings([]byte(tt.data), "")
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not contain %q", err.Error(), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseImportPRFlags(t *testing.T) {
+	opts, err := ParseImportPRFlags([]string{"--squash=abc", "--head=refs/pull/7/head", "--base=main", "--strategy=gitlab"})
+	if err != nil {
+		t.Fatalf("ParseImportPRFlags: %v", err)
+	}
+	if len(opts.Mappings) != 1 || opts.Mappings[0].Strategy != StrategyGitLab || opts.Remote != "origin" {
+		t.Errorf("opts: %+v", opts)
+	}
+
+	if _, err := ParseImportPRFlags([]string{"--squash=abc"}); err == nil {
+		t.Error("expected error for missing --head/--base")
+	}
+	if _, err := ParseImportPRFlags([]string{"--file=m.csv", "--squash=abc"}); err == nil {
+		t.Error("expected error for --file combined with --squash")
+	}
+	opts, err = ParseImportPRFlags([]string{"--file=m.csv", "--strategy=gitlab"})
+	if err != nil || opts.File != "m.csv" || opts.Strategy != StrategyGitLab {
+		t.Errorf("--file opts = %+v, %v", opts, err)
+	}
+	if _, err := ParseImportPRFlags([]string{"abc", "--squash=abc", "--head=h", "--base=main"}); err == nil {
+		t.Error("expected error for a positional argument")
+	}
+}
@@ -93,3 +93,173 @@ func TestParse_ValidationErrors(t *testing.T) {
 	}
 }
 
+func TestParse_V2(t *testing.T) {
+	valid := []byte(`{
+		"spec": "squash-tree/v2",
+		"type": "squash",
+		"root": "abc123",
+		"base": "def456",
+		"children": [{"hash": "c1", "order": 1}],
+		"created_at": "2026-01-27T14:30:00Z",
+		"strategy": "github",
+		"author": {"name": "Jane", "email": "jane@example.com"},
+		"committer": {"name": "GitHub", "email": "noreply@github.com"},
+		"refs": [{"kind": "pr", "id": "42", "url": "https://example.com/pull/42"}],
+		"labels": {"team": "payments"},
+		"tool": {"name": "release-bot", "version": "1.2", "data": {"run": 7}}
+	}`)
+	meta, err := Parse(valid)
+	if err != nil {
+		t.Fatalf("Parse(v2): %v", err)
+	}
+	if meta.Spec != SpecVersionV2 {
+		t.Errorf("Spec: got %q", meta.Spec)
+	}
+	if meta.Author == nil || meta.Author.String() != "Jane <jane@example.com>" {
+		t.Errorf("Author: got %+v", meta.Author)
+	}
+	if len(meta.Refs) != 1 || meta.Refs[0].URL != "https://example.com/pull/42" {
+		t.Errorf("Refs: got %+v", meta.Refs)
+	}
+	if meta.Labels["team"] != "payments" {
+		t.Errorf("Labels: got %+v", meta.Labels)
+	}
+	if meta.Tool == nil || meta.Tool.Name != "release-bot" || string(meta.Tool.Data) != `{"run": 7}` {
+		t.Errorf("Tool: got %+v", meta.Tool)
+	}
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	meta2, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse(round-trip): %v", err)
+	}
+	if meta2.Committer.Email != "noreply@github.com" || meta2.Refs[0].ID != "42" {
+		t.Errorf("Round-trip: got %+v", meta2)
+	}
+}
+
+func TestParse_V1AuthorString(t *testing.T) {
+	meta, err := Parse([]byte(`{"spec":"squash-tree/v1","type":"squash","root":"r","base":"b","children":[{"hash":"c","order":1}],"created_at":"2026-01-01T00:00:00Z","author":"Jane <jane@example.com>"}`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if meta.Author == nil || meta.Author.Name != "Jane" || meta.Author.Email != "jane@example.com" {
+		t.Errorf("Author: got %+v", meta.Author)
+	}
+}
+
+func TestParse_V2ValidationErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want string
+	}{
+		{"v1 with refs", `{"spec":"squash-tree/v1","type":"squash","root":"r","base":"b","children":[{"hash":"c","order":1}],"created_at":"2026-01-01T00:00:00Z","refs":[{"kind":"pr","id":"1"}]}`, "require spec"},
+		{"ref without kind", `{"spec":"squash-tree/v2","type":"squash","root":"r","base":"b","children":[{"hash":"c","order":1}],"created_at":"2026-01-01T00:00:00Z","refs":[{"id":"1"}]}`, "missing kind"},
+		{"ref without target", `{"spec":"squash-tree/v2","type":"squash","root":"r","base":"b","children":[{"hash":"c","order":1}],"created_at":"2026-01-01T00:00:00Z","refs":[{"kind":"pr"}]}`, "id or url"},
+		{"tool without name", `{"spec":"squash-tree/v2","type":"squash","root":"r","base":"b","children":[{"hash":"c","order":1}],"created_at":"2026-01-01T00:00:00Z","tool":{"version":"1"}}`, "tool"},
+		{"v2 missing root", `{"spec":"squash-tree/v2","type":"squash","base":"b","children":[{"hash":"c","order":1}],"created_at":"2026-01-01T00:00:00Z"}`, "root"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.json))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not contain %q", err.Error(), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseIdentity(t *testing.T) {
+	tests := []struct {
+		in        string
+		name      string
+		email     string
+		wantError bool
+	}{
+		{"Jane Doe <jane@example.com>", "Jane Doe", "jane@example.com", false},
+		{"Jane", "Jane", "", false},
+		{"<bot@example.com>", "", "bot@example.com", false},
+		{"Jane <jane@example.com", "", "", true},
+		{"", "", "", true},
+	}
+	for _, tt := range tests {
+		id, err := ParseIdentity(tt.in)
+		if tt.wantError {
+			if err == nil {
+				t.Errorf("ParseIdentity(%q): expected error", tt.in)
+			}
+			continue
+		}
+		if err != nil || id.Name != tt.name || id.Email != tt.email {
+			t.Errorf("ParseIdentity(%q) = %+v, %v", tt.in, id, err)
+		}
+	}
+}
+
+func TestParseAddMetadataFlags_V2(t *testing.T) {
+	opts, err := ParseAddMetadataFlags([]string{
+		"--root=HEAD", "--base=main", "--children=a,b",
+		"--author=Jane <jane@example.com>",
+		"--pr=https://example.com/pull/9",
+		"--ref=ticket=PAY-12",
+		"--label=team=payments", "--label=risk=low",
+		"--tool=release-bot@1.2",
+	})
+	if err != nil {
+		t.Fatalf("ParseAddMetadataFlags: %v", err)
+	}
+	if !opts.HasV2Fields() {
+		t.Error("HasV2Fields: got false")
+	}
+	if opts.Author == nil || opts.Author.Email != "jane@example.com" {
+		t.Errorf("Author: %+v", opts.Author)
+	}
+	if len(opts.Refs) != 2 || opts.Refs[0].Kind != "ticket" || opts.Refs[0].ID != "PAY-12" || opts.Refs[1].Kind != RefKindPR || opts.Refs[1].URL == "" {
+		t.Errorf("Refs: %+v", opts.Refs)
+	}
+	if len(opts.Labels) != 2 || opts.Labels["risk"] != "low" {
+		t.Errorf("Labels: %+v", opts.Labels)
+	}
+	if opts.Tool == nil || opts.Tool.Name != "release-bot" || opts.Tool.Version != "1.2" {
+		t.Errorf("Tool: %+v", opts.Tool)
+	}
+
+	plain, err := ParseAddMetadataFlags([]string{"--root=HEAD", "--base=main", "--children=a"})
+	if err != nil {
+		t.Fatalf("ParseAddMetadataFlags: %v", err)
+	}
+	if plain.HasV2Fields() {
+		t.Error("HasV2Fields: got true without v2 flags")
+	}
+	if plain.Force {
+		t.Error("Force: got true without --force")
+	}
+	forced, err := ParseAddMetadataFlags([]string{"--force", "--root=HEAD", "--base=main", "--children=a"})
+	if err != nil || !forced.Force {
+		t.Errorf("--force: got %+v, %v", forced, err)
+	}
+
+	if _, err := ParseAddMetadataFlags([]string{"--root=HEAD", "--base=main", "--children=a", "--label=novalue"}); err == nil {
+		t.Error("expected error for malformed --label")
+	}
+}
+
+func TestParse_ChildAction(t *testing.T) {
+	meta, err := Parse([]byte(`{"spec":"squash-tree/v1.1","type":"squash","root":"r","base":"b","children":[{"hash":"c1","order":1,"action":"pick"},{"hash":"c2","order":2,"action":"fixup"}],"created_at":"2026-01-01T00:00:00Z","strategy":"rebase"}`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if meta.Children[0].Action != ActionPick || meta.Children[1].Action != ActionFixup {
+		t.Errorf("actions = %q, %q; want pick, fixup", meta.Children[0].Action, meta.Children[1].Action)
+	}
+	if len(meta.Warnings) != 0 {
+		t.Errorf("Warnings = %v", meta.Warnings)
+	}
+}
@@ -9,14 +9,49 @@ import (
 
 const (
 	SpecVersionV1 = "squash-tree/v1"
+	SpecVersionV2 = "squash-tree/v2"
 	TypeSquash    = "squash"
 )
 
 type AddMetadataInputs struct {
-	RootRef     string
-	BaseRef     string
+	RootRef      string
+	BaseRef      string
 	ChildrenRefs string // comma-separated refs
-	Strategy   string
+	Strategy     string
+	Force        bool // replace existing metadata instead of keeping it
+
+	// V2 or any v2 field being set makes add-metadata write squash-tree/v2.
+	V2 bool
+	V2Fields
+}
+
+// V2Fields are the squash-tree/v2 fields given when recording a squash; unset ones
+// keep the values taken from the squash commit.
+type V2Fields struct {
+	Author    *Identity
+	Committer *Identity
+	Refs      []Reference
+	Labels    map[string]string
+	Tool      *ToolInfo
+}
+
+func (f V2Fields) empty() bool {
+	return f.Author == nil && f.Committer == nil && len(f.Refs) == 0 && len(f.Labels) == 0 && f.Tool == nil
+}
+
+// HasV2Fields reports whether the inputs require a squash-tree/v2 note.
+func (in AddMetadataInputs) HasV2Fields() bool {
+	return in.V2 || !in.V2Fields.empty()
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
 }
 
 func ParseAddMetadataFlags(args []string) (AddMetadataInputs, error) {
@@ -25,24 +60,141 @@ func ParseAddMetadataFlags(args []string) (AddMetadataInputs, error) {
 	base := fs.String("base", "", "Base commit hash or ref")
 	children := fs.String("children", "", "Comma-separated child commit hashes (order preserved)")
 	strategy := fs.String("strategy", "auto", "Strategy: auto or manual")
+	v2 := fs.Bool("v2", false, "Write squash-tree/v2 metadata even without v2 fields")
+	force := fs.Bool("force", false, "Replace existing metadata on the root (the old note stays in the notes history)")
+	author := fs.String("author", "", "Squash author as \"Name <email>\" (v2)")
+	committer := fs.String("committer", "", "Squash committer as \"Name <email>\" (v2)")
+	pr := fs.String("pr", "", "Pull request URL or number (v2, shorthand for --ref=pr=<value>)")
+	tool := fs.String("tool", "", "Recording tool as name[@version] (v2)")
+	var refs, labels stringList
+	fs.Var(&refs, "ref", "Reference as kind=<url or id>, e.g. pr=https://... or ticket=ABC-1 (v2, repeatable)")
+	fs.Var(&labels, "label", "Label as key=value (v2, repeatable)")
 	if err := fs.Parse(args); err != nil {
 		return AddMetadataInputs{}, err
 	}
+	if fs.NArg() > 0 {
+		return AddMetadataInputs{}, fmt.Errorf("add-metadata: unexpected argument %q", fs.Arg(0))
+	}
 	if *root == "" || *base == "" || *children == "" {
 		return AddMetadataInputs{}, fmt.Errorf("add-metadata requires --root, --base, and --children")
 	}
-	return AddMetadataInputs{
+	in := AddMetadataInputs{
 		RootRef:      *root,
 		BaseRef:      *base,
 		ChildrenRefs: strings.TrimSpace(*children),
 		Strategy:     *strategy,
-	}, nil
+		Force:        *force,
+		V2:           *v2,
+	}
+	if *author != "" {
+		id, err := ParseIdentity(*author)
+		if err != nil {
+			return AddMetadataInputs{}, fmt.Errorf("--author: %w", err)
+		}
+		in.Author = &id
+	}
+	if *committer != "" {
+		id, err := ParseIdentity(*committer)
+		if err != nil {
+			return AddMetadataInputs{}, fmt.Errorf("--committer: %w", err)
+		}
+		in.Committer = &id
+	}
+	if *pr != "" {
+		refs = append(refs, RefKindPR+"="+*pr)
+	}
+	for _, r := range refs {
+		ref, err := ParseReference(r)
+		if err != nil {
+			return AddMetadataInputs{}, fmt.Errorf("--ref: %w", err)
+		}
+		in.Refs = append(in.Refs, ref)
+	}
+	for _, l := range labels {
+		k, v, ok := strings.Cut(l, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return AddMetadataInputs{}, fmt.Errorf("--label: expected key=value, got %q", l)
+		}
+		if in.Labels == nil {
+			in.Labels = make(map[string]string)
+		}
+		in.Labels[strings.TrimSpace(k)] = v
+	}
+	if *tool != "" {
+		name, version, _ := strings.Cut(*tool, "@")
+		in.Tool = &ToolInfo{Name: name, Version: version}
+	}
+	return in, nil
 }
 
 type ChildCommit struct {
 	Hash    string `json:"hash"`
 	Order   int    `json:"order"`
 	Message string `json:"message,omitempty"`
+	// Action is the rebase todo command that folded the child into the squash, when
+	// recorded from a rebase. The message of a fixup child is not part of the squash
+	// commit's message and survives only here.
+	Action string `json:"action,omitempty"`
+	// Parents are the child's parent commits, recorded for every child when the
+	// squashed commits include a merge. Parents that are children of the same squash
+	// give the branch topology; the others (the base, merged-in commits) lie outside it.
+	Parents []string `json:"parents,omitempty"`
+
+	// Extra holds fields unknown to this build; they are written back unchanged.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// NoBase is the base of a squash that contains a whole history from its root commit,
+// such as the one made by `git subtree add`.
+const NoBase = "0000000000000000000000000000000000000000"
+
+// Rebase actions recorded in ChildCommit.Action.
+const (
+	ActionPick   = "pick"
+	ActionReword = "reword"
+	ActionEdit   = "edit"
+	ActionSquash = "squash"
+	ActionFixup  = "fixup"
+)
+
+func validAction(a string) bool {
+	switch a {
+	case "", ActionPick, ActionReword, ActionEdit, ActionSquash, ActionFixup:
+		return true
+	}
+	return false
+}
+
+// Reference points from a squash to an external record such as a PR or ticket.
+type Reference struct {
+	Kind string `json:"kind"`
+	ID   string `json:"id,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
+
+const (
+	RefKindPR     = "pr"
+	RefKindTicket = "ticket"
+)
+
+// ParseReference parses kind=value; value is stored as URL when it looks like one, else as ID.
+func ParseReference(s string) (Reference, error) {
+	kind, value, ok := strings.Cut(s, "=")
+	kind, value = strings.TrimSpace(kind), strings.TrimSpace(value)
+	if !ok || kind == "" || value == "" {
+		return Reference{}, fmt.Errorf("expected kind=<url or id>, got %q", s)
+	}
+	if strings.Contains(value, "://") {
+		return Reference{Kind: kind, URL: value}, nil
+	}
+	return Reference{Kind: kind, ID: value}, nil
+}
+
+// ToolInfo identifies the tool that recorded a squash; Data is opaque to squash-tree.
+type ToolInfo struct {
+	Name    string          `json:"name"`
+	Version string          `json:"version,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
 }
 
 type SquashMetadata struct {
@@ -54,9 +206,42 @@ type SquashMetadata struct {
 	Children  []ChildCommit `json:"children"`
 	CreatedAt string        `json:"created_at"`
 	Strategy  string        `json:"strategy"`
+	Author    *Identity     `json:"author,omitempty"`
+	// RewrittenFrom is the root of the note this one was carried over from when the
+	// squash commit was amended or rebased.
+	RewrittenFrom string `json:"rewritten_from,omitempty"`
+	// DerivedFrom is the root of the note this one was copied from when the squash
+	// commit was cherry-picked, e.g. onto a release branch.
+	DerivedFrom string `json:"derived_from,omitempty"`
+
+	// v2 only.
+	Committer *Identity         `json:"committer,omitempty"`
+	Refs      []Reference       `json:"refs,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Tool      *ToolInfo         `json:"tool,omitempty"`
+
+	// Extra holds fields unknown to this build; they are written back unchanged.
+	Extra map[string]json.RawMessage `json:"-"`
+	// Warnings lists compatibility issues found by Parse (newer minor spec, unknown fields).
+	Warnings []string `json:"-"`
 }
 
+// Parse decodes a note and validates it against the rules of its spec version.
+// Notes with an unsupported major spec version fail with an error matching
+// ErrUnsupportedSpec; see compat.go for the versioning policy.
 func Parse(data []byte) (*SquashMetadata, error) {
+	var probe struct {
+		Spec string `json:"spec"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("failed to parse metadata JSON: %w", err)
+	}
+	if probe.Spec != "" {
+		if _, err := checkSpec(&SquashMetadata{Spec: probe.Spec}); err != nil {
+			return nil, err
+		}
+	}
+
 	var metadata SquashMetadata
 	if err := json.Unmarshal(data, &metadata); err != nil {
 		return nil, fmt.Errorf("failed to parse metadata JSON: %w", err)
@@ -65,6 +250,7 @@ func Parse(data []byte) (*SquashMetadata, error) {
 	if err := validate(&metadata); err != nil {
 		return nil, err
 	}
+	metadata.Warnings = append(metadata.Warnings, unknownWarnings(&metadata)...)
 
 	return &metadata, nil
 }
@@ -74,8 +260,24 @@ func validate(m *SquashMetadata) error {
 		return fmt.Errorf("metadata missing required field: spec")
 	}
 
-	if m.Spec != SpecVersionV1 {
-		return fmt.Errorf("unsupported spec version: %s (expected %s)", m.Spec, SpecVersionV1)
+	major, err := checkSpec(m)
+	if err != nil {
+		return err
+	}
+	for i, id := range []*Identity{m.Author, m.Committer} {
+		if role := []string{"author", "committer"}[i]; id != nil && id.unparsed {
+			m.Warnings = append(m.Warnings, fmt.Sprintf("%s %q is not \"Name <email>\"; kept as a name", role, id.Name))
+		}
+	}
+	switch major {
+	case 1:
+		if err := validateV1(m); err != nil {
+			return err
+		}
+	case 2:
+		if err := validateV2(m); err != nil {
+			return err
+		}
 	}
 
 	if m.Type == "" {
@@ -106,6 +308,14 @@ func validate(m *SquashMetadata) error {
 		if child.Order < 1 {
 			return fmt.Errorf("child commit at index %d has invalid order: %d (must be >= 1)", i, child.Order)
 		}
+		if !validAction(child.Action) {
+			m.Warnings = append(m.Warnings, fmt.Sprintf("child %s has unknown action %q", child.Hash, child.Action))
+		}
+		for _, p := range child.Parents {
+			if p == "" {
+				return fmt.Errorf("child commit at index %d has an empty parent", i)
+			}
+		}
 		if seenOrders[child.Order] {
 			return fmt.Errorf("duplicate order %d in children", child.Order)
 		}
@@ -114,3 +324,35 @@ func validate(m *SquashMetadata) error {
 
 	return nil
 }
+
+func validateV1(m *SquashMetadata) error {
+	if m.Committer != nil || len(m.Refs) > 0 || len(m.Labels) > 0 || m.Tool != nil {
+		return fmt.Errorf("committer, refs, labels and tool require spec %s", SpecVersionV2)
+	}
+	return nil
+}
+
+func validateV2(m *SquashMetadata) error {
+	for _, id := range []*Identity{m.Author, m.Committer} {
+		if id != nil && !id.unparsed && id.Name == "" && id.Email == "" {
+			return fmt.Errorf("identity must have a name or email")
+		}
+	}
+	for i, r := range m.Refs {
+		if r.Kind == "" {
+			return fmt.Errorf("ref at index %d missing kind", i)
+		}
+		if r.ID == "" && r.URL == "" {
+			return fmt.Errorf("ref at index %d must have an id or url", i)
+		}
+	}
+	for k := range m.Labels {
+		if strings.TrimSpace(k) == "" {
+			return fmt.Errorf("labels must not have empty keys")
+		}
+	}
+	if m.Tool != nil && m.Tool.Name == "" {
+		return fmt.Errorf("tool missing required field: name")
+	}
+	return nil
+}
@@ -1,11 +1,13 @@
 package repo
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+
+	"github.com/widefix/squash-tree/internal/backend"
 )
 
 func FindGitRepo(startPath string) (string, error) {
@@ -18,6 +20,10 @@ func FindGitRepo(startPath string) (string, error) {
 		if info, err := os.Stat(gitPath); err == nil && info.IsDir() {
 			return path, nil
 		}
+		// Linked worktrees and submodules have a .git file pointing at their git directory.
+		if data, err := os.ReadFile(gitPath); err == nil && strings.HasPrefix(string(data), "gitdir: ") {
+			return path, nil
+		}
 		parent := filepath.Dir(path)
 		if parent == path {
 			break
@@ -27,24 +33,18 @@ func FindGitRepo(startPath string) (string, error) {
 	return "", fmt.Errorf("not a git repository")
 }
 
-func ResolveCommitHash(repoPath, ref string) (string, error) {
-	cmd := exec.Command("git", "rev-parse", "--short", ref)
-	cmd.Dir = repoPath
-	output, err := cmd.Output()
-	if err != nil {
-		return "", fmt.Errorf("git rev-parse failed: %w", err)
-	}
-	return strings.TrimSpace(string(output)), nil
+func ResolveCommitHash(ctx context.Context, repoPath, ref string) (string, error) {
+	return backend.For(repoPath).ShortHash(ctx, ref)
 }
 
-func ResolveRefs(repoPath string, refs []string) ([]string, error) {
+func ResolveRefs(ctx context.Context, repoPath string, refs []string) ([]string, error) {
 	var hashes []string
 	for _, ref := range refs {
 		ref = strings.TrimSpace(ref)
 		if ref == "" {
 			continue
 		}
-		short, err := ResolveCommitHash(repoPath, ref)
+		short, err := ResolveCommitHash(ctx, repoPath, ref)
 		if err != nil {
 			return nil, fmt.Errorf("invalid ref %q: %w", ref, err)
 		}
@@ -55,3 +55,32 @@ func ResolveRefs(repoPath string, refs []string) ([]string, error) {
 	}
 	return hashes, nil
 }
+
+func MergeBase(ctx context.Context, repoPath, a, b string) (string, error) {
+	output, err := backend.Command(ctx, repoPath, "merge-base", a, b).Output()
+	if err != nil {
+		return "", fmt.Errorf("git merge-base %s %s failed: %w", a, b, err)
+	}
+	return ResolveCommitHash(ctx, repoPath, strings.TrimSpace(string(output)))
+}
+
+// RevList returns the short hashes of base..tip, oldest first.
+func RevList(ctx context.Context, repoPath, base, tip string) ([]string, error) {
+	output, err := backend.Command(ctx, repoPath, "rev-list", "--reverse", base+".."+tip).Output()
+	if err != nil {
+		return nil, fmt.Errorf("git rev-list %s..%s failed: %w", base, tip, err)
+	}
+	fulls := strings.Fields(string(output))
+	if len(fulls) == 0 {
+		return nil, nil
+	}
+	return ResolveRefs(ctx, repoPath, fulls)
+}
+
+// FetchRef fetches ref from remote into the same ref name locally, e.g. refs/pull/42/head.
+func FetchRef(ctx context.Context, repoPath, remote, ref string) error {
+	if out, err := backend.Command(ctx, repoPath, "fetch", "--no-tags", remote, "+"+ref+":"+ref).CombinedOutput(); err != nil {
+		return fmt.Errorf("git fetch %s %s: %w: %s", remote, ref, err, string(out))
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package repo
 
 import (
+	"context"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -37,6 +38,25 @@ func TestFindGitRepo_FromSubdir(t *testing.T) {
 	}
 }
 
+func TestFindGitRepo_LinkedWorktree(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squash-tree-repo-test-*")
+	if err != nil {
+		t.Fatalf("MkdirTemp: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.WriteFile(filepath.Join(dir, ".git"), []byte("gitdir: /elsewhere/.git/worktrees/wt\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	got, err := FindGitRepo(dir)
+	if err != nil {
+		t.Fatalf("FindGitRepo(worktree): %v", err)
+	}
+	if got != dir {
+		t.Errorf("FindGitRepo(worktree) = %q, want %q", got, dir)
+	}
+}
+
 func TestFindGitRepo_NotFound(t *testing.T) {
 	dir, err := os.MkdirTemp("", "squash-tree-repo-test-*")
 	if err != nil {
@@ -58,7 +78,7 @@ func TestResolveCommitHash(t *testing.T) {
 	dir, cleanup := initTempRepoWithCommit(t)
 	defer cleanup()
 
-	hash, err := ResolveCommitHash(dir, "HEAD")
+	hash, err := ResolveCommitHash(context.Background(), dir, "HEAD")
 	if err != nil {
 		t.Fatalf("ResolveCommitHash: %v", err)
 	}
@@ -74,12 +94,59 @@ func TestResolveCommitHash_InvalidRef(t *testing.T) {
 	dir, cleanup := initTempRepoWithCommit(t)
 	defer cleanup()
 
-	_, err := ResolveCommitHash(dir, "nonexistent-ref-xyz")
+	_, err := ResolveCommitHash(context.Background(), dir, "nonexistent-ref-xyz")
 	if err == nil {
 		t.Fatal("ResolveCommitHash: expected error for invalid ref")
 	}
 }
 
+func TestMergeBaseAndRevList(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available:", err)
+	}
+	dir, cleanup := initTempRepoWithCommit(t)
+	defer cleanup()
+
+	run := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v %s", args, err, out)
+		}
+	}
+	run("branch", "feature")
+	run("checkout", "-q", "feature")
+	run("commit", "-q", "--allow-empty", "-m", "f1")
+	run("commit", "-q", "--allow-empty", "-m", "f2")
+	run("checkout", "-q", "-")
+	run("commit", "-q", "--allow-empty", "-m", "main work")
+
+	fork, _ := ResolveCommitHash(context.Background(), dir, "feature~2")
+	base, err := MergeBase(context.Background(), dir, "HEAD", "feature")
+	if err != nil {
+		t.Fatalf("MergeBase: %v", err)
+	}
+	if base != fork {
+		t.Errorf("MergeBase = %q, want %q", base, fork)
+	}
+
+	commits, err := RevList(context.Background(), dir, base, "feature")
+	if err != nil {
+		t.Fatalf("RevList: %v", err)
+	}
+	f1, _ := ResolveCommitHash(context.Background(), dir, "feature~1")
+	f2, _ := ResolveCommitHash(context.Background(), dir, "feature")
+	if len(commits) != 2 || commits[0] != f1 || commits[1] != f2 {
+		t.Errorf("RevList = %v, want [%s %s]", commits, f1, f2)
+	}
+
+	empty, err := RevList(context.Background(), dir, "feature", "feature")
+	if err != nil || len(empty) != 0 {
+		t.Errorf("RevList(empty range) = %v, %v", empty, err)
+	}
+}
+
 func createTempDirWithGit(t *testing.T) (string, func()) {
 	t.Helper()
 	dir, err := os.MkdirTemp("", "squash-tree-repo-test-*")
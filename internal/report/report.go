@@ -0,0 +1,164 @@
+// Package report writes a self-contained static HTML site for squash trees.
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/widefix/squash-tree/internal/tree"
+)
+
+// Report describes what to write. DiffStat is optional; when nil no diffstats are shown.
+type Report struct {
+	Title       string
+	Roots       []*tree.Node
+	DiffStat    func(hash string) (string, error)
+	GeneratedAt time.Time
+}
+
+type indexPage struct {
+	Title       string
+	GeneratedAt string
+	Roots       []*tree.Node
+}
+
+type squashPage struct {
+	Title     string
+	Node      *tree.Node
+	Children  []childRow
+	Parents   []string // squashes (from any tree in the report) that contain this commit
+	TreeNode  *tree.Node
+	HasPage   map[string]bool
+	RootIndex string
+}
+
+type childRow struct {
+	Order    int
+	Node     *tree.Node
+	DiffStat string
+	SharedBy []string // other squashes that also contain this child
+}
+
+// PageName returns the file name of the page for a squash commit.
+func PageName(hash string) string {
+	return hash + ".html"
+}
+
+// Write renders the index and one page per squash reachable from r.Roots into dir.
+func Write(dir string, r Report) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("create report dir: %w", err)
+	}
+	if r.Title == "" {
+		r.Title = "Squash Tree Report"
+	}
+
+	squashes := make(map[string]*tree.Node)
+	parents := make(map[string]map[string]bool)
+	var order []string
+	seen := make(map[*tree.Node]bool)
+	var walk func(n *tree.Node)
+	walk = func(n *tree.Node) {
+		if seen[n] {
+			return
+		}
+		seen[n] = true
+		if n.IsSquash() {
+			if _, ok := squashes[n.Hash]; !ok {
+				order = append(order, n.Hash)
+			}
+			squashes[n.Hash] = n
+		}
+		for _, c := range n.Children {
+			if parents[c.Hash] == nil {
+				parents[c.Hash] = make(map[string]bool)
+			}
+			parents[c.Hash][n.Hash] = true
+			walk(c)
+		}
+	}
+	for _, root := range r.Roots {
+		walk(root)
+	}
+	hasPage := make(map[string]bool, len(squashes))
+	for h := range squashes {
+		hasPage[h] = true
+	}
+
+	if err := writePage(filepath.Join(dir, "index.html"), "index", indexPage{
+		Title:       r.Title,
+		GeneratedAt: r.GeneratedAt.UTC().Format(time.RFC3339),
+		Roots:       r.Roots,
+	}); err != nil {
+		return err
+	}
+
+	for _, h := range order {
+		n := squashes[h]
+		page := squashPage{
+			Title:     r.Title,
+			Node:      n,
+			Parents:   sortedSet(parents[h], ""),
+			TreeNode:  n,
+			HasPage:   hasPage,
+			RootIndex: "index.html",
+		}
+		for i, c := range n.Children {
+			row := childRow{Order: i + 1, Node: c, SharedBy: sortedSet(parents[c.Hash], h)}
+			if r.DiffStat != nil && !c.IsMissing() {
+				if stat, err := r.DiffStat(c.Hash); err == nil {
+					row.DiffStat = stat
+				}
+			}
+			page.Children = append(page.Children, row)
+		}
+		if err := writePage(filepath.Join(dir, PageName(h)), "squash", page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedSet(set map[string]bool, exclude string) []string {
+	var out []string
+	for k := range set {
+		if k != exclude {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func writePage(path, name string, data interface{}) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", path, err)
+	}
+	defer f.Close()
+	if err := templates.ExecuteTemplate(f, name, data); err != nil {
+		return fmt.Errorf("render %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+var templates = template.Must(template.New("report").Funcs(template.FuncMap{
+	"page":  PageName,
+	"upper": strings.ToUpper,
+	"seen":  func() map[*tree.Node]bool { return make(map[*tree.Node]bool) },
+	"first": func(seen map[*tree.Node]bool, n *tree.Node) bool {
+		if seen[n] {
+			return false
+		}
+		seen[n] = true
+		return true
+	},
+	"ctx": func(n *tree.Node, hasPage map[string]bool, seen map[*tree.Node]bool) map[string]interface{} {
+		return map[string]interface{}{"Node": n, "HasPage": hasPage, "Seen": seen}
+	},
+}).Parse(pageTemplates))
@@ -0,0 +1,81 @@
+package report
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/widefix/squash-tree/internal/metadata"
+	"github.com/widefix/squash-tree/internal/tree"
+)
+
+func TestWrite_IndexAndPages(t *testing.T) {
+	fix := &tree.Node{Hash: "fix", Type: tree.NodeTypeLeaf, Message: "Fix <b>overflow</b>"}
+	a1 := &tree.Node{Hash: "a1", Type: tree.NodeTypeLeaf, Message: "Start A"}
+	gone := &tree.Node{Hash: "gone", Type: tree.NodeTypeMissing, Message: "Lost"}
+	featA := &tree.Node{Hash: "featA", Type: tree.NodeTypeSquash, Message: "Feature A", Children: []*tree.Node{a1, fix},
+		Metadata: &metadata.SquashMetadata{Spec: metadata.SpecVersionV2, Base: "base", Strategy: "github",
+			Refs: []metadata.Reference{{Kind: "pr", ID: "42", URL: "https://example.com/pull/42"}}}}
+	featB := &tree.Node{Hash: "featB", Type: tree.NodeTypeSquash, Message: "Feature B", Children: []*tree.Node{fix, gone},
+		Metadata: &metadata.SquashMetadata{Spec: metadata.SpecVersionV1, Base: "base", Strategy: "rebase"}}
+	release := &tree.Node{Hash: "release", Type: tree.NodeTypeSquash, Message: "Release", Children: []*tree.Node{featA, featB},
+		Metadata: &metadata.SquashMetadata{Spec: metadata.SpecVersionV1, Base: "base", Strategy: "manual"}}
+
+	dir := t.TempDir()
+	err := Write(dir, Report{
+		Roots:       []*tree.Node{release},
+		DiffStat:    func(hash string) (string, error) { return " f.txt | 1 +  (" + hash + ")", nil },
+		GeneratedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	for _, name := range []string{"index.html", "release.html", "featA.html", "featB.html"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "fix.html")); err == nil {
+		t.Error("leaf should not get a page")
+	}
+
+	index := read(t, dir, "index.html")
+	if !strings.Contains(index, `<a href="release.html">release</a>`) || !strings.Contains(index, "2026-01-02T03:04:05Z") {
+		t.Errorf("index.html: %s", index)
+	}
+
+	a := read(t, dir, "featA.html")
+	for _, want := range []string{
+		"Contained in: <a class=\"hash\" href=\"release.html\">release</a>",
+		`also in <a class="hash" href="featB.html">featB</a>`,
+		"Fix &lt;b&gt;overflow&lt;/b&gt;",
+		"f.txt | 1 &#43;  (fix)",
+		`<a href="https://example.com/pull/42">42</a>`,
+	} {
+		if !strings.Contains(a, want) {
+			t.Errorf("featA.html missing %q", want)
+		}
+	}
+
+	b := read(t, dir, "featB.html")
+	if strings.Contains(b, "(gone)") {
+		t.Error("diffstat requested for missing commit")
+	}
+
+	r := read(t, dir, "release.html")
+	if strings.Count(r, "<details open>") != 3 || !strings.Contains(r, "(see above)") {
+		t.Errorf("release.html tree: %s", r)
+	}
+}
+
+func read(t *testing.T, dir, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	return string(data)
+}
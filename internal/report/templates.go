@@ -0,0 +1,84 @@
+package report
+
+const pageTemplates = `
+{{define "head"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em auto; max-width: 70em; color: #222; }
+code, pre, .hash { font-family: ui-monospace, Menlo, Consolas, monospace; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; vertical-align: top; padding: .3em .6em; border-bottom: 1px solid #ddd; }
+pre { margin: 0; font-size: .85em; white-space: pre-wrap; }
+ul.tree { list-style: none; padding-left: 1.2em; }
+ul.tree summary { cursor: pointer; }
+.type { font-size: .75em; padding: 0 .3em; border-radius: 3px; background: #eee; }
+.type-squash { background: #dbeafe; }
+.type-missing, .type-invalid, .type-unreadable { background: #fee2e2; }
+.note { color: #666; font-size: .9em; }
+</style>
+</head>
+<body>
+{{end}}
+
+{{define "hashlink"}}<span class="hash">{{if and .Node.IsSquash (index .HasPage .Node.Hash)}}<a href="{{page .Node.Hash}}">{{.Node.Hash}}</a>{{else}}{{.Node.Hash}}{{end}}</span> <span class="type type-{{.Node.Type}}">{{upper .Node.Type.String}}</span>{{end}}
+
+{{define "label"}}{{template "hashlink" .}} {{.Node.Message}}{{end}}
+
+{{define "node"}}<li>{{if first .Seen .Node}}{{if .Node.Children}}<details open><summary>{{template "label" .}}</summary>
+<ul class="tree">{{$ctx := .}}{{range .Node.Children}}{{template "node" (ctx . $ctx.HasPage $ctx.Seen)}}{{end}}</ul>
+</details>{{else}}{{template "label" .}}{{if .Node.Unexpanded}} <span class="note">(not expanded)</span>{{end}}{{end}}{{else}}{{template "label" .}} <span class="note">(see above)</span>{{end}}</li>
+{{end}}
+
+{{define "index"}}{{template "head" .Title}}
+<h1>{{.Title}}</h1>
+<p class="note">Generated {{.GeneratedAt}} from refs/notes/squash-tree.</p>
+<table>
+<tr><th>Squash</th><th>Message</th><th>Children</th><th>Strategy</th><th>Recorded</th></tr>
+{{range .Roots}}<tr>
+<td class="hash">{{if .IsSquash}}<a href="{{page .Hash}}">{{.Hash}}</a>{{else}}{{.Hash}}{{end}}</td>
+<td>{{.Message}}</td>
+<td>{{len .Children}}</td>
+<td>{{if .Metadata}}{{.Metadata.Strategy}}{{end}}</td>
+<td>{{if .Metadata}}{{.Metadata.CreatedAt}}{{end}}</td>
+</tr>
+{{else}}<tr><td colspan="5">No squash metadata found.</td></tr>
+{{end}}</table>
+</body>
+</html>
+{{end}}
+
+{{define "squash"}}{{template "head" .Node.Hash}}
+<p><a href="{{.RootIndex}}">&larr; {{.Title}}</a></p>
+<h1><span class="hash">{{.Node.Hash}}</span> {{.Node.Message}}</h1>
+{{with .Node.Metadata}}<table>
+<tr><th>Spec</th><td>{{.Spec}}</td></tr>
+<tr><th>Base</th><td class="hash">{{.Base}}</td></tr>
+<tr><th>Strategy</th><td>{{.Strategy}}</td></tr>
+<tr><th>Recorded</th><td>{{.CreatedAt}}</td></tr>
+{{with .Author}}<tr><th>Author</th><td>{{.String}}</td></tr>{{end}}
+{{with .Committer}}<tr><th>Committer</th><td>{{.String}}</td></tr>{{end}}
+{{range .Refs}}<tr><th>{{.Kind}}</th><td>{{if .URL}}<a href="{{.URL}}">{{if .ID}}{{.ID}}{{else}}{{.URL}}{{end}}</a>{{else}}{{.ID}}{{end}}</td></tr>{{end}}
+{{range $k, $v := .Labels}}<tr><th>{{$k}}</th><td>{{$v}}</td></tr>{{end}}
+</table>{{end}}
+{{if .Parents}}<p>Contained in: {{range .Parents}}<a class="hash" href="{{page .}}">{{.}}</a> {{end}}</p>{{end}}
+
+<h2>Children</h2>
+<table>
+<tr><th>#</th><th>Commit</th><th>Message</th><th>Diffstat</th></tr>
+{{range .Children}}<tr>
+<td>{{.Order}}</td>
+<td>{{template "hashlink" (ctx .Node $.HasPage nil)}}{{if .SharedBy}}<br><span class="note">also in {{range .SharedBy}}<a class="hash" href="{{page .}}">{{.}}</a> {{end}}</span>{{end}}</td>
+<td>{{.Node.Message}}</td>
+<td>{{if .DiffStat}}<pre>{{.DiffStat}}</pre>{{end}}</td>
+</tr>
+{{end}}</table>
+
+<h2>Tree</h2>
+<ul class="tree">{{template "node" (ctx .TreeNode .HasPage seen)}}</ul>
+</body>
+</html>
+{{end}}
+`
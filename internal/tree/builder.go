@@ -1,23 +1,39 @@
 package tree
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sort"
 
-	"squash-tree/internal/metadata"
+	"github.com/widefix/squash-tree/internal/metadata"
 )
 
 // NotesSource provides squash metadata and commit existence for building the tree.
 // *git.NotesReader implements this interface.
 type NotesSource interface {
-	HasMetadata(commitHash string) bool
-	ReadMetadata(commitHash string) (*metadata.SquashMetadata, error)
-	CommitExists(commitHash string) bool
+	HasMetadata(ctx context.Context, commitHash string) bool
+	ReadMetadata(ctx context.Context, commitHash string) (*metadata.SquashMetadata, error)
+	CommitExists(ctx context.Context, commitHash string) bool
+}
+
+// Options controls how BuildTree deals with damaged history and how far it expands.
+type Options struct {
+	// Strict fails the whole tree on a missing child commit or an unreadable note,
+	// instead of marking the affected node and continuing.
+	Strict bool
+	// MaxDepth limits expansion: squashes MaxDepth levels below the root are returned
+	// as unexpanded stubs (their notes are not read). Zero means unlimited.
+	MaxDepth int
+	// Lazy expands a single level at a time (MaxDepth 1 unless MaxDepth is set);
+	// callers expand stubs on demand with Expand.
+	Lazy bool
 }
 
 type Builder struct {
 	notesReader NotesSource
 	visited     map[string]*Node
+	opts        Options
 }
 
 func NewBuilder(notesReader NotesSource) *Builder {
@@ -27,9 +43,25 @@ func NewBuilder(notesReader NotesSource) *Builder {
 	}
 }
 
-func (b *Builder) BuildTree(commitHash string) (*Node, error) {
+// WithOptions sets the options used by subsequent BuildTree and Expand calls.
+func (b *Builder) WithOptions(opts Options) *Builder {
+	b.opts = opts
+	return b
+}
+
+// BuildTree resolves the squash tree rooted at commitHash. Unless Options.Strict is set,
+// missing children and bad notes become NodeTypeMissing / NodeTypeInvalid nodes;
+// a missing root commit is always an error, and so is ctx being done: a cancelled build
+// never returns a partial tree.
+func (b *Builder) BuildTree(ctx context.Context, commitHash string) (*Node, error) {
 	b.visited = make(map[string]*Node)
-	node, err := b.buildNode(commitHash)
+	if !b.notesReader.CommitExists(ctx, commitHash) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("commit %s does not exist", commitHash)
+	}
+	node, err := b.buildNode(ctx, commitHash, 0)
 	if err != nil {
 		return nil, err
 	}
@@ -37,14 +69,59 @@ func (b *Builder) BuildTree(commitHash string) (*Node, error) {
 	return node, nil
 }
 
-func (b *Builder) buildNode(commitHash string) (*Node, error) {
+// Expand expands an unexpanded squash stub returned by an earlier BuildTree or Expand
+// on this builder, up to the configured depth below node. Shared commits keep
+// resolving to the same *Node. Expanding an already expanded node is a no-op.
+func (b *Builder) Expand(ctx context.Context, node *Node) error {
+	if node == nil || !node.Unexpanded {
+		return nil
+	}
+	if err := b.expand(ctx, node, 0); err != nil {
+		return err
+	}
+	b.clearVisitedFlags(node)
+	return nil
+}
+
+func (b *Builder) maxDepth() int {
+	if b.opts.MaxDepth == 0 && b.opts.Lazy {
+		return 1
+	}
+	return b.opts.MaxDepth
+}
+
+func (b *Builder) canExpand(depth int) bool {
+	max := b.maxDepth()
+	return max == 0 || depth < max
+}
+
+func (b *Builder) buildNode(ctx context.Context, commitHash string, depth int) (*Node, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if cached, exists := b.visited[commitHash]; exists {
+		if cached.Unexpanded && b.canExpand(depth) {
+			if err := b.expand(ctx, cached, depth); err != nil {
+				return nil, err
+			}
+		}
 		return cached, nil
 	}
-	if !b.notesReader.CommitExists(commitHash) {
-		return nil, fmt.Errorf("commit %s does not exist", commitHash)
+	if !b.notesReader.CommitExists(ctx, commitHash) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if b.opts.Strict {
+			return nil, fmt.Errorf("commit %s does not exist", commitHash)
+		}
+		node := &Node{Hash: commitHash, Type: NodeTypeMissing, Children: []*Node{}}
+		b.visited[commitHash] = node
+		return node, nil
+	}
+	hasMetadata := b.notesReader.HasMetadata(ctx, commitHash)
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
-	hasMetadata := b.notesReader.HasMetadata(commitHash)
 
 	node := &Node{
 		Hash:     commitHash,
@@ -55,31 +132,12 @@ func (b *Builder) buildNode(commitHash string) (*Node, error) {
 
 	if hasMetadata {
 		node.Type = NodeTypeSquash
-		meta, err := b.notesReader.ReadMetadata(commitHash)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read metadata for %s: %w", commitHash, err)
+		if !b.canExpand(depth) {
+			node.Unexpanded = true
+			return node, nil
 		}
-		node.Metadata = meta
-		node.Message = meta.Message
-
-		children := make([]metadata.ChildCommit, len(meta.Children))
-		copy(children, meta.Children)
-		sort.Slice(children, func(i, j int) bool {
-			return children[i].Order < children[j].Order
-		})
-
-		for _, childCommit := range children {
-			childNode, err := b.buildNode(childCommit.Hash)
-			if err != nil {
-				return nil, fmt.Errorf("failed to build child node %s: %w", childCommit.Hash, err)
-			}
-			if b.hasCycle(childNode, commitHash) {
-				return nil, fmt.Errorf("cycle detected: commit %s is part of a cycle", childCommit.Hash)
-			}
-			if childCommit.Message != "" && childNode.Message == "" {
-				childNode.Message = childCommit.Message
-			}
-			node.Children = append(node.Children, childNode)
+		if err := b.expand(ctx, node, depth); err != nil {
+			return nil, err
 		}
 	} else {
 		node.Type = NodeTypeLeaf
@@ -88,6 +146,54 @@ func (b *Builder) buildNode(commitHash string) (*Node, error) {
 	return node, nil
 }
 
+// expand reads the note of squash node (at depth below the build root) and builds its children.
+func (b *Builder) expand(ctx context.Context, node *Node, depth int) error {
+	commitHash := node.Hash
+	meta, err := b.notesReader.ReadMetadata(ctx, commitHash)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	node.Unexpanded = false
+	if err != nil && !b.opts.Strict {
+		node.Type = NodeTypeInvalid
+		if errors.Is(err, metadata.ErrUnsupportedSpec) {
+			node.Type = NodeTypeUnreadable
+		}
+		node.Err = err
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read metadata for %s: %w", commitHash, err)
+	}
+	node.Metadata = meta
+	node.Message = meta.Message
+
+	children := make([]metadata.ChildCommit, len(meta.Children))
+	copy(children, meta.Children)
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].Order < children[j].Order
+	})
+
+	for _, childCommit := range children {
+		childNode, err := b.buildNode(ctx, childCommit.Hash, depth+1)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return fmt.Errorf("failed to build child node %s: %w", childCommit.Hash, err)
+		}
+		if b.hasCycle(childNode, commitHash) {
+			return fmt.Errorf("cycle detected: commit %s is part of a cycle", childCommit.Hash)
+		}
+		if childCommit.Message != "" && childNode.Message == "" {
+			childNode.Message = childCommit.Message
+		}
+		node.Children = append(node.Children, childNode)
+		childNode.Parents = append(childNode.Parents, node)
+	}
+	return nil
+}
+
 func (b *Builder) hasCycle(node *Node, targetHash string) bool {
 	if node.Hash == targetHash {
 		return true
@@ -1,10 +1,13 @@
 package tree
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"strings"
 	"testing"
 
-	"squash-tree/internal/metadata"
+	"github.com/widefix/squash-tree/internal/metadata"
 )
 
 // mockNotesSource is a test double for NotesSource.
@@ -12,6 +15,7 @@ type mockNotesSource struct {
 	commits   map[string]bool
 	metadata  map[string]*metadata.SquashMetadata
 	hasMeta   map[string]bool
+	readErrs  map[string]error
 }
 
 func newMockNotesSource() *mockNotesSource {
@@ -19,6 +23,7 @@ func newMockNotesSource() *mockNotesSource {
 		commits:  make(map[string]bool),
 		metadata: make(map[string]*metadata.SquashMetadata),
 		hasMeta:  make(map[string]bool),
+		readErrs: make(map[string]error),
 	}
 }
 
@@ -42,15 +47,18 @@ func (m *mockNotesSource) addSquash(root, base string, childHashes []string) {
 	}
 }
 
-func (m *mockNotesSource) CommitExists(commitHash string) bool {
+func (m *mockNotesSource) CommitExists(ctx context.Context, commitHash string) bool {
 	return m.commits[commitHash]
 }
 
-func (m *mockNotesSource) HasMetadata(commitHash string) bool {
+func (m *mockNotesSource) HasMetadata(ctx context.Context, commitHash string) bool {
 	return m.hasMeta[commitHash]
 }
 
-func (m *mockNotesSource) ReadMetadata(commitHash string) (*metadata.SquashMetadata, error) {
+func (m *mockNotesSource) ReadMetadata(ctx context.Context, commitHash string) (*metadata.SquashMetadata, error) {
+	if err, ok := m.readErrs[commitHash]; ok {
+		return nil, err
+	}
 	meta, ok := m.metadata[commitHash]
 	if !ok {
 		return nil, nil
@@ -63,7 +71,7 @@ func TestBuilder_LeafOnly(t *testing.T) {
 	mock.addCommit("abc")
 	b := NewBuilder(mock)
 
-	node, err := b.BuildTree("abc")
+	node, err := b.BuildTree(context.Background(), "abc")
 	if err != nil {
 		t.Fatalf("BuildTree: %v", err)
 	}
@@ -86,7 +94,7 @@ func TestBuilder_SingleSquashWithChildren(t *testing.T) {
 	mock.addSquash("root", "base", []string{"c1", "c2"})
 	b := NewBuilder(mock)
 
-	node, err := b.BuildTree("root")
+	node, err := b.BuildTree(context.Background(), "root")
 	if err != nil {
 		t.Fatalf("BuildTree: %v", err)
 	}
@@ -112,7 +120,7 @@ func TestBuilder_NestedSquash(t *testing.T) {
 	mock.addSquash("root", "base", []string{"inner"})
 	b := NewBuilder(mock)
 
-	node, err := b.BuildTree("root")
+	node, err := b.BuildTree(context.Background(), "root")
 	if err != nil {
 		t.Fatalf("BuildTree: %v", err)
 	}
@@ -133,7 +141,7 @@ func TestBuilder_MissingCommit(t *testing.T) {
 	mock.addCommit("exists")
 	b := NewBuilder(mock)
 
-	_, err := b.BuildTree("nonexistent")
+	_, err := b.BuildTree(context.Background(), "nonexistent")
 	if err == nil {
 		t.Fatal("BuildTree: expected error for missing commit")
 	}
@@ -150,7 +158,7 @@ func TestBuilder_CycleDetection(t *testing.T) {
 	mock.addSquash("mid", "base", []string{"root"})
 	b := NewBuilder(mock)
 
-	_, err := b.BuildTree("root")
+	_, err := b.BuildTree(context.Background(), "root")
 	if err == nil {
 		t.Fatal("BuildTree: expected error for cycle")
 	}
@@ -158,3 +166,242 @@ func TestBuilder_CycleDetection(t *testing.T) {
 		t.Errorf("error %q", err.Error())
 	}
 }
+
+func TestBuilder_UnsupportedSpecBecomesUnreadableNode(t *testing.T) {
+	mock := newMockNotesSource()
+	mock.addCommit("base")
+	mock.addCommit("c1")
+	mock.addCommit("future")
+	mock.hasMeta["future"] = true
+	mock.readErrs["future"] = fmt.Errorf("failed to parse metadata: %w", &metadata.UnsupportedSpecError{Spec: "squash-tree/v3"})
+	mock.addSquash("root", "base", []string{"c1", "future"})
+	b := NewBuilder(mock)
+
+	node, err := b.BuildTree(context.Background(), "root")
+	if err != nil {
+		t.Fatalf("BuildTree: %v", err)
+	}
+	future := node.Children[1]
+	if !future.IsUnreadable() || future.Err == nil {
+		t.Fatalf("future: Type=%v Err=%v", future.Type, future.Err)
+	}
+
+	out := NewVisualizer().Visualize(node)
+	if !strings.Contains(out, "future [UNREADABLE SQUASH]") || !strings.Contains(out, "squash-tree/v3") {
+		t.Errorf("output: %q", out)
+	}
+}
+
+func TestBuilder_InvalidNoteBecomesInvalidNode(t *testing.T) {
+	mock := newMockNotesSource()
+	mock.addCommit("root")
+	mock.hasMeta["root"] = true
+	mock.readErrs["root"] = errors.New("metadata missing required field: root")
+
+	node, err := NewBuilder(mock).BuildTree(context.Background(), "root")
+	if err != nil {
+		t.Fatalf("BuildTree: %v", err)
+	}
+	if !node.IsInvalid() || node.Err == nil {
+		t.Fatalf("root: Type=%v Err=%v", node.Type, node.Err)
+	}
+	out := NewVisualizer().Visualize(node)
+	if !strings.Contains(out, "[INVALID SQUASH]") || !strings.Contains(out, "missing required field") {
+		t.Errorf("output: %q", out)
+	}
+
+	_, err = NewBuilder(mock).WithOptions(Options{Strict: true}).BuildTree(context.Background(), "root")
+	if err == nil {
+		t.Fatal("BuildTree(strict): expected error")
+	}
+}
+
+func TestBuilder_MissingChildBecomesMissingNode(t *testing.T) {
+	mock := newMockNotesSource()
+	mock.addCommit("base")
+	mock.addCommit("c1")
+	mock.addSquash("root", "base", []string{"c1", "gone"})
+	mock.metadata["root"].Children[1].Message = "Fix flaky test"
+
+	node, err := NewBuilder(mock).BuildTree(context.Background(), "root")
+	if err != nil {
+		t.Fatalf("BuildTree: %v", err)
+	}
+	if len(node.Children) != 2 {
+		t.Fatalf("Children: got %d", len(node.Children))
+	}
+	gone := node.Children[1]
+	if !gone.IsMissing() || gone.Message != "Fix flaky test" {
+		t.Fatalf("gone: Type=%v Message=%q", gone.Type, gone.Message)
+	}
+	out := NewVisualizer().Visualize(node)
+	if !strings.Contains(out, "gone [MISSING]") || !strings.Contains(out, "Fix flaky test") {
+		t.Errorf("output: %q", out)
+	}
+
+	_, err = NewBuilder(mock).WithOptions(Options{Strict: true}).BuildTree(context.Background(), "root")
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Fatalf("BuildTree(strict): got %v", err)
+	}
+}
+
+func TestBuilder_SharedChildHasBothParents(t *testing.T) {
+	mock := newMockNotesSource()
+	mock.addCommit("base")
+	mock.addCommit("fix")
+	mock.addCommit("a1")
+	mock.addSquash("featA", "base", []string{"a1", "fix"})
+	mock.addSquash("featB", "base", []string{"fix"})
+	mock.addSquash("release", "base", []string{"featA", "featB"})
+
+	node, err := NewBuilder(mock).BuildTree(context.Background(), "release")
+	if err != nil {
+		t.Fatalf("BuildTree: %v", err)
+	}
+	fromA := node.Children[0].Children[1]
+	fromB := node.Children[1].Children[0]
+	if fromA != fromB {
+		t.Fatal("shared child should be a single node")
+	}
+	if !fromA.IsShared() || len(fromA.Parents) != 2 {
+		t.Fatalf("Parents: got %d", len(fromA.Parents))
+	}
+	if fromA.Parents[0].Hash != "featA" || fromA.Parents[1].Hash != "featB" {
+		t.Errorf("Parents: %q %q", fromA.Parents[0].Hash, fromA.Parents[1].Hash)
+	}
+	if node.IsShared() || len(node.Parents) != 0 {
+		t.Errorf("root Parents: got %d", len(node.Parents))
+	}
+}
+
+// countingNotesSource records which notes were read.
+type countingNotesSource struct {
+	*mockNotesSource
+	reads map[string]int
+}
+
+func (c *countingNotesSource) ReadMetadata(ctx context.Context, commitHash string) (*metadata.SquashMetadata, error) {
+	c.reads[commitHash]++
+	return c.mockNotesSource.ReadMetadata(ctx, commitHash)
+}
+
+func TestBuilder_MaxDepthReturnsStubs(t *testing.T) {
+	mock := newMockNotesSource()
+	mock.addCommit("base")
+	mock.addCommit("leaf")
+	mock.addSquash("l2", "base", []string{"leaf"})
+	mock.addSquash("l1", "base", []string{"l2"})
+	mock.addSquash("root", "base", []string{"l1"})
+	src := &countingNotesSource{mockNotesSource: mock, reads: make(map[string]int)}
+
+	b := NewBuilder(src).WithOptions(Options{MaxDepth: 1})
+	node, err := b.BuildTree(context.Background(), "root")
+	if err != nil {
+		t.Fatalf("BuildTree: %v", err)
+	}
+	l1 := node.Children[0]
+	if !l1.IsSquash() || !l1.Unexpanded || len(l1.Children) != 0 {
+		t.Fatalf("l1: Type=%v Unexpanded=%v Children=%d", l1.Type, l1.Unexpanded, len(l1.Children))
+	}
+	if src.reads["l1"] != 0 {
+		t.Errorf("stub note was read %d times", src.reads["l1"])
+	}
+	if out := NewVisualizer().Visualize(node); !strings.Contains(out, "l1 [SQUASH]  (not expanded)") {
+		t.Errorf("output: %q", out)
+	}
+
+	if err := b.Expand(context.Background(), l1); err != nil {
+		t.Fatalf("Expand: %v", err)
+	}
+	if l1.Unexpanded || len(l1.Children) != 1 || !l1.Children[0].Unexpanded {
+		t.Fatalf("after Expand: l1.Unexpanded=%v children=%+v", l1.Unexpanded, l1.Children)
+	}
+}
+
+func TestBuilder_LazyExpandsOneLevel(t *testing.T) {
+	mock := newMockNotesSource()
+	mock.addCommit("base")
+	mock.addCommit("leaf")
+	mock.addSquash("inner", "base", []string{"leaf"})
+	mock.addSquash("root", "base", []string{"inner"})
+
+	b := NewBuilder(mock).WithOptions(Options{Lazy: true})
+	node, err := b.BuildTree(context.Background(), "root")
+	if err != nil {
+		t.Fatalf("BuildTree: %v", err)
+	}
+	inner := node.Children[0]
+	if !inner.Unexpanded {
+		t.Fatal("inner should be a stub")
+	}
+	if err := b.Expand(context.Background(), inner); err != nil {
+		t.Fatalf("Expand: %v", err)
+	}
+	if len(inner.Children) != 1 || !inner.Children[0].IsLeaf() {
+		t.Fatalf("inner.Children: %+v", inner.Children)
+	}
+	if len(inner.Parents) != 1 || inner.Parents[0] != node {
+		t.Errorf("inner.Parents: %+v", inner.Parents)
+	}
+}
+
+// cancellingNotesSource cancels the build when a given note is read.
+type cancellingNotesSource struct {
+	*mockNotesSource
+	at     string
+	cancel context.CancelFunc
+}
+
+func (c *cancellingNotesSource) ReadMetadata(ctx context.Context, commitHash string) (*metadata.SquashMetadata, error) {
+	if commitHash == c.at {
+		c.cancel()
+		return nil, ctx.Err()
+	}
+	return c.mockNotesSource.ReadMetadata(ctx, commitHash)
+}
+
+func TestBuilder_CancelledContext(t *testing.T) {
+	mock := newMockNotesSource()
+	mock.addCommit("base")
+	mock.addCommit("leaf")
+	mock.addSquash("inner", "base", []string{"leaf"})
+	mock.addSquash("root", "base", []string{"inner"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	src := &cancellingNotesSource{mockNotesSource: mock, at: "inner", cancel: cancel}
+	// Without the context check the failed read would become an invalid node.
+	if _, err := NewBuilder(src).BuildTree(ctx, "root"); !errors.Is(err, context.Canceled) {
+		t.Errorf("BuildTree cancelled mid-build: got %v, want context.Canceled", err)
+	}
+	if _, err := NewBuilder(mock).BuildTree(ctx, "root"); !errors.Is(err, context.Canceled) {
+		t.Errorf("BuildTree with cancelled context: got %v, want context.Canceled", err)
+	}
+}
+
+func TestBuilder_ChildParentsFormBranchTopology(t *testing.T) {
+	mock := newMockNotesSource()
+	for _, h := range []string{"base", "main1", "a", "m", "b"} {
+		mock.addCommit(h)
+	}
+	// a, then a merge of main1 into the branch, then b on top of the merge.
+	mock.addSquash("root", "base", []string{"a", "m", "b"})
+	children := mock.metadata["root"].Children
+	children[0].Parents = []string{"base"}
+	children[1].Parents = []string{"a", "main1"}
+	children[2].Parents = []string{"m"}
+
+	node, err := NewBuilder(mock).BuildTree(context.Background(), "root")
+	if err != nil {
+		t.Fatalf("BuildTree: %v", err)
+	}
+	want := [][]string{nil, {"a"}, {"m"}}
+	for i, w := range want {
+		var got []string
+		for _, p := range node.ChildParents(i) {
+			got = append(got, p.Hash)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(w) {
+			t.Errorf("ChildParents(%d) = %v, want %v", i, got, w)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package tree
+
+import (
+	"encoding/json"
+
+	"github.com/widefix/squash-tree/internal/metadata"
+)
+
+// JSONTree is the JSON form of a composition DAG. Every commit appears once in
+// Nodes, in depth-first order from Root; edges refer to nodes by ID (the commit hash),
+// so a commit shared by several squashes is listed once with several parents.
+type JSONTree struct {
+	Root  string     `json:"root"`
+	Nodes []JSONNode `json:"nodes"`
+}
+
+type JSONNode struct {
+	ID       string                   `json:"id"`
+	Type     string                   `json:"type"`
+	Message  string                   `json:"message,omitempty"`
+	Children []string                 `json:"children,omitempty"`
+	Parents  []string                 `json:"parents,omitempty"`
+	Metadata *metadata.SquashMetadata `json:"metadata,omitempty"`
+	Error    string                   `json:"error,omitempty"`
+	// Unexpanded marks a squash beyond the requested depth; its children are not listed.
+	Unexpanded bool `json:"unexpanded,omitempty"`
+}
+
+func NewJSONTree(root *Node) *JSONTree {
+	t := &JSONTree{Root: root.Hash, Nodes: []JSONNode{}}
+	seen := make(map[*Node]bool)
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		if seen[n] {
+			return
+		}
+		seen[n] = true
+		jn := JSONNode{
+			ID:         n.Hash,
+			Type:       n.Type.String(),
+			Message:    n.Message,
+			Metadata:   n.Metadata,
+			Unexpanded: n.Unexpanded,
+		}
+		for _, c := range n.Children {
+			jn.Children = append(jn.Children, c.Hash)
+		}
+		for _, p := range n.Parents {
+			jn.Parents = append(jn.Parents, p.Hash)
+		}
+		if n.Err != nil {
+			jn.Error = n.Err.Error()
+		}
+		t.Nodes = append(t.Nodes, jn)
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	walk(root)
+	return t
+}
+
+func EncodeJSON(root *Node) ([]byte, error) {
+	return json.MarshalIndent(NewJSONTree(root), "", "  ")
+}
@@ -0,0 +1,27 @@
+package tree
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEncodeJSON_SharedNodeListedOnce(t *testing.T) {
+	data, err := EncodeJSON(sharedTree())
+	if err != nil {
+		t.Fatalf("EncodeJSON: %v", err)
+	}
+	var got JSONTree
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if got.Root != "root" || len(got.Nodes) != 5 {
+		t.Fatalf("JSONTree: root=%q nodes=%d", got.Root, len(got.Nodes))
+	}
+	for _, n := range got.Nodes {
+		if n.ID == "shared" {
+			if n.Type != "squash" || len(n.Parents) != 2 || len(n.Children) != 1 || n.Children[0] != "leaf" {
+				t.Errorf("shared: %+v", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package tree
+
+import (
+	"context"
+	"fmt"
+)
+
+// FindRoots returns the commits of annotated (commits with squash notes) that are not
+// recorded as a child of any other annotated commit, in the order given. Notes that
+// cannot be read are treated as roots so they still show up.
+func FindRoots(ctx context.Context, src NotesSource, annotated []string) ([]string, error) {
+	isChild := make(map[string]bool)
+	for _, h := range annotated {
+		meta, err := src.ReadMetadata(ctx, h)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err != nil || meta == nil {
+			continue
+		}
+		for _, c := range meta.Children {
+			isChild[c.Hash] = true
+		}
+	}
+	var roots []string
+	for _, h := range annotated {
+		if !isChild[h] {
+			roots = append(roots, h)
+		}
+	}
+	if len(roots) == 0 && len(annotated) > 0 {
+		return nil, fmt.Errorf("no squash roots found: every annotated commit is a child of another")
+	}
+	return roots, nil
+}
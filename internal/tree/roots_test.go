@@ -0,0 +1,29 @@
+package tree
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFindRoots(t *testing.T) {
+	mock := newMockNotesSource()
+	mock.addCommit("base")
+	mock.addCommit("c1")
+	mock.addSquash("inner", "base", []string{"c1"})
+	mock.addSquash("outer", "base", []string{"inner"})
+	mock.addSquash("other", "base", []string{"c1"})
+
+	roots, err := FindRoots(context.Background(), mock, []string{"inner", "outer", "other"})
+	if err != nil {
+		t.Fatalf("FindRoots: %v", err)
+	}
+	if len(roots) != 2 || roots[0] != "outer" || roots[1] != "other" {
+		t.Errorf("roots = %v, want [outer other]", roots)
+	}
+
+	mock.addSquash("a", "base", []string{"b"})
+	mock.addSquash("b", "base", []string{"a"})
+	if _, err := FindRoots(context.Background(), mock, []string{"a", "b"}); err == nil {
+		t.Error("FindRoots(cycle only): expected error")
+	}
+}
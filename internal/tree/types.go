@@ -1,21 +1,51 @@
 package tree
 
-import "squash-tree/internal/metadata"
+import "github.com/widefix/squash-tree/internal/metadata"
 
 type NodeType int
 
 const (
 	NodeTypeLeaf NodeType = iota
 	NodeTypeSquash
+	// NodeTypeUnreadable is a squash whose note uses a spec major version this build cannot read.
+	NodeTypeUnreadable
+	// NodeTypeMissing is a recorded child commit that no longer exists in the repository.
+	NodeTypeMissing
+	// NodeTypeInvalid is a commit whose squash note could not be parsed or validated.
+	NodeTypeInvalid
 )
 
+func (t NodeType) String() string {
+	switch t {
+	case NodeTypeLeaf:
+		return "leaf"
+	case NodeTypeSquash:
+		return "squash"
+	case NodeTypeUnreadable:
+		return "unreadable"
+	case NodeTypeMissing:
+		return "missing"
+	case NodeTypeInvalid:
+		return "invalid"
+	}
+	return "unknown"
+}
+
+// Node is a vertex of the composition DAG. A commit that is a child of several
+// squashes is a single Node listed in each parent's Children and with all of
+// those squashes in Parents.
 type Node struct {
 	Hash     string
 	Type     NodeType
 	Message  string
 	Metadata *metadata.SquashMetadata
 	Children []*Node
+	Parents  []*Node // logical parents: squashes that list this commit as a child
 	Visited  bool
+	// Unexpanded marks a squash stub beyond Options.MaxDepth: its note has not been
+	// read and Children is empty until Builder.Expand is called.
+	Unexpanded bool
+	Err        error // why the node could not be expanded, for NodeTypeUnreadable and NodeTypeInvalid
 }
 
 func (n *Node) IsSquash() bool {
@@ -25,3 +55,62 @@ func (n *Node) IsSquash() bool {
 func (n *Node) IsLeaf() bool {
 	return n.Type == NodeTypeLeaf
 }
+
+func (n *Node) IsUnreadable() bool {
+	return n.Type == NodeTypeUnreadable
+}
+
+func (n *Node) IsMissing() bool {
+	return n.Type == NodeTypeMissing
+}
+
+func (n *Node) IsInvalid() bool {
+	return n.Type == NodeTypeInvalid
+}
+
+// ChildAction returns the rebase action recorded for n.Children[i], or "" if none.
+func (n *Node) ChildAction(i int) string {
+	if c := n.childCommit(i); c != nil {
+		return c.Action
+	}
+	return ""
+}
+
+// ChildParents returns the children of n that are git parents of n.Children[i], the
+// edges of the branch topology recorded for squashes of non-linear history. It is empty
+// when no parents were recorded or all of them lie outside the squash, such as the base.
+func (n *Node) ChildParents(i int) []*Node {
+	c := n.childCommit(i)
+	if c == nil {
+		return nil
+	}
+	var parents []*Node
+	for _, p := range c.Parents {
+		for _, sibling := range n.Children {
+			if sibling.Hash == p {
+				parents = append(parents, sibling)
+				break
+			}
+		}
+	}
+	return parents
+}
+
+// childCommit returns the note's entry for n.Children[i], or nil.
+func (n *Node) childCommit(i int) *metadata.ChildCommit {
+	if n.Metadata == nil || i >= len(n.Children) {
+		return nil
+	}
+	hash := n.Children[i].Hash
+	for j := range n.Metadata.Children {
+		if n.Metadata.Children[j].Hash == hash {
+			return &n.Metadata.Children[j]
+		}
+	}
+	return nil
+}
+
+// IsShared reports whether the commit is a child of more than one squash.
+func (n *Node) IsShared() bool {
+	return len(n.Parents) > 1
+}
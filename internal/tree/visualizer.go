@@ -1,8 +1,11 @@
 package tree
 
 import (
+	"errors"
 	"fmt"
 	"strings"
+
+	"github.com/widefix/squash-tree/internal/metadata"
 )
 
 type Visualizer struct {
@@ -19,11 +22,14 @@ func (v *Visualizer) Visualize(node *Node) string {
 	}
 
 	var builder strings.Builder
-	v.renderNode(&builder, node, "", true, true)
+	v.renderNode(&builder, node, "", "", true, true, make(map[*Node]bool))
 	return builder.String()
 }
 
-func (v *Visualizer) renderNode(builder *strings.Builder, node *Node, prefix string, isLast bool, isRoot bool) {
+// renderNode expands each node once; later occurrences of a shared node are
+// marked "(see above)" instead of being expanded again. action describes how node
+// entered the parent being rendered (see childNote).
+func (v *Visualizer) renderNode(builder *strings.Builder, node *Node, action string, prefix string, isLast bool, isRoot bool, seen map[*Node]bool) {
 	var connector string
 	if isRoot {
 		connector = ""
@@ -36,17 +42,32 @@ func (v *Visualizer) renderNode(builder *strings.Builder, node *Node, prefix str
 	var label string
 	if node.IsSquash() {
 		label = fmt.Sprintf("%s [SQUASH]", node.Hash)
+	} else if node.IsUnreadable() || node.IsMissing() || node.IsInvalid() {
+		label = damagedLabel(node)
 	} else {
 		label = fmt.Sprintf("%s [LEAF]", node.Hash)
 	}
+	if action != "" {
+		label = fmt.Sprintf("%s (%s)", label, action)
+	}
 	if node.Message != "" {
 		label = fmt.Sprintf("%s  %s", label, node.Message)
 	}
+	repeated := seen[node]
+	seen[node] = true
+	if repeated {
+		label += "  (see above)"
+	} else if node.Unexpanded {
+		label += "  (not expanded)"
+	}
 
 	builder.WriteString(prefix)
 	builder.WriteString(connector)
 	builder.WriteString(label)
 	builder.WriteString("\n")
+	if repeated {
+		return
+	}
 
 	var childPrefix string
 	if isRoot {
@@ -59,7 +80,7 @@ func (v *Visualizer) renderNode(builder *strings.Builder, node *Node, prefix str
 
 	for i, child := range node.Children {
 		isLastChild := i == len(node.Children)-1
-		v.renderNode(builder, child, childPrefix, isLastChild, false)
+		v.renderNode(builder, child, childNote(node, i), childPrefix, isLastChild, false, seen)
 	}
 }
 
@@ -71,11 +92,11 @@ func (v *Visualizer) VisualizeWithDetails(node *Node) string {
 	var builder strings.Builder
 	builder.WriteString("Squash Tree:\n")
 	builder.WriteString("============\n\n")
-	v.renderNodeWithDetails(&builder, node, "", true, true)
+	v.renderNodeWithDetails(&builder, node, "", "", true, true, make(map[*Node]bool))
 	return builder.String()
 }
 
-func (v *Visualizer) renderNodeWithDetails(builder *strings.Builder, node *Node, prefix string, isLast bool, isRoot bool) {
+func (v *Visualizer) renderNodeWithDetails(builder *strings.Builder, node *Node, action string, prefix string, isLast bool, isRoot bool, seen map[*Node]bool) {
 	var connector string
 	if isRoot {
 		connector = ""
@@ -91,17 +112,35 @@ func (v *Visualizer) renderNodeWithDetails(builder *strings.Builder, node *Node,
 			node.Hash,
 			node.Metadata.Base,
 			node.Metadata.Strategy)
+	} else if node.IsSquash() {
+		// An unexpanded stub: its note has not been read.
+		label = fmt.Sprintf("%s [SQUASH]", node.Hash)
+	} else if node.IsUnreadable() || node.IsMissing() || node.IsInvalid() {
+		label = damagedLabel(node)
 	} else {
 		label = fmt.Sprintf("%s [LEAF]", node.Hash)
 	}
+	if action != "" {
+		label = fmt.Sprintf("%s (%s)", label, action)
+	}
 	if node.Message != "" {
 		label = fmt.Sprintf("%s  %s", label, node.Message)
 	}
+	repeated := seen[node]
+	seen[node] = true
+	if repeated {
+		label += "  (see above)"
+	} else if node.Unexpanded {
+		label += "  (not expanded)"
+	}
 
 	builder.WriteString(prefix)
 	builder.WriteString(connector)
 	builder.WriteString(label)
 	builder.WriteString("\n")
+	if repeated {
+		return
+	}
 
 	var childPrefix string
 	if isRoot {
@@ -114,6 +153,41 @@ func (v *Visualizer) renderNodeWithDetails(builder *strings.Builder, node *Node,
 
 	for i, child := range node.Children {
 		isLastChild := i == len(node.Children)-1
-		v.renderNodeWithDetails(builder, child, childPrefix, isLastChild, false)
+		v.renderNodeWithDetails(builder, child, childNote(node, i), childPrefix, isLastChild, false, seen)
+	}
+}
+
+// childNote returns the rebase action recorded for node.Children[i] and, where the
+// recorded branch topology departs from the listed order (a merge, or a child that
+// does not build on the one before it), the child's parents.
+func childNote(node *Node, i int) string {
+	var notes []string
+	if action := node.ChildAction(i); action != "" {
+		notes = append(notes, action)
+	}
+	if c := node.childCommit(i); c != nil && len(c.Parents) > 0 {
+		inSet := node.ChildParents(i)
+		linear := len(c.Parents) == 1 &&
+			(i == 0 && len(inSet) == 0 || i > 0 && len(inSet) == 1 && inSet[0] == node.Children[i-1])
+		if !linear {
+			notes = append(notes, "parents: "+strings.Join(c.Parents, ", "))
+		}
+	}
+	return strings.Join(notes, "; ")
+}
+
+// damagedLabel labels nodes the builder could not expand; the recorded child message,
+// if any, is appended by the caller.
+func damagedLabel(node *Node) string {
+	switch node.Type {
+	case NodeTypeMissing:
+		return fmt.Sprintf("%s [MISSING] (commit not found)", node.Hash)
+	case NodeTypeInvalid:
+		return fmt.Sprintf("%s [INVALID SQUASH] (%v)", node.Hash, node.Err)
+	}
+	var specErr *metadata.UnsupportedSpecError
+	if errors.As(node.Err, &specErr) {
+		return fmt.Sprintf("%s [UNREADABLE SQUASH] (note uses %s; upgrade git-squash-tree to expand)", node.Hash, specErr.Spec)
 	}
+	return fmt.Sprintf("%s [UNREADABLE SQUASH]", node.Hash)
 }
@@ -1,7 +1,7 @@
 package tree
 
 import (
-	"squash-tree/internal/metadata"
+	"github.com/widefix/squash-tree/internal/metadata"
 	"strings"
 	"testing"
 )
@@ -95,3 +95,84 @@ func TestVisualize_NestedTree(t *testing.T) {
 		t.Errorf("output missing nodes: %q", out)
 	}
 }
+
+func sharedTree() *Node {
+	leaf := &Node{Hash: "leaf", Type: NodeTypeLeaf}
+	shared := &Node{Hash: "shared", Type: NodeTypeSquash, Children: []*Node{leaf}}
+	leaf.Parents = []*Node{shared}
+	a := &Node{Hash: "a", Type: NodeTypeSquash, Children: []*Node{shared}}
+	b := &Node{Hash: "b", Type: NodeTypeSquash, Children: []*Node{shared}}
+	shared.Parents = []*Node{a, b}
+	root := &Node{Hash: "root", Type: NodeTypeSquash, Children: []*Node{a, b}}
+	a.Parents = []*Node{root}
+	b.Parents = []*Node{root}
+	return root
+}
+
+func TestVisualize_SharedNodeExpandedOnce(t *testing.T) {
+	out := NewVisualizer().Visualize(sharedTree())
+	if strings.Count(out, "leaf [LEAF]") != 1 {
+		t.Errorf("shared subtree expanded more than once: %q", out)
+	}
+	if strings.Count(out, "shared [SQUASH]") != 2 || strings.Count(out, "(see above)") != 1 {
+		t.Errorf("second occurrence not marked: %q", out)
+	}
+}
+
+func TestVisualize_ShowsRebaseAction(t *testing.T) {
+	root := &Node{
+		Hash: "root",
+		Type: NodeTypeSquash,
+		Metadata: &metadata.SquashMetadata{Root: "root", Base: "base", Strategy: "rebase", Children: []metadata.ChildCommit{
+			{Hash: "c1", Order: 1, Action: metadata.ActionPick},
+			{Hash: "c2", Order: 2, Action: metadata.ActionFixup},
+		}},
+		Children: []*Node{
+			{Hash: "c1", Type: NodeTypeLeaf, Message: "Add parser"},
+			{Hash: "c2", Type: NodeTypeLeaf, Message: "fix typo"},
+		},
+	}
+	v := NewVisualizer()
+	for _, out := range []string{v.Visualize(root), v.VisualizeWithDetails(root)} {
+		if !strings.Contains(out, "c1 [LEAF] (pick)  Add parser") || !strings.Contains(out, "c2 [LEAF] (fixup)  fix typo") {
+			t.Errorf("output missing actions: %q", out)
+		}
+	}
+}
+
+func TestVisualize_ShowsBranchTopology(t *testing.T) {
+	root := &Node{
+		Hash: "root",
+		Type: NodeTypeSquash,
+		Metadata: &metadata.SquashMetadata{Root: "root", Base: "base", Strategy: "merge", Children: []metadata.ChildCommit{
+			{Hash: "a", Order: 1, Parents: []string{"base"}},
+			{Hash: "m", Order: 2, Parents: []string{"a", "main1"}},
+			{Hash: "b", Order: 3, Parents: []string{"m"}},
+		}},
+		Children: []*Node{
+			{Hash: "a", Type: NodeTypeLeaf},
+			{Hash: "m", Type: NodeTypeLeaf},
+			{Hash: "b", Type: NodeTypeLeaf},
+		},
+	}
+	out := NewVisualizer().Visualize(root)
+	if !strings.Contains(out, "m [LEAF] (parents: a, main1)") {
+		t.Errorf("merge child not marked: %q", out)
+	}
+	if strings.Contains(out, "a [LEAF] (") || strings.Contains(out, "b [LEAF] (") {
+		t.Errorf("linear children annotated: %q", out)
+	}
+}
+
+func TestVisualizeWithDetails_UnexpandedSquash(t *testing.T) {
+	root := &Node{
+		Hash:     "root",
+		Type:     NodeTypeSquash,
+		Metadata: &metadata.SquashMetadata{Root: "root", Base: "base", Strategy: "manual", Children: []metadata.ChildCommit{{Hash: "inner", Order: 1}}},
+		Children: []*Node{{Hash: "inner", Type: NodeTypeSquash, Unexpanded: true, Message: "Nested squash"}},
+	}
+	out := NewVisualizer().VisualizeWithDetails(root)
+	if !strings.Contains(out, "inner [SQUASH]  Nested squash  (not expanded)") {
+		t.Errorf("unexpanded squash not marked: %q", out)
+	}
+}
@@ -0,0 +1,12 @@
+// Package squashtree is the public Go API of git-squash-tree: reading squash trees,
+// inspecting and recording squash metadata, and unsquashing, without shelling out to
+// the CLI.
+//
+//	r, err := squashtree.Open(".")
+//	root, err := r.Tree(ctx, "HEAD", squashtree.TreeOptions{})
+//
+// Compatibility: this package follows semantic versioning with the module's release
+// tags. Within a major version, exported identifiers are not removed or changed
+// incompatibly; new fields, methods and NodeType values may be added. Everything under
+// internal/ carries no such guarantee and must not be relied upon.
+package squashtree
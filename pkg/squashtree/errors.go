@@ -0,0 +1,23 @@
+package squashtree
+
+import (
+	"errors"
+
+	"github.com/widefix/squash-tree/internal/metadata"
+)
+
+// Errors returned by Repo methods are wrapped; match them with errors.Is.
+var (
+	// ErrNotRepository is returned by Open when the path is not inside a git repository.
+	ErrNotRepository = errors.New("not a git repository")
+	// ErrNotFound is returned when a commit or ref cannot be resolved.
+	ErrNotFound = errors.New("commit not found")
+	// ErrNoMetadata is returned when a commit has no squash note.
+	ErrNoMetadata = errors.New("no squash metadata")
+	// ErrAlreadyRecorded is returned by Record when the squash already has a note.
+	ErrAlreadyRecorded = errors.New("squash metadata already recorded")
+	// ErrInvalidMetadata is returned for notes or record options that fail validation.
+	ErrInvalidMetadata = errors.New("invalid squash metadata")
+	// ErrUnsupportedSpec is returned for notes whose spec major version this build cannot read.
+	ErrUnsupportedSpec = metadata.ErrUnsupportedSpec
+)
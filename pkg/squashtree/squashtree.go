@@ -0,0 +1,240 @@
+package squashtree
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/widefix/squash-tree/internal/git"
+	"github.com/widefix/squash-tree/internal/metadata"
+	"github.com/widefix/squash-tree/internal/repo"
+	"github.com/widefix/squash-tree/internal/tree"
+)
+
+// Strategies accepted by RecordOptions.Strategy.
+const (
+	StrategyAuto   = "auto"
+	StrategyManual = "manual"
+)
+
+// Repo is a git repository opened for squash-tree operations and is safe for
+// concurrent use. Repos of the same repository share one cached git backend; with
+// the native backend (squashTree.backend = native) it keeps the repository's pack
+// files open for the life of the process.
+type Repo struct {
+	path string
+}
+
+// Open finds the git repository containing path.
+func Open(path string) (*Repo, error) {
+	root, err := repo.FindGitRepo(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, ErrNotRepository)
+	}
+	return &Repo{path: root}, nil
+}
+
+// Path returns the top-level directory of the repository.
+func (r *Repo) Path() string {
+	return r.path
+}
+
+// TreeOptions controls Tree.
+type TreeOptions struct {
+	// Strict fails on missing children and unreadable notes instead of returning
+	// NodeMissing, NodeInvalid and NodeUnreadable nodes.
+	Strict bool
+	// MaxDepth stops expansion that many levels below the root; zero means unlimited.
+	MaxDepth int
+}
+
+// Tree returns the squash tree rooted at commit.
+func (r *Repo) Tree(ctx context.Context, commit string, opts TreeOptions) (*Node, error) {
+	hash, err := r.resolve(ctx, commit)
+	if err != nil {
+		return nil, err
+	}
+	src := git.NewNotesReader(r.path)
+	root, err := tree.NewBuilder(src).WithOptions(tree.Options{Strict: opts.Strict, MaxDepth: opts.MaxDepth}).BuildTree(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	return newNode(root, make(map[*tree.Node]*Node)), nil
+}
+
+// Inspect returns the squash metadata recorded on commit.
+func (r *Repo) Inspect(ctx context.Context, commit string) (*Metadata, error) {
+	hash, err := r.resolve(ctx, commit)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := r.read(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	return newMetadata(meta), nil
+}
+
+// RecordOptions describes a squash to record. Squash, Base and Children accept any
+// revision git understands; Children are in squash order.
+type RecordOptions struct {
+	Squash   string
+	Base     string
+	Children []string
+	Strategy string // StrategyAuto or StrategyManual; empty means StrategyManual
+	// Replace overwrites an existing note instead of failing with ErrAlreadyRecorded;
+	// the previous version stays in the history of the notes ref.
+	Replace bool
+
+	// Setting V2 or any of the fields below writes a squash-tree/v2 note; Author and
+	// Committer default to those of the squash commit.
+	V2        bool
+	Author    *Identity
+	Committer *Identity
+	Refs      []Reference
+	Labels    map[string]string
+	Tool      *Tool
+}
+
+func (o RecordOptions) v2() bool {
+	return o.V2 || o.Author != nil || o.Committer != nil || len(o.Refs) > 0 || len(o.Labels) > 0 || o.Tool != nil
+}
+
+// Record attaches squash metadata to opts.Squash and preserves its children, returning
+// the metadata written. It fails with ErrAlreadyRecorded if the squash already has a note.
+func (r *Repo) Record(ctx context.Context, opts RecordOptions) (*Metadata, error) {
+	root, err := r.resolve(ctx, opts.Squash)
+	if err != nil {
+		return nil, err
+	}
+	base, err := r.resolve(ctx, opts.Base)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.Children) == 0 {
+		return nil, fmt.Errorf("at least one child commit required: %w", ErrInvalidMetadata)
+	}
+	children := make([]string, len(opts.Children))
+	for i, c := range opts.Children {
+		if children[i], err = r.resolve(ctx, c); err != nil {
+			return nil, err
+		}
+	}
+	if !opts.Replace && git.NewNotesReader(r.path).HasMetadata(ctx, root) {
+		return nil, fmt.Errorf("%s: %w", root, ErrAlreadyRecorded)
+	}
+	strategy := opts.Strategy
+	if strategy == "" {
+		strategy = StrategyManual
+	}
+
+	meta, err := git.BuildMetadata(ctx, r.path, root, base, children, strategy)
+	if err != nil {
+		return nil, err
+	}
+	if opts.v2() {
+		fields := metadata.V2Fields{Labels: opts.Labels}
+		if opts.Author != nil {
+			fields.Author = opts.Author.internal()
+		}
+		if opts.Committer != nil {
+			fields.Committer = opts.Committer.internal()
+		}
+		for _, ref := range opts.Refs {
+			fields.Refs = append(fields.Refs, metadata.Reference{Kind: ref.Kind, ID: ref.ID, URL: ref.URL})
+		}
+		if opts.Tool != nil {
+			fields.Tool = &metadata.ToolInfo{Name: opts.Tool.Name, Version: opts.Tool.Version}
+		}
+		if err := git.UpgradeToV2(ctx, r.path, meta, fields); err != nil {
+			return nil, err
+		}
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := metadata.Parse(data); err != nil {
+		return nil, fmt.Errorf("%v: %w", err, ErrInvalidMetadata)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	write := git.WriteSquashMetadata
+	if opts.Replace {
+		write = git.ReplaceSquashMetadata
+	}
+	if err := write(ctx, r.path, meta); err != nil {
+		return nil, err
+	}
+	return newMetadata(meta), nil
+}
+
+// Remove deletes the squash note on commit together with the refs preserving its
+// children. It fails with ErrNoMetadata if the commit has no note.
+func (r *Repo) Remove(ctx context.Context, commit string) error {
+	hash, err := r.resolve(ctx, commit)
+	if err != nil {
+		return err
+	}
+	if !git.NewNotesReader(r.path).HasMetadata(ctx, hash) {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%s: %w", hash, ErrNoMetadata)
+	}
+	return git.RemoveSquashMetadata(ctx, r.path, hash)
+}
+
+// List returns the short hashes of all commits that carry a squash note.
+func (r *Repo) List(ctx context.Context) ([]string, error) {
+	return git.NewNotesReader(r.path).ListAnnotated(ctx)
+}
+
+// Unsquash recreates the children of the squash commit on a new branch starting at its
+// recorded base, without touching the current checkout. An empty branch defaults to
+// "unsquash/<commit>". It returns the name of the created branch.
+func (r *Repo) Unsquash(ctx context.Context, commit, branch string) (string, error) {
+	hash, err := r.resolve(ctx, commit)
+	if err != nil {
+		return "", err
+	}
+	if _, err := r.read(ctx, hash); err != nil {
+		return "", err
+	}
+	return git.Unsquash(ctx, r.path, hash, branch)
+}
+
+func (r *Repo) resolve(ctx context.Context, ref string) (string, error) {
+	hash, err := repo.ResolveCommitHash(ctx, r.path, ref+"^{commit}")
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("%q: %w", ref, ErrNotFound)
+	}
+	return hash, nil
+}
+
+func (r *Repo) read(ctx context.Context, hash string) (*metadata.SquashMetadata, error) {
+	nr := git.NewNotesReader(r.path)
+	if !nr.HasMetadata(ctx, hash) {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%s: %w", hash, ErrNoMetadata)
+	}
+	meta, err := nr.ReadMetadata(ctx, hash)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if errors.Is(err, ErrUnsupportedSpec) {
+			return nil, err
+		}
+		// The note exists, so a read failure means it does not parse or validate.
+		return nil, fmt.Errorf("%v: %w", err, ErrInvalidMetadata)
+	}
+	return meta, nil
+}
@@ -0,0 +1,244 @@
+package squashtree
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/widefix/squash-tree/internal/metadata"
+	"github.com/widefix/squash-tree/internal/tree"
+)
+
+func requireGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available:", err)
+	}
+}
+
+func run(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v %s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// squashRepo creates base, two children and a squash of them; it returns the opened
+// repo and the short hashes.
+func squashRepo(t *testing.T) (r *Repo, base, c1, c2, squash string) {
+	t.Helper()
+	requireGit(t)
+	dir := t.TempDir()
+	run(t, dir, "init")
+	run(t, dir, "config", "user.email", "test@test")
+	run(t, dir, "config", "user.name", "Test")
+	run(t, dir, "config", "commit.gpgsign", "false")
+	commit := func(msg, content string) string {
+		if err := os.WriteFile(filepath.Join(dir, "f.txt"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		run(t, dir, "add", "f.txt")
+		run(t, dir, "commit", "-m", msg)
+		return run(t, dir, "rev-parse", "--short", "HEAD")
+	}
+	base = commit("base", "0")
+	c1 = commit("child one", "1")
+	c2 = commit("child two", "2")
+	run(t, dir, "reset", "--soft", base)
+	run(t, dir, "commit", "-m", "squashed")
+	squash = run(t, dir, "rev-parse", "--short", "HEAD")
+
+	r, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return r, base, c1, c2, squash
+}
+
+func TestOpen_NotRepository(t *testing.T) {
+	if _, err := Open(t.TempDir()); !errors.Is(err, ErrNotRepository) {
+		t.Errorf("Open: got %v, want ErrNotRepository", err)
+	}
+}
+
+func TestRecordInspectTreeList(t *testing.T) {
+	r, base, c1, c2, squash := squashRepo(t)
+	ctx := context.Background()
+
+	if _, err := r.Inspect(ctx, squash); !errors.Is(err, ErrNoMetadata) {
+		t.Errorf("Inspect before Record: got %v, want ErrNoMetadata", err)
+	}
+
+	meta, err := r.Record(ctx, RecordOptions{
+		Squash:   "HEAD",
+		Base:     base,
+		Children: []string{c1, c2},
+		Refs:     []Reference{{Kind: "pr", ID: "42"}},
+	})
+	if err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if meta.Root != squash || meta.Strategy != StrategyManual || meta.Spec != "squash-tree/v2" {
+		t.Errorf("Record returned %+v", meta)
+	}
+	if meta.Author == nil || meta.Author.Name != "Test" {
+		t.Errorf("Author = %+v, want default from commit", meta.Author)
+	}
+
+	if _, err := r.Record(ctx, RecordOptions{Squash: squash, Base: base, Children: []string{c1}}); !errors.Is(err, ErrAlreadyRecorded) {
+		t.Errorf("second Record: got %v, want ErrAlreadyRecorded", err)
+	}
+
+	got, err := r.Inspect(ctx, "HEAD")
+	if err != nil {
+		t.Fatalf("Inspect: %v", err)
+	}
+	if len(got.Children) != 2 || got.Children[0].Hash != c1 || got.Children[1].Message != "child two" {
+		t.Errorf("Children = %+v", got.Children)
+	}
+	if len(got.Refs) != 1 || got.Refs[0].ID != "42" {
+		t.Errorf("Refs = %+v", got.Refs)
+	}
+
+	root, err := r.Tree(ctx, squash, TreeOptions{})
+	if err != nil {
+		t.Fatalf("Tree: %v", err)
+	}
+	if root.Type != NodeSquash || len(root.Children) != 2 || root.Children[0].Type != NodeLeaf {
+		t.Fatalf("Tree = %+v", root)
+	}
+	if p := root.Children[1].Parents; len(p) != 1 || p[0] != root {
+		t.Errorf("child Parents = %v", p)
+	}
+
+	list, err := r.List(ctx)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 1 || list[0] != squash {
+		t.Errorf("List = %v", list)
+	}
+}
+
+func TestRecordReplaceAndRemove(t *testing.T) {
+	r, base, c1, c2, squash := squashRepo(t)
+	ctx := context.Background()
+
+	if err := r.Remove(ctx, squash); !errors.Is(err, ErrNoMetadata) {
+		t.Errorf("Remove before Record: got %v, want ErrNoMetadata", err)
+	}
+	if _, err := r.Record(ctx, RecordOptions{Squash: squash, Base: base, Children: []string{c1, c2}}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	meta, err := r.Record(ctx, RecordOptions{Squash: squash, Base: base, Children: []string{c2}, Replace: true})
+	if err != nil {
+		t.Fatalf("Record with Replace: %v", err)
+	}
+	if got, err := r.Inspect(ctx, squash); err != nil || len(got.Children) != 1 || got.Children[0].Hash != meta.Children[0].Hash {
+		t.Errorf("Inspect after Replace = %+v, %v", got, err)
+	}
+	if err := r.Remove(ctx, squash); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := r.Inspect(ctx, squash); !errors.Is(err, ErrNoMetadata) {
+		t.Errorf("Inspect after Remove: got %v, want ErrNoMetadata", err)
+	}
+}
+
+func TestRecord_Errors(t *testing.T) {
+	r, base, c1, _, squash := squashRepo(t)
+	ctx := context.Background()
+
+	if _, err := r.Record(ctx, RecordOptions{Squash: squash, Base: base, Children: []string{"nope"}}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown child: got %v, want ErrNotFound", err)
+	}
+	if _, err := r.Record(ctx, RecordOptions{Squash: squash, Base: base}); !errors.Is(err, ErrInvalidMetadata) {
+		t.Errorf("no children: got %v, want ErrInvalidMetadata", err)
+	}
+	if _, err := r.Record(ctx, RecordOptions{Squash: squash, Base: base, Children: []string{c1}, Refs: []Reference{{Kind: "pr"}}}); !errors.Is(err, ErrInvalidMetadata) {
+		t.Errorf("bad ref: got %v, want ErrInvalidMetadata", err)
+	}
+	if _, err := r.Inspect(ctx, squash); !errors.Is(err, ErrNoMetadata) {
+		t.Errorf("failed Record wrote a note: %v", err)
+	}
+}
+
+func TestInspect_InvalidAndUnsupported(t *testing.T) {
+	r, _, _, _, squash := squashRepo(t)
+	ctx := context.Background()
+
+	run(t, r.Path(), "notes", "--ref", "refs/notes/squash-tree", "add", "-m", `{"spec":"squash-tree/v9"}`, squash)
+	if _, err := r.Inspect(ctx, squash); !errors.Is(err, ErrUnsupportedSpec) {
+		t.Errorf("v9 note: got %v, want ErrUnsupportedSpec", err)
+	}
+	run(t, r.Path(), "notes", "--ref", "refs/notes/squash-tree", "add", "-f", "-m", `{"spec":"squash-tree/v1"}`, squash)
+	if _, err := r.Inspect(ctx, squash); !errors.Is(err, ErrInvalidMetadata) {
+		t.Errorf("invalid note: got %v, want ErrInvalidMetadata", err)
+	}
+}
+
+func TestUnsquashAndCancellation(t *testing.T) {
+	r, base, c1, c2, squash := squashRepo(t)
+	ctx := context.Background()
+	if _, err := r.Record(ctx, RecordOptions{Squash: squash, Base: base, Children: []string{c1, c2}}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+
+	branch, err := r.Unsquash(ctx, squash, "")
+	if err != nil {
+		t.Fatalf("Unsquash: %v", err)
+	}
+	if log := run(t, r.Path(), "log", "--format=%s", base+".."+branch); log != "child two\nchild one" {
+		t.Errorf("log = %q", log)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := r.Tree(cancelled, squash, TreeOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Tree with cancelled context: got %v", err)
+	}
+	if _, err := r.Unsquash(cancelled, squash, "other"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Unsquash with cancelled context: got %v", err)
+	}
+}
+
+func TestNewMetadata_CopiesRecordedDetails(t *testing.T) {
+	got := newMetadata(&metadata.SquashMetadata{
+		Spec:          "squash-tree/v1.1",
+		Root:          "r",
+		Base:          "b",
+		Children:      []metadata.ChildCommit{{Hash: "c", Order: 1, Action: metadata.ActionFixup, Parents: []string{"b"}}},
+		RewrittenFrom: "r0",
+		DerivedFrom:   "d0",
+	})
+	if got.RewrittenFrom != "r0" || got.DerivedFrom != "d0" {
+		t.Errorf("RewrittenFrom = %q, DerivedFrom = %q", got.RewrittenFrom, got.DerivedFrom)
+	}
+	if c := got.Children[0]; c.Action != "fixup" || len(c.Parents) != 1 || c.Parents[0] != "b" {
+		t.Errorf("child = %+v", c)
+	}
+}
+
+func TestNewNode_ChildParents(t *testing.T) {
+	a := &tree.Node{Hash: "a", Type: tree.NodeTypeLeaf}
+	m := &tree.Node{Hash: "m", Type: tree.NodeTypeLeaf}
+	root := &tree.Node{Hash: "root", Type: tree.NodeTypeSquash, Children: []*tree.Node{a, m},
+		Metadata: &metadata.SquashMetadata{Root: "root", Base: "base", Children: []metadata.ChildCommit{
+			{Hash: "a", Order: 1, Parents: []string{"base"}},
+			{Hash: "m", Order: 2, Parents: []string{"a", "main1"}},
+		}}}
+	got := newNode(root, make(map[*tree.Node]*Node))
+	if p := got.ChildParents(0); len(p) != 0 {
+		t.Errorf("ChildParents(0) = %v, want none", p)
+	}
+	if p := got.ChildParents(1); len(p) != 1 || p[0] != got.Children[0] {
+		t.Errorf("ChildParents(1) = %v, want the first child", p)
+	}
+}
@@ -0,0 +1,177 @@
+package squashtree
+
+import (
+	"github.com/widefix/squash-tree/internal/metadata"
+	"github.com/widefix/squash-tree/internal/tree"
+)
+
+// NodeType classifies a node of a squash tree.
+type NodeType string
+
+const (
+	NodeLeaf   NodeType = "leaf"
+	NodeSquash NodeType = "squash"
+	// NodeUnreadable is a squash whose note uses a spec major version this build cannot read.
+	NodeUnreadable NodeType = "unreadable"
+	// NodeMissing is a recorded child commit that no longer exists in the repository.
+	NodeMissing NodeType = "missing"
+	// NodeInvalid is a commit whose squash note could not be parsed or validated.
+	NodeInvalid NodeType = "invalid"
+)
+
+// Node is a commit in a squash tree. A commit shared by several squashes is a single
+// *Node listed in each parent's Children and with all of them in Parents.
+type Node struct {
+	Hash     string
+	Type     NodeType
+	Message  string
+	Metadata *Metadata // nil unless Type is NodeSquash
+	Children []*Node
+	Parents  []*Node
+	// Unexpanded marks a squash below TreeOptions.MaxDepth whose children were not loaded.
+	Unexpanded bool
+	// Err explains why a NodeUnreadable or NodeInvalid node could not be expanded.
+	Err error
+
+	childParents [][]*Node
+}
+
+// ChildParents returns the children of n that are git parents of n.Children[i]: the
+// branch topology recorded when the squashed commits include a merge. It is empty when
+// no parents were recorded or all of them lie outside the squash, such as the base.
+func (n *Node) ChildParents(i int) []*Node {
+	if i >= len(n.childParents) {
+		return nil
+	}
+	return n.childParents[i]
+}
+
+// Metadata is the squash note attached to a squash commit.
+type Metadata struct {
+	Spec      string
+	Root      string
+	Base      string
+	Message   string
+	Strategy  string
+	CreatedAt string
+	Children  []Child
+	// RewrittenFrom is the squash commit this one replaced by an amend or rebase.
+	RewrittenFrom string
+	// DerivedFrom is the squash commit this one is a cherry-pick of.
+	DerivedFrom string
+
+	// Set only for squash-tree/v2 notes.
+	Author    *Identity
+	Committer *Identity
+	Refs      []Reference
+	Labels    map[string]string
+	Tool      *Tool
+
+	// Warnings lists compatibility issues found while reading the note.
+	Warnings []string
+}
+
+// Child is one original commit of a squash, in squash order (Order starts at 1).
+type Child struct {
+	Hash    string
+	Order   int
+	Message string
+	// Action is the rebase todo command ("pick", "squash", "fixup", ...) that folded the
+	// child into the squash, if recorded.
+	Action string
+	// Parents are the child's parent commits, recorded when the squashed commits
+	// include a merge; see Node.ChildParents.
+	Parents []string
+}
+
+// Identity is the author or committer of a squash.
+type Identity struct {
+	Name  string
+	Email string
+	Date  string
+}
+
+// Reference links a squash to an external object such as a pull request or ticket.
+type Reference struct {
+	Kind string // "pr" or "ticket"
+	ID   string
+	URL  string
+}
+
+// Tool names the program that recorded a squash.
+type Tool struct {
+	Name    string
+	Version string
+}
+
+func newNode(n *tree.Node, seen map[*tree.Node]*Node) *Node {
+	if out, ok := seen[n]; ok {
+		return out
+	}
+	out := &Node{
+		Hash:       n.Hash,
+		Type:       NodeType(n.Type.String()),
+		Message:    n.Message,
+		Metadata:   newMetadata(n.Metadata),
+		Unexpanded: n.Unexpanded,
+		Err:        n.Err,
+	}
+	seen[n] = out
+	for _, c := range n.Children {
+		child := newNode(c, seen)
+		out.Children = append(out.Children, child)
+		child.Parents = append(child.Parents, out)
+	}
+	for i := range n.Children {
+		var parents []*Node
+		for _, p := range n.ChildParents(i) {
+			parents = append(parents, seen[p])
+		}
+		out.childParents = append(out.childParents, parents)
+	}
+	return out
+}
+
+func newMetadata(m *metadata.SquashMetadata) *Metadata {
+	if m == nil {
+		return nil
+	}
+	out := &Metadata{
+		Spec:          m.Spec,
+		Root:          m.Root,
+		Base:          m.Base,
+		Message:       m.Message,
+		Strategy:      m.Strategy,
+		CreatedAt:     m.CreatedAt,
+		RewrittenFrom: m.RewrittenFrom,
+		DerivedFrom:   m.DerivedFrom,
+		Author:        newIdentity(m.Author),
+		Committer:     newIdentity(m.Committer),
+		Labels:        m.Labels,
+		Warnings:      m.Warnings,
+	}
+	for _, c := range m.Children {
+		out.Children = append(out.Children, Child{Hash: c.Hash, Order: c.Order, Message: c.Message, Action: c.Action, Parents: c.Parents})
+	}
+	for _, r := range m.Refs {
+		out.Refs = append(out.Refs, Reference{Kind: r.Kind, ID: r.ID, URL: r.URL})
+	}
+	if m.Tool != nil {
+		out.Tool = &Tool{Name: m.Tool.Name, Version: m.Tool.Version}
+	}
+	return out
+}
+
+func newIdentity(id *metadata.Identity) *Identity {
+	if id == nil {
+		return nil
+	}
+	return &Identity{Name: id.Name, Email: id.Email, Date: id.Date}
+}
+
+func (id *Identity) internal() *metadata.Identity {
+	if id == nil {
+		return nil
+	}
+	return &metadata.Identity{Name: id.Name, Email: id.Email, Date: id.Date}
+}